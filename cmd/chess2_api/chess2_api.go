@@ -1,13 +1,30 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/CGamesPlay/chess2/pkg/chess2"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/pflag"
+)
+
+var (
+	storeDir  = pflag.String("store", "", "directory to save games in (default: keep games in memory)")
+	armyFiles = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
 )
 
 func parseArmySymbol(value string, param string) (chess2.Army, error) {
@@ -24,6 +41,81 @@ func parseArmySymbol(value string, param string) (chess2.Army, error) {
 	return army, nil
 }
 
+// Creates a game for the armies from the chess960 and stones parameters. The
+// stones, if given, are written as in an EPD, like "33" or "12/9".
+func newGame(white, black chess2.Army, chess960, stones string) (chess2.Game, *int, error) {
+	game, position, err := newGameFromChess960(white, black, chess960)
+	if err != nil || stones == "" {
+		return game, position, err
+	}
+	counts, max, err := chess2.ParseStones(stones)
+	if err == nil {
+		err = game.SetStones(counts[0], counts[1], max)
+	}
+	if err != nil {
+		return chess2.Game{}, nil, fmt.Errorf("stones must be written as in an EPD, like 33 or 12/9, with at most %d stones", chess2.MaxStonesLimit)
+	}
+	return game, position, nil
+}
+
+// Creates a game for the armies from a chess960 parameter, which is either the
+// number of a Chess960 position or "random". Without one, the game starts
+// from the standard position. The position number is returned so that it can
+// be reported to the players.
+func newGameFromChess960(white, black chess2.Army, chess960 string) (chess2.Game, *int, error) {
+	if chess960 == "" {
+		return chess2.GameFromArmies(white, black), nil, nil
+	}
+	var position int
+	if chess960 == "random" {
+		n, err := rand.Int(rand.Reader, big.NewInt(chess2.Chess960Positions))
+		if err != nil {
+			return chess2.Game{}, nil, err
+		}
+		position = int(n.Int64())
+	} else {
+		n, err := strconv.Atoi(chess960)
+		if err != nil || n < 0 || n >= chess2.Chess960Positions {
+			return chess2.Game{}, nil, fmt.Errorf("chess960 must be random or a position from 0 to %d", chess2.Chess960Positions-1)
+		}
+		position = n
+	}
+	game, err := chess2.GameFromChess960(white, black, position)
+	return game, &position, err
+}
+
+// A chess960Param is the chess960 parameter of a JSON request, which is either
+// a position number or the string "random". It holds the parameter as it would
+// be written in a query string.
+type chess960Param string
+
+func (p *chess960Param) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var position int
+	if err := json.Unmarshal(data, &position); err == nil {
+		*p = chess960Param(strconv.Itoa(position))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil || value != "random" {
+		return fmt.Errorf("chess960 must be random or a position from 0 to %d", chess2.Chess960Positions-1)
+	}
+	*p = chess960Param(value)
+	return nil
+}
+
+func parseColor(value string) (chess2.Color, error) {
+	switch value {
+	case "white":
+		return chess2.ColorWhite, nil
+	case "black":
+		return chess2.ColorBlack, nil
+	}
+	return chess2.ColorWhite, fmt.Errorf("color must be white or black")
+}
+
 func formatGame(game chess2.Game) gin.H {
 	response := make(gin.H)
 	response["epd"] = chess2.EncodeEpd(game)
@@ -45,10 +137,468 @@ func formatGame(game chess2.Game) gin.H {
 	case chess2.GameOverDraw:
 		response["winner"] = "draw"
 	}
+	if result := game.Result(); result.Reason != chess2.EndReasonNone {
+		response["reason"] = result.Reason.String()
+	} else {
+		response["reason"] = nil
+	}
+	return response
+}
+
+// A pendingDuel is a move whose duels are still being decided. The bids are
+// secret, so pending moves have to live on the server rather than being passed
+// back and forth in the requests. Pending duels in hosted games are also saved
+// in the session, and their IDs start with the session ID so that they can be
+// found again after the server restarts.
+type pendingDuel struct {
+	*chess2.PendingMove
+	// gameID is the session that the move belongs to, or empty for moves
+	// made through the stateless /move endpoint.
+	gameID string
+	// The challenges and responses must come with the defender's or the
+	// attacker's token. In hosted games, these are the tokens of the players'
+	// seats, and otherwise they are issued for the move, which is abandoned
+	// once it expires.
+	defenderToken string
+	attackerToken string
+	expires       time.Time
+}
+
+// Creates a pendingDuel for a move in a session, which the players decide
+// using the tokens of their seats.
+func newSessionPending(sess *session, pendingMove *chess2.PendingMove) *pendingDuel {
+	game := pendingMove.Game()
+	attacker := game.ToMove()
+	return &pendingDuel{
+		PendingMove:   pendingMove,
+		gameID:        sess.ID,
+		defenderToken: sess.Tokens[chess2.ColorIdx(chess2.OtherColor(attacker))],
+		attackerToken: sess.Tokens[chess2.ColorIdx(attacker)],
+	}
+}
+
+// statelessDuelTTL is how long a duel started through /move can wait for a
+// decision, and maxStatelessDuels is how many of them can be waiting at once.
+const (
+	statelessDuelTTL  = 10 * time.Minute
+	maxStatelessDuels = 10000
+)
+
+var (
+	errTooManyDuels  = errors.New("too many pending duels, try again later")
+	errDuelForbidden = errors.New("token does not allow deciding this duel")
+	errDuelsDecided  = errors.New("duels are decided after the move is made, through /duel")
+	errSeatForbidden = errors.New("token does not belong to a player in this game")
+	errNotYourTurn   = errors.New("it is not your turn")
+)
+
+type server struct {
+	mutex sync.Mutex
+	store gameStore
+	// now returns the current time. Time controls are enforced using it, so
+	// it can be replaced to test them deterministically.
+	now func() time.Time
+	// pending maps pending duel IDs to pending duels, and pendingByGame maps
+	// session IDs to the pending duel in that game.
+	pending       map[string]*pendingDuel
+	pendingByGame map[string]string
+	// subscribers maps session IDs to the clients watching the game.
+	subscribers map[string]map[chan event]struct{}
+}
+
+func newServer(store gameStore, now func() time.Time) *server {
+	return &server{
+		store:         store,
+		now:           now,
+		pending:       make(map[string]*pendingDuel),
+		pendingByGame: make(map[string]string),
+		subscribers:   make(map[string]map[chan event]struct{}),
+	}
+}
+
+func newID() string {
+	return randomHex(8)
+}
+
+// Returns a new secret which a player uses to prove who they are.
+func newToken() string {
+	return randomHex(16)
+}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Returns true if the token matches the expected one, which must be set.
+func validToken(token, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// Starts tracking a pending duel. The caller must hold the mutex.
+func (s *server) addPending(pending *pendingDuel) (string, error) {
+	if pending.gameID == "" {
+		now := s.now()
+		if s.pruneStateless(now) >= maxStatelessDuels {
+			return "", errTooManyDuels
+		}
+		pending.defenderToken = newToken()
+		pending.attackerToken = newToken()
+		pending.expires = now.Add(statelessDuelTTL)
+	}
+	id := newID()
+	if pending.gameID != "" {
+		id = pending.gameID + "-" + id
+	}
+	s.pending[id] = pending
+	if pending.gameID != "" {
+		s.pendingByGame[pending.gameID] = id
+		s.publish(pending.gameID, event{"duel", formatPending(id, pending)})
+	}
+	return id, nil
+}
+
+// Abandons the stateless duels which have expired, and returns the number
+// which are left. The caller must hold the mutex.
+func (s *server) pruneStateless(now time.Time) int {
+	count := 0
+	for id, pending := range s.pending {
+		if pending.gameID != "" {
+			continue
+		} else if !now.Before(pending.expires) {
+			delete(s.pending, id)
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns an error if any of the move's duels have been decided. Each player
+// makes their own decisions through /duel, so that the bids stay secret.
+func validateUndecided(move chess2.Move) error {
+	for _, duel := range move.Duels {
+		if duel.IsStarted() {
+			return errDuelsDecided
+		}
+	}
+	return nil
+}
+
+// Returns an error unless the token allows the player who is to decide the
+// current duel to do so.
+func (p *pendingDuel) authorize(token string) error {
+	expected := p.defenderToken
+	if p.Phase() == chess2.DuelPhaseResponse {
+		expected = p.attackerToken
+	}
+	if !validToken(token, expected) {
+		return errDuelForbidden
+	}
+	return nil
+}
+
+// Runs the function on the pending duel with the given ID and responds with
+// the result. If the move is complete afterwards, it is finalized. Unless the
+// function is nil, which only shows the duel, the token must allow the player
+// to decide the duel.
+func (s *server) updatePending(c *gin.Context, id, token string, f func(*chess2.PendingMove) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending, found := s.pending[id]
+	if i := strings.IndexByte(id, '-'); !found && i > 0 {
+		// Loading the session resumes its pending duel, in case the
+		// server has restarted since the duel began.
+		if _, err := s.getSession(id[:i]); err != nil && err != errGameNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		pending, found = s.pending[id]
+	}
+	if found && pending.gameID == "" && !s.now().Before(pending.expires) {
+		delete(s.pending, id)
+		found = false
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such pending duel"})
+		return
+	}
+	if f != nil {
+		if err := pending.authorize(token); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var sess *session
+	if pending.gameID != "" {
+		// Loading the session ends the game, and the duel, if a player has
+		// run out of time.
+		var err error
+		if sess, err = s.getSession(pending.gameID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, found := s.pending[id]; !found {
+			c.JSON(http.StatusConflict, gin.H{"error": chess2.GameOverError.Error()})
+			return
+		}
+	}
+	if f == nil {
+		s.respondPending(c, sess, id, pending)
+		return
+	}
+	if err := f(pending.PendingMove); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sess != nil {
+		sess.timeDuel(pending.Phase(), s.now())
+	}
+	if pending.Phase() == chess2.DuelPhaseComplete {
+		delete(s.pending, id)
+		delete(s.pendingByGame, pending.gameID)
+	} else if sess != nil {
+		if err := s.savePending(sess, id, pending); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.publish(pending.gameID, event{"duel", formatPending(id, pending)})
+	}
+	s.respondPending(c, sess, id, pending)
+}
+
+// Saves the decisions made so far in a pending duel to its session. The
+// caller must hold the mutex.
+func (s *server) savePending(sess *session, id string, pending *pendingDuel) error {
+	sess.Duel = &sessionDuel{
+		ID:    id,
+		Move:  pending.Move().String(),
+		Index: pending.Index(),
+	}
+	return s.store.Put(sess)
+}
+
+// Starts tracking the pending duel saved in the session, if it isn't tracked
+// already. The caller must hold the mutex.
+func (s *server) resumePending(sess *session) error {
+	if sess.Duel == nil {
+		return nil
+	} else if _, found := s.pending[sess.Duel.ID]; found {
+		return nil
+	}
+	pendingMove, err := resumePendingMove(sess.record.Game(), sess.Duel)
+	if err != nil {
+		return err
+	}
+	s.pending[sess.Duel.ID] = newSessionPending(sess, pendingMove)
+	s.pendingByGame[sess.ID] = sess.Duel.ID
+	return nil
+}
+
+// Describes the decision a pending move is waiting for. The challenge of the
+// current duel is kept secret from the attacker.
+func formatPending(id string, pending *pendingDuel) gin.H {
+	move := pending.Move()
+	move.Duels[pending.Index()] = chess2.Duel{}
+	o := pending.Opportunity()
+	bids := pending.AvailableBids()
+	if bids == nil {
+		bids = []int{}
+	}
+	response := gin.H{
+		"id":             id,
+		"epd":            chess2.EncodeEpd(pending.Game()),
+		"move":           move.String(),
+		"phase":          pending.Phase().String(),
+		"duel_index":     pending.Index(),
+		"square":         o.Square.String(),
+		"attacker":       string(chess2.EncodeFenPiece(o.Attacker)),
+		"defender":       string(chess2.EncodeFenPiece(o.Defender)),
+		"available_bids": bids,
+	}
+	if pending.gameID != "" {
+		response["game_id"] = pending.gameID
+	}
+	return response
+}
+
+// Responds with the result of a duel decision: either the next decision which
+// is required, or the game after the move is finalized. The session is the one
+// the move belongs to, or nil for the stateless /move endpoint. The caller
+// must hold the mutex.
+func (s *server) respondPending(c *gin.Context, sess *session, id string, pending *pendingDuel) {
+	if pending.Phase() != chess2.DuelPhaseComplete {
+		c.JSON(http.StatusOK, gin.H{"pending_duel": formatPending(id, pending)})
+		return
+	}
+	if sess == nil {
+		game, err := pending.Apply()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		response := formatGame(game)
+		response["move"] = pending.Move().String()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	s.applySessionMove(c, sess, pending.Move())
+}
+
+// Adds a move with all of its duels decided to the session, saves it, and
+// responds with the session. The caller must hold the mutex.
+func (s *server) applySessionMove(c *gin.Context, sess *session, move chess2.Move) {
+	if err := sess.applyMove(move, s.now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.store.Put(sess); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response := s.formatSession(sess)
+	s.publish(sess.ID, event{"game", response})
+	c.JSON(http.StatusOK, response)
+}
+
+// Describes a session, including the current position in the same format as
+// the stateless endpoints. The caller must hold the mutex.
+func (s *server) formatSession(sess *session) gin.H {
+	response := formatGame(sess.record.Game())
+	response["id"] = sess.ID
+	response["white"] = sess.White
+	response["black"] = sess.Black
+	response["start"] = sess.Start
+	response["chess960"] = sess.Chess960
+	moves := sess.Moves
+	if moves == nil {
+		moves = []string{}
+	}
+	response["moves"] = moves
+	if len(moves) > 0 {
+		response["last_move"] = moves[len(moves)-1]
+	} else {
+		response["last_move"] = nil
+	}
+	if id, found := s.pendingByGame[sess.ID]; found {
+		response["pending_duel"] = formatPending(id, s.pending[id])
+	} else {
+		response["pending_duel"] = nil
+	}
+	if color, offered := sess.record.DrawOffer(); offered {
+		response["draw_offer"] = color.String()
+	} else {
+		response["draw_offer"] = nil
+	}
+	if sess.clock != nil {
+		response["clock"] = formatClock(sess, s.now())
+	} else {
+		response["clock"] = nil
+	}
+	return response
+}
+
+// Describes the time remaining for each player in a timed session.
+func formatClock(sess *session, now time.Time) gin.H {
+	remaining := func(color chess2.Color) float64 {
+		r := sess.clock.Remaining(color, now)
+		if r < 0 {
+			r = 0
+		}
+		return r.Seconds()
+	}
+	response := gin.H{
+		"time_control": sess.TimeControl,
+		"white":        remaining(chess2.ColorWhite),
+		"black":        remaining(chess2.ColorBlack),
+		"running":      nil,
+	}
+	if sess.Result == "" {
+		if color, running := sess.clock.Running(); running {
+			response["running"] = strings.ToLower(color.String())
+		}
+	}
 	return response
 }
 
-func setupRouter() *gin.Engine {
+// Loads a session, ending the game if the player to move has run out of time.
+// The caller must hold the mutex.
+func (s *server) getSession(id string) (*session, error) {
+	sess, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resumePending(sess); err != nil {
+		return nil, err
+	}
+	if sess.checkTime(s.now()) {
+		if err := s.store.Put(sess); err != nil {
+			return nil, err
+		}
+		s.dropPending(sess.ID)
+		s.publish(sess.ID, event{"game", s.formatSession(sess)})
+	}
+	return sess, nil
+}
+
+// Abandons the pending duel in the game with the given session ID, if there
+// is one. The caller must hold the mutex.
+func (s *server) dropPending(gameID string) {
+	if pendingID, found := s.pendingByGame[gameID]; found {
+		delete(s.pending, pendingID)
+		delete(s.pendingByGame, gameID)
+	}
+}
+
+// Loads the session named in the URL, or responds with an error. The caller
+// must hold the mutex.
+func (s *server) loadSession(c *gin.Context) *session {
+	sess, err := s.getSession(c.Param("id"))
+	if err == errGameNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return sess
+}
+
+type newGameRequest struct {
+	White string `json:"white"`
+	Black string `json:"black"`
+	// Chess960 is a Chess960 position number, or the string "random".
+	Chess960 chess960Param `json:"chess960"`
+	// Stones are the starting stones, written as in an EPD like "12/9".
+	Stones      string       `json:"stones"`
+	TimeControl *timeControl `json:"time_control"`
+}
+
+type moveRequest struct {
+	Move string `json:"move"`
+	// Token is the token of the player's seat, for hosted games.
+	Token string `json:"token"`
+}
+
+type actionRequest struct {
+	Action string `json:"action"`
+	Token  string `json:"token"`
+}
+
+type duelRequest struct {
+	// Bid is the number of stones bid. A challenge with no bid declines to
+	// duel.
+	Bid  *int `json:"bid"`
+	Gain bool `json:"gain"`
+	// Token is the defender's token for a challenge or the attacker's for a
+	// response.
+	Token string `json:"token"`
+}
+
+func setupRouter(s *server) *gin.Engine {
 	r := gin.Default()
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{"ok": true})
@@ -58,12 +608,20 @@ func setupRouter() *gin.Engine {
 		black, blackErr := parseArmySymbol(c.Query("black"), "black")
 		if whiteErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": whiteErr.Error()})
+			return
 		}
 		if blackErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": blackErr.Error()})
+			return
+		}
+		game, position, err := newGame(white, black, c.Query("chess960"), c.Query("stones"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		game := chess2.GameFromArmies(white, black)
-		c.JSON(http.StatusOK, formatGame(game))
+		response := formatGame(game)
+		response["chess960"] = position
+		c.JSON(http.StatusOK, response)
 	})
 	r.POST("/move", func(c *gin.Context) {
 		var request gin.H
@@ -80,21 +638,233 @@ func setupRouter() *gin.Engine {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		nextGame := game.ApplyMove(move)
-		response := formatGame(nextGame)
-		duels := game.GenerateDuels(move)
-		// Strip off the first item, which is always "don't initiate a duel"
-		duelsStr := make([]string, len(duels)-1)
-		for i := 1; i < len(duels); i++ {
-			duelsStr[i-1] = duels[i].String()[4:]
+		if err := validateUndecided(move); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pendingMove, err := chess2.NewPendingMove(game, move)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		pending := &pendingDuel{PendingMove: pendingMove}
+		if pendingMove.Phase() == chess2.DuelPhaseComplete {
+			s.respondPending(c, nil, "", pending)
+			return
+		}
+		id, err := s.addPending(pending)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		// There are no players to check in a stateless game, so both tokens
+		// are sent to the client which made the move, and it is trusted to
+		// pass the defender's token on to the defender. Hosted games send
+		// each player only their own token.
+		response := formatPending(id, pending)
+		response["defender_token"] = pending.defenderToken
+		response["attacker_token"] = pending.attackerToken
+		c.JSON(http.StatusOK, gin.H{"pending_duel": response})
+	})
+	r.POST("/games", func(c *gin.Context) {
+		var request newGameRequest
+		if err := c.BindJSON(&request); err != nil {
+			return
+		}
+		white, err := parseArmySymbol(request.White, "white")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		black, err := parseArmySymbol(request.Black, "black")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.TimeControl != nil {
+			if err := request.TimeControl.validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		game, position, err := newGame(white, black, string(request.Chess960), request.Stones)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := s.now()
+		sess := &session{
+			ID:          newID(),
+			Tokens:      [2]string{newToken(), newToken()},
+			White:       request.White,
+			Black:       request.Black,
+			Start:       chess2.EncodeEpd(game),
+			Chess960:    position,
+			Created:     now,
+			Updated:     now,
+			TimeControl: request.TimeControl,
+		}
+		if err := sess.load(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if err := s.store.Put(sess); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// The tokens are only ever sent here, to the player who created the
+		// game, who passes their opponent's token on to them.
+		response := s.formatSession(sess)
+		response["white_token"] = sess.Tokens[chess2.ColorIdx(chess2.ColorWhite)]
+		response["black_token"] = sess.Tokens[chess2.ColorIdx(chess2.ColorBlack)]
+		c.JSON(http.StatusCreated, response)
+	})
+	r.GET("/games/:id", func(c *gin.Context) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if sess := s.loadSession(c); sess != nil {
+			c.JSON(http.StatusOK, s.formatSession(sess))
+		}
+	})
+	r.POST("/games/:id/moves", func(c *gin.Context) {
+		var request moveRequest
+		if err := c.BindJSON(&request); err != nil {
+			return
+		}
+		move, err := chess2.ParseUci(request.Move)
+		if err == nil {
+			err = validateUndecided(move)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		sess := s.loadSession(c)
+		if sess == nil {
+			return
+		}
+		color, err := sess.seat(request.Token)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if _, found := s.pendingByGame[sess.ID]; found {
+			c.JSON(http.StatusConflict, gin.H{"error": "a duel is pending"})
+			return
+		}
+		game := sess.record.Game()
+		if game.GameState() == chess2.GameInProgress && color != game.ToMove() {
+			c.JSON(http.StatusForbidden, gin.H{"error": errNotYourTurn.Error()})
+			return
+		}
+		pendingMove, err := chess2.NewPendingMove(sess.record.Game(), move)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if pendingMove.Phase() == chess2.DuelPhaseComplete {
+			s.applySessionMove(c, sess, pendingMove.Move())
+			return
+		}
+		pending := newSessionPending(sess, pendingMove)
+		id, err := s.addPending(pending)
+		if err == nil {
+			sess.timeDuel(pendingMove.Phase(), s.now())
+			err = s.savePending(sess, id, pending)
+		}
+		if err != nil {
+			s.dropPending(sess.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.respondPending(c, sess, id, pending)
+	})
+	r.POST("/games/:id/actions", func(c *gin.Context) {
+		var request actionRequest
+		if err := c.BindJSON(&request); err != nil {
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		sess := s.loadSession(c)
+		if sess == nil {
+			return
+		}
+		color, err := sess.seat(request.Token)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err := sess.applyAction(request.Action, color, s.now()); err == chess2.GameOverError {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if sess.Result != "" {
+			s.dropPending(sess.ID)
 		}
-		response["available_duels"] = duelsStr
+		if err := s.store.Put(sess); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response := s.formatSession(sess)
+		s.publish(sess.ID, event{"game", response})
 		c.JSON(http.StatusOK, response)
 	})
+	r.GET("/games/:id/events", s.streamEvents)
+	r.GET("/duel/:id", func(c *gin.Context) {
+		s.updatePending(c, c.Param("id"), "", nil)
+	})
+	r.POST("/duel/:id/challenge", func(c *gin.Context) {
+		var request duelRequest
+		if err := c.BindJSON(&request); err != nil {
+			return
+		}
+		s.updatePending(c, c.Param("id"), request.Token, func(p *chess2.PendingMove) error {
+			if request.Bid == nil {
+				return p.Decline()
+			}
+			return p.Challenge(*request.Bid)
+		})
+	})
+	r.POST("/duel/:id/response", func(c *gin.Context) {
+		var request duelRequest
+		if err := c.BindJSON(&request); err != nil {
+			return
+		}
+		s.updatePending(c, c.Param("id"), request.Token, func(p *chess2.PendingMove) error {
+			if request.Bid == nil {
+				return fmt.Errorf("bid is required")
+			}
+			return p.Respond(*request.Bid, request.Gain)
+		})
+	})
 	return r
 }
 
 func main() {
-	r := setupRouter()
+	pflag.Parse()
+
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load army: ", err)
+		os.Exit(2)
+	}
+	var store gameStore = newMemoryStore()
+	if *storeDir != "" {
+		var err error
+		if store, err = newFileStore(*storeDir); err != nil {
+			fmt.Fprintln(os.Stderr, "could not open store: ", err)
+			os.Exit(2)
+		}
+	}
+	r := setupRouter(newServer(store, time.Now))
 	r.Run()
 }
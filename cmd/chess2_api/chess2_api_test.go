@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = ioutil.Discard
+	os.Exit(m.Run())
+}
+
+// A testServer is an API server with a fake clock, which only moves when the
+// test advances it.
+type testServer struct {
+	t      *testing.T
+	server *server
+	router *gin.Engine
+	now    time.Time
+	// tokens maps game IDs to the tokens of the white and black seats.
+	tokens map[string]map[string]string
+}
+
+func newTestServer(t *testing.T, store gameStore) *testServer {
+	ts := &testServer{
+		t:      t,
+		now:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		tokens: make(map[string]map[string]string),
+	}
+	ts.server = newServer(store, func() time.Time { return ts.now })
+	ts.router = setupRouter(ts.server)
+	return ts
+}
+
+func (ts *testServer) advance(value float64) {
+	ts.now = ts.now.Add(seconds(value))
+}
+
+// Makes a request with the given JSON body, and returns the status code and
+// the decoded response.
+func (ts *testServer) request(method, path string, body interface{}) (int, map[string]interface{}) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(ts.t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	var response map[string]interface{}
+	require.NoError(ts.t, json.Unmarshal(w.Body.Bytes(), &response), "Path: %s", path)
+	return w.Code, response
+}
+
+// Makes a request which must succeed, and returns the decoded response.
+func (ts *testServer) mustRequest(method, path string, body interface{}) map[string]interface{} {
+	code, response := ts.request(method, path, body)
+	require.Less(ts.t, code, 300, "Path: %s  Response: %v", path, response)
+	return response
+}
+
+// Creates a hosted game and returns its ID.
+func (ts *testServer) newGame(white, black string, tc *timeControl) string {
+	request := gin.H{"white": white, "black": black}
+	if tc != nil {
+		request["time_control"] = tc
+	}
+	response := ts.mustRequest("POST", "/games", request)
+	id := response["id"].(string)
+	ts.tokens[id] = map[string]string{
+		"white": response["white_token"].(string),
+		"black": response["black_token"].(string),
+	}
+	return id
+}
+
+// Returns the color of the player to move in the game.
+func (ts *testServer) toMove(id string) string {
+	epd := ts.mustRequest("GET", "/games/"+id, nil)["epd"].(string)
+	if side := strings.Fields(epd)[1]; side == "b" || side == "k" {
+		return "black"
+	}
+	return "white"
+}
+
+// Makes each move in the game after the given number of seconds.
+func (ts *testServer) play(id string, seconds float64, ucis ...string) map[string]interface{} {
+	var response map[string]interface{}
+	for _, uci := range ucis {
+		token := ts.tokens[id][ts.toMove(id)]
+		ts.advance(seconds)
+		response = ts.mustRequest("POST", "/games/"+id+"/moves", gin.H{"move": uci, "token": token})
+	}
+	return response
+}
+
+// Returns the body of a request by the given player to bid in a duel.
+func (ts *testServer) bid(id, color string, bid int) gin.H {
+	return gin.H{"bid": bid, "token": ts.tokens[id][color]}
+}
+
+func clockOf(response map[string]interface{}) map[string]interface{} {
+	return response["clock"].(map[string]interface{})
+}
+
+func TestDuelTime(t *testing.T) {
+	cases := map[string]struct {
+		// Seconds taken by black to challenge, and by white to respond.
+		challenge, response float64
+		white, black        float64
+		winner              interface{}
+	}{
+		"challenge": {
+			challenge: 5,
+			response:  2,
+			white:     47,
+			black:     54,
+		},
+		// A defender who never decides runs out of time themselves.
+		"defender stalls": {
+			challenge: 60,
+			white:     49,
+			black:     0,
+			winner:    "white",
+		},
+	}
+	for name, config := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		id := ts.newGame("c", "c", &timeControl{Base: 60})
+		ts.play(id, 1, "e2e4", "d7d5")
+		response := ts.play(id, 10, "e4d5")
+		duelID := response["pending_duel"].(map[string]interface{})["id"].(string)
+		response = ts.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, "black", clockOf(response)["running"], "Case: %s", name)
+
+		ts.advance(config.challenge)
+		if config.winner != nil {
+			response = ts.mustRequest("GET", "/games/"+id, nil)
+			assert.Equal(t, config.winner, response["winner"], "Case: %s", name)
+			assert.Equal(t, "timeout", response["reason"], "Case: %s", name)
+			assert.Nil(t, response["pending_duel"], "Case: %s", name)
+			code, _ := ts.request("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "black", 1))
+			assert.Equal(t, http.StatusNotFound, code, "Case: %s", name)
+		} else {
+			ts.mustRequest("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "black", 1))
+			response = ts.mustRequest("GET", "/games/"+id, nil)
+			assert.Equal(t, "white", clockOf(response)["running"], "Case: %s", name)
+			ts.advance(config.response)
+			response = ts.mustRequest("POST", "/duel/"+duelID+"/response", ts.bid(id, "white", 0))
+			assert.Equal(t, "e4d5:10-", response["last_move"], "Case: %s", name)
+			assert.Equal(t, "black", clockOf(response)["running"], "Case: %s", name)
+		}
+		assert.Equal(t, config.white, clockOf(response)["white"], "Case: %s", name)
+		assert.Equal(t, config.black, clockOf(response)["black"], "Case: %s", name)
+	}
+}
+
+func TestTimeControl(t *testing.T) {
+	cases := map[string]struct {
+		control timeControl
+		// Seconds taken by each move, starting with white's e2e4.
+		moves        []float64
+		white, black float64
+		winner       interface{}
+	}{
+		"sudden death": {
+			control: timeControl{Base: 60},
+			moves:   []float64{10, 20, 5},
+			white:   45,
+			black:   40,
+		},
+		"fischer increment": {
+			control: timeControl{Base: 60, Increment: 2},
+			moves:   []float64{1, 10, 1},
+			white:   62,
+			black:   52,
+		},
+		"bronstein delay": {
+			control: timeControl{Base: 60, Delay: 2},
+			moves:   []float64{1, 10, 3},
+			white:   59,
+			black:   52,
+		},
+		"flagged": {
+			control: timeControl{Base: 60},
+			moves:   []float64{10, 20, 51},
+			white:   0,
+			black:   40,
+			winner:  "black",
+		},
+		"move limit": {
+			control: timeControl{MoveLimit: 30},
+			moves:   []float64{29, 29, 31},
+			winner:  "black",
+		},
+	}
+	ucis := []string{"e2e4", "e7e5", "g1f3"}
+	for name, config := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		id := ts.newGame("c", "c", &config.control)
+		last := len(config.moves) - 1
+		for i, seconds := range config.moves[:last] {
+			ts.play(id, seconds, ucis[i])
+		}
+		ts.advance(config.moves[last])
+		token := ts.tokens[id]["white"]
+		code, _ := ts.request("POST", "/games/"+id+"/moves", gin.H{"move": ucis[last], "token": token})
+		response := ts.mustRequest("GET", "/games/"+id, nil)
+		if config.winner != nil {
+			assert.NotEqual(t, http.StatusOK, code, "Case: %s", name)
+			assert.Equal(t, config.winner, response["winner"], "Case: %s", name)
+			assert.Equal(t, "timeout", response["reason"], "Case: %s", name)
+			assert.Nil(t, clockOf(response)["running"], "Case: %s", name)
+			continue
+		}
+		require.Equal(t, http.StatusOK, code, "Case: %s", name)
+		assert.Nil(t, response["winner"], "Case: %s", name)
+		assert.Equal(t, config.white, clockOf(response)["white"], "Case: %s", name)
+		assert.Equal(t, config.black, clockOf(response)["black"], "Case: %s", name)
+		assert.Equal(t, "black", clockOf(response)["running"], "Case: %s", name)
+	}
+}
+
+func TestTimeControlKingTurn(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	id := ts.newGame("k", "c", &timeControl{Base: 60, Increment: 1})
+	response := ts.play(id, 10, "d2d4")
+	// The king-turn continues, so white's clock keeps running.
+	assert.Equal(t, "white", clockOf(response)["running"])
+	assert.Equal(t, 50.0, clockOf(response)["white"])
+	response = ts.play(id, 10, "d1d2", "e7e5")
+	// White's king-turn is a single turn, so the increment is only added
+	// once.
+	assert.Equal(t, 41.0, clockOf(response)["white"])
+	assert.Equal(t, 51.0, clockOf(response)["black"])
+	assert.Equal(t, "white", clockOf(response)["running"])
+}
+
+func TestTimedSessionReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chess2_api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files, err := newFileStore(dir)
+	require.NoError(t, err)
+	stores := map[string]gameStore{
+		"memory": newMemoryStore(),
+		"file":   files,
+	}
+	for name, store := range stores {
+		ts := newTestServer(t, store)
+		id := ts.newGame("c", "c", &timeControl{Base: 60, Increment: 1})
+		ts.play(id, 5, "e2e4", "d7d5")
+		response := ts.play(id, 5, "e4d5")
+		duelID := response["pending_duel"].(map[string]interface{})["id"].(string)
+		ts.advance(3)
+		before := ts.mustRequest("GET", "/games/"+id, nil)
+
+		// A new server only has the store to go on, as after a restart.
+		restarted := newTestServer(t, store)
+		restarted.now = ts.now
+		after := restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, before, after, "Case: %s", name)
+		assert.Equal(t, 51.0, clockOf(after)["white"], "Case: %s", name)
+		assert.Equal(t, 53.0, clockOf(after)["black"], "Case: %s", name)
+		assert.Equal(t, "black", clockOf(after)["running"], "Case: %s", name)
+
+		restarted.mustRequest("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "black", 1))
+		restarted.advance(3)
+		response = restarted.mustRequest("POST", "/duel/"+duelID+"/response", ts.bid(id, "white", 0))
+		assert.Equal(t, "e4d5:10-", response["last_move"], "Case: %s", name)
+		assert.Equal(t, 49.0, clockOf(response)["white"], "Case: %s", name)
+		assert.Equal(t, 53.0, clockOf(response)["black"], "Case: %s", name)
+
+		restarted.advance(52)
+		response = restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Nil(t, response["winner"], "Case: %s", name)
+		assert.Equal(t, 1.0, clockOf(response)["black"], "Case: %s", name)
+		restarted.advance(1)
+		response = restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, "white", response["winner"], "Case: %s", name)
+		assert.Equal(t, "timeout", response["reason"], "Case: %s", name)
+		now := restarted.now.Add(time.Hour)
+		restarted = newTestServer(t, store)
+		restarted.now = now
+		response = restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, "white", response["winner"], "Case: %s", name)
+		assert.Equal(t, 0.0, clockOf(response)["black"], "Case: %s", name)
+	}
+}
+
+func TestActions(t *testing.T) {
+	type step struct {
+		// color is the seat whose token is used, if any.
+		color  string
+		action string
+		code   int
+	}
+	cases := map[string]struct {
+		steps          []step
+		winner, reason interface{}
+		drawOffer      interface{}
+	}{
+		"resign": {
+			steps:  []step{{"white", "resign", http.StatusOK}},
+			winner: "black",
+			reason: "resignation",
+		},
+		"resign out of turn": {
+			steps: []step{{"black", "resign", http.StatusBadRequest}},
+		},
+		"resign without token": {
+			steps: []step{{"", "resign", http.StatusForbidden}},
+		},
+		"offer draw": {
+			steps:     []step{{"white", "offer_draw", http.StatusOK}},
+			drawOffer: "white",
+		},
+		"offer draw by the opponent": {
+			steps:     []step{{"black", "offer_draw", http.StatusOK}},
+			drawOffer: "black",
+		},
+		"offer draw against an offer": {
+			steps: []step{
+				{"white", "offer_draw", http.StatusOK},
+				{"black", "offer_draw", http.StatusBadRequest},
+			},
+			drawOffer: "white",
+		},
+		"accept draw": {
+			steps: []step{
+				{"white", "offer_draw", http.StatusOK},
+				{"black", "accept_draw", http.StatusOK},
+			},
+			winner: "draw",
+			reason: "agreement",
+		},
+		"accept own draw offer": {
+			steps: []step{
+				{"white", "offer_draw", http.StatusOK},
+				{"white", "accept_draw", http.StatusBadRequest},
+			},
+			drawOffer: "white",
+		},
+		"accept draw without offer": {
+			steps: []step{{"black", "accept_draw", http.StatusBadRequest}},
+		},
+		"decline draw": {
+			steps: []step{
+				{"white", "offer_draw", http.StatusOK},
+				{"black", "decline_draw", http.StatusOK},
+			},
+		},
+		"decline draw without token": {
+			steps: []step{
+				{"white", "offer_draw", http.StatusOK},
+				{"", "decline_draw", http.StatusForbidden},
+			},
+			drawOffer: "white",
+		},
+		// Hosted games end by themselves under the fifty move rule and on
+		// threefold repetition, so there is never a draw to claim.
+		"claim draw": {
+			steps: []step{{"white", "claim_draw", http.StatusBadRequest}},
+		},
+		"action after game over": {
+			steps: []step{
+				{"white", "resign", http.StatusOK},
+				{"black", "offer_draw", http.StatusConflict},
+			},
+			winner: "black",
+			reason: "resignation",
+		},
+		"unknown action": {
+			steps: []step{{"white", "abort", http.StatusBadRequest}},
+		},
+	}
+	for name, config := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		id := ts.newGame("c", "c", nil)
+		for i, step := range config.steps {
+			request := gin.H{"action": step.action}
+			if step.color != "" {
+				request["token"] = ts.tokens[id][step.color]
+			}
+			code, response := ts.request("POST", "/games/"+id+"/actions", request)
+			assert.Equal(t, step.code, code, "Case: %s  Step: %d  Response: %v", name, i, response)
+		}
+		response := ts.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, config.winner, response["winner"], "Case: %s", name)
+		assert.Equal(t, config.reason, response["reason"], "Case: %s", name)
+		assert.Equal(t, config.drawOffer, response["draw_offer"], "Case: %s", name)
+	}
+}
+
+func TestSeats(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	id := ts.newGame("c", "c", nil)
+	assert.NotEqual(t, ts.tokens[id]["white"], ts.tokens[id]["black"])
+	response := ts.mustRequest("GET", "/games/"+id, nil)
+	assert.NotContains(t, response, "white_token")
+	assert.NotContains(t, response, "black_token")
+
+	move := func(uci, token string) int {
+		code, _ := ts.request("POST", "/games/"+id+"/moves", gin.H{"move": uci, "token": token})
+		return code
+	}
+	assert.Equal(t, http.StatusForbidden, move("e2e4", ""))
+	assert.Equal(t, http.StatusForbidden, move("e2e4", "0123456789abcdef"))
+	assert.Equal(t, http.StatusForbidden, move("e2e4", ts.tokens[id]["black"]))
+	assert.Equal(t, http.StatusOK, move("e2e4", ts.tokens[id]["white"]))
+	assert.Equal(t, http.StatusOK, move("d7d5", ts.tokens[id]["black"]))
+
+	// Only the defender can challenge, and only the attacker can respond.
+	response = ts.play(id, 0, "e4d5")
+	duelID := response["pending_duel"].(map[string]interface{})["id"].(string)
+	code, _ := ts.request("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "white", 1))
+	assert.Equal(t, http.StatusForbidden, code)
+	ts.mustRequest("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "black", 1))
+	code, _ = ts.request("POST", "/duel/"+duelID+"/response", ts.bid(id, "black", 0))
+	assert.Equal(t, http.StatusForbidden, code)
+	response = ts.mustRequest("POST", "/duel/"+duelID+"/response", ts.bid(id, "white", 0))
+	assert.Equal(t, "e4d5:10-", response["last_move"])
+}
+
+// Starts a stateless duel where white's pawn captures on e5, and returns the
+// pending duel.
+func (ts *testServer) startStatelessDuel() map[string]interface{} {
+	response := ts.mustRequest("POST", "/move", gin.H{
+		"epd":  "rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+		"move": "d4e5",
+	})
+	return response["pending_duel"].(map[string]interface{})
+}
+
+func TestStatelessDuel(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	pending := ts.startStatelessDuel()
+	id := pending["id"].(string)
+	defender := pending["defender_token"].(string)
+	attacker := pending["attacker_token"].(string)
+	assert.Equal(t, "challenge", pending["phase"])
+	assert.Equal(t, []interface{}{0.0, 1.0, 2.0}, pending["available_bids"])
+
+	response := ts.mustRequest("GET", "/duel/"+id, nil)
+	pending = response["pending_duel"].(map[string]interface{})
+	assert.Equal(t, "challenge", pending["phase"])
+	assert.NotContains(t, pending, "defender_token")
+	assert.NotContains(t, pending, "attacker_token")
+
+	// Only the defender can challenge, and only the attacker can respond.
+	for _, token := range []string{"", attacker} {
+		code, _ := ts.request("POST", "/duel/"+id+"/challenge", gin.H{"bid": 1, "token": token})
+		assert.Equal(t, http.StatusForbidden, code, "Token: %s", token)
+	}
+	response = ts.mustRequest("POST", "/duel/"+id+"/challenge", gin.H{"bid": 1, "token": defender})
+	pending = response["pending_duel"].(map[string]interface{})
+	assert.Equal(t, "response", pending["phase"])
+	// The attacker must not see the challenge before responding.
+	assert.Equal(t, "d4e5", pending["move"])
+	for _, token := range []string{"", defender} {
+		code, _ := ts.request("POST", "/duel/"+id+"/response", gin.H{"bid": 0, "token": token})
+		assert.Equal(t, http.StatusForbidden, code, "Token: %s", token)
+	}
+	code, _ := ts.request("POST", "/duel/"+id+"/challenge", gin.H{"bid": 1, "token": defender})
+	assert.Equal(t, http.StatusForbidden, code)
+	response = ts.mustRequest("POST", "/duel/"+id+"/response", gin.H{"bid": 0, "gain": true, "token": attacker})
+	assert.Equal(t, "d4e5:10+", response["move"])
+	assert.Equal(t, "rnbqkbnr/pppp1ppp/8/8/8/8/PPP1PPPP/RNBQKBNR b KQkq - 0 1 cc 43", response["epd"])
+
+	code, _ = ts.request("GET", "/duel/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestStatelessDuelDecided(t *testing.T) {
+	cases := map[string]string{
+		"challenge":              "d4e5:1",
+		"challenge and response": "d4e5:00+",
+	}
+	for name, uci := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		code, response := ts.request("POST", "/move", gin.H{
+			"epd":  "rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			"move": uci,
+		})
+		assert.Equal(t, http.StatusBadRequest, code, "Case: %s", name)
+		assert.Equal(t, errDuelsDecided.Error(), response["error"], "Case: %s", name)
+	}
+}
+
+func TestStatelessDuelExpiry(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	pending := ts.startStatelessDuel()
+	id := pending["id"].(string)
+	ts.advance(statelessDuelTTL.Seconds() - 1)
+	ts.mustRequest("GET", "/duel/"+id, nil)
+	ts.advance(1)
+	code, _ := ts.request("GET", "/duel/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = ts.request("POST", "/duel/"+id+"/challenge", gin.H{"bid": 1, "token": pending["defender_token"]})
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestSessionMoveDecided(t *testing.T) {
+	cases := map[string]string{
+		"challenge":              "e4d5:1",
+		"challenge and response": "e4d5:00+",
+		"second duel":            "e4d5::00+",
+	}
+	for name, uci := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		id := ts.newGame("c", "c", nil)
+		before := ts.play(id, 0, "e2e4", "d7d5")
+		code, response := ts.request("POST", "/games/"+id+"/moves", gin.H{"move": uci, "token": ts.tokens[id]["white"]})
+		assert.Equal(t, http.StatusBadRequest, code, "Case: %s", name)
+		assert.Equal(t, errDuelsDecided.Error(), response["error"], "Case: %s", name)
+		after := ts.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, before, after, "Case: %s", name)
+		assert.Equal(t, "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2 cc 33", after["epd"], "Case: %s", name)
+	}
+}
+
+func TestNewGame(t *testing.T) {
+	cases := map[string]struct {
+		query string
+		code  int
+		err   string
+	}{
+		"classic":        {"white=c&black=c", http.StatusOK, ""},
+		"no white army":  {"black=c", http.StatusBadRequest, "white is required"},
+		"bad black army": {"white=c&black=zz", http.StatusBadRequest, "black must be a valid army symbol"},
+		"bad chess960":   {"white=c&black=c&chess960=960", http.StatusBadRequest, "chess960 must be random or a position from 0 to 959"},
+	}
+	for name, config := range cases {
+		ts := newTestServer(t, newMemoryStore())
+		code, response := ts.request("GET", "/new?"+config.query, nil)
+		assert.Equal(t, config.code, code, "Case: %s", name)
+		if config.err != "" {
+			assert.Equal(t, gin.H{"error": config.err}, gin.H(response), "Case: %s", name)
+		} else {
+			assert.Contains(t, response, "epd", "Case: %s", name)
+		}
+	}
+}
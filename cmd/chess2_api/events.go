@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// An event is a message pushed to the clients watching a game.
+type event struct {
+	name string
+	data gin.H
+}
+
+// subscriberBuffer is the number of events which can be queued for a client
+// before it is considered too slow and disconnected. Clients are expected to
+// reconnect and receive the latest state.
+const subscriberBuffer = 16
+
+// keepaliveInterval is how often a comment is sent to idle clients, so that
+// proxies don't close the connection and disconnected clients are noticed.
+const keepaliveInterval = 30 * time.Second
+
+// Starts sending the events for the given session to a new channel. The caller
+// must hold the mutex.
+func (s *server) subscribe(gameID string) chan event {
+	ch := make(chan event, subscriberBuffer)
+	subscribers, found := s.subscribers[gameID]
+	if !found {
+		subscribers = make(map[chan event]struct{})
+		s.subscribers[gameID] = subscribers
+	}
+	subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Stops sending events to the channel, if it hasn't been stopped already.
+func (s *server) unsubscribe(gameID string, ch chan event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if subscribers, found := s.subscribers[gameID]; found {
+		if _, found := subscribers[ch]; found {
+			delete(subscribers, ch)
+			close(ch)
+		}
+		if len(subscribers) == 0 {
+			delete(s.subscribers, gameID)
+		}
+	}
+}
+
+// Sends the event to everyone watching the session. The caller must hold the
+// mutex.
+func (s *server) publish(gameID string, e event) {
+	for ch := range s.subscribers[gameID] {
+		select {
+		case ch <- e:
+		default:
+			delete(s.subscribers[gameID], ch)
+			close(ch)
+		}
+	}
+}
+
+// Streams the events for a session as Server-Sent Events. The first event is
+// always the current state of the game.
+func (s *server) streamEvents(c *gin.Context) {
+	s.mutex.Lock()
+	sess := s.loadSession(c)
+	if sess == nil {
+		s.mutex.Unlock()
+		return
+	}
+	initial := s.formatSession(sess)
+	events := s.subscribe(sess.ID)
+	s.mutex.Unlock()
+	defer s.unsubscribe(sess.ID, events)
+
+	// Flush the initial event right away, rather than holding it until the
+	// next event or keepalive.
+	c.SSEvent("game", initial)
+	c.Writer.Flush()
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(e.name, e.data)
+			return true
+		case <-keepalive.C:
+			_, err := io.WriteString(w, ":\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
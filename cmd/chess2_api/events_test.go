@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Reads the next Server-Sent Event from the stream, and returns its name and
+// decoded data.
+func readEvent(t *testing.T, r *bufio.Reader) (string, map[string]interface{}) {
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if name == "" && data == "" {
+				continue
+			}
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(data), &decoded))
+			return name, decoded
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimPrefix(line, "data:")
+		}
+	}
+}
+
+// Returns the number of clients watching the game.
+func (ts *testServer) subscriberCount(id string) int {
+	ts.server.mutex.Lock()
+	defer ts.server.mutex.Unlock()
+	return len(ts.server.subscribers[id])
+}
+
+func TestEvents(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	id := ts.newGame("c", "c", nil)
+	httpServer := httptest.NewServer(ts.router)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/games/" + id + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+
+	name, data := readEvent(t, stream)
+	assert.Equal(t, "game", name)
+	assert.Equal(t, id, data["id"])
+	assert.Nil(t, data["last_move"])
+	assert.Equal(t, 1, ts.subscriberCount(id))
+
+	ts.play(id, 0, "e2e4")
+	name, data = readEvent(t, stream)
+	assert.Equal(t, "game", name)
+	assert.Equal(t, "e2e4", data["last_move"])
+
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.subscriberCount(id) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, ts.subscriberCount(id))
+	ts.server.mutex.Lock()
+	_, found := ts.server.subscribers[id]
+	ts.server.mutex.Unlock()
+	assert.False(t, found)
+}
+
+func TestEventsNotFound(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	code, _ := ts.request("GET", "/games/0123456789abcdef/events", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Empty(t, ts.server.subscribers)
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+)
+
+// A session is a game hosted by the server. Only the starting position, the
+// moves, and the actions are authoritative; everything else is derived from
+// them when the session is loaded.
+type session struct {
+	ID      string    `json:"id"`
+	White   string    `json:"white"`
+	Black   string    `json:"black"`
+	Start   string    `json:"start"`
+	Moves   []string  `json:"moves"`
+	Result  string    `json:"result,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// Chess960 is the number of the Chess960 position that the game started
+	// from, if any.
+	Chess960 *int `json:"chess960,omitempty"`
+	// TimeControl is nil for untimed games. For timed games, MoveTimes has
+	// the time that each move was made, Interruptions has the times that the
+	// opponent spent deciding duels, and the clock starts when the session
+	// is created.
+	TimeControl   *timeControl   `json:"time_control,omitempty"`
+	MoveTimes     []time.Time    `json:"move_times,omitempty"`
+	Interruptions []interruption `json:"interruptions,omitempty"`
+	// Actions are the resignations and draw offers made by the players.
+	Actions []sessionAction `json:"actions,omitempty"`
+	// Tokens are the secrets issued to the players when the session is
+	// created, indexed by ColorIdx. A player's token is needed to make moves
+	// and take actions as that player.
+	Tokens [2]string `json:"tokens"`
+	// Duel is the move whose duels are being decided, if any, so that the
+	// duel can carry on after the server restarts.
+	Duel *sessionDuel `json:"duel,omitempty"`
+
+	record *chess2.GameRecord
+	clock  *chess2.Clock
+}
+
+// A timeControl is a chess2.TimeControl with every duration in seconds.
+type timeControl struct {
+	Base      float64 `json:"base"`
+	Increment float64 `json:"increment"`
+	Delay     float64 `json:"delay"`
+	MoveLimit float64 `json:"move_limit"`
+}
+
+// An interruption is a time during a turn when the opponent of the player to
+// move was deciding whether to challenge a capture.
+type interruption struct {
+	// Ply is the number of moves that had been made when the interruption
+	// started.
+	Ply   int       `json:"ply"`
+	Start time.Time `json:"start"`
+	// End is zero while the opponent is still deciding.
+	End time.Time `json:"end"`
+}
+
+// A sessionAction is something a player did other than making a move.
+type sessionAction struct {
+	// Action is one of resign, offer_draw, accept_draw, decline_draw, or
+	// claim_draw.
+	Action string `json:"action"`
+	Color  string `json:"color"`
+	// Ply is the number of moves that had been made when the action was
+	// taken.
+	Ply  int       `json:"ply"`
+	Time time.Time `json:"time"`
+}
+
+// A sessionDuel is a move in a session whose duels are still being decided.
+type sessionDuel struct {
+	// ID is the ID of the pending duel.
+	ID string `json:"id"`
+	// Move has the duels decided so far, including the secret challenge of
+	// the current duel, and Index is the duel currently being decided.
+	Move  string `json:"move"`
+	Index int    `json:"index"`
+}
+
+// Rebuilds the pending move described by the sessionDuel by repeating the
+// decisions made so far.
+func resumePendingMove(game chess2.Game, d *sessionDuel) (*chess2.PendingMove, error) {
+	decided, err := chess2.ParseUci(d.Move)
+	if err != nil {
+		return nil, err
+	}
+	move := decided
+	move.Duels = [len(move.Duels)]chess2.Duel{}
+	p, err := chess2.NewPendingMove(game, move)
+	if err != nil {
+		return nil, err
+	}
+	for p.Phase() != chess2.DuelPhaseComplete {
+		duel := decided.Duels[p.Index()]
+		switch {
+		case p.Phase() == chess2.DuelPhaseResponse && duel.IsComplete():
+			err = p.Respond(duel.Response(), duel.Gain())
+		case p.Phase() == chess2.DuelPhaseChallenge && duel.IsStarted():
+			err = p.Challenge(duel.Challenge())
+		case p.Phase() == chess2.DuelPhaseChallenge && p.Index() < d.Index:
+			err = p.Decline()
+		default:
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("session has a duel which is already decided")
+}
+
+// Performs the action on the GameRecord.
+func applyAction(record *chess2.GameRecord, action string, color chess2.Color) error {
+	switch action {
+	case "resign":
+		return record.Resign(color)
+	case "offer_draw":
+		return record.OfferDraw(color)
+	case "accept_draw":
+		return record.AcceptDraw(color)
+	case "decline_draw":
+		return record.DeclineDraw(color)
+	case "claim_draw":
+		return record.ClaimDraw(color)
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+func (tc *timeControl) validate() error {
+	if tc.Base < 0 || tc.Increment < 0 || tc.Delay < 0 || tc.MoveLimit < 0 {
+		return errors.New("time control must not be negative")
+	} else if tc.Base == 0 && tc.MoveLimit == 0 {
+		return errors.New("time control must have a base or move_limit")
+	}
+	return nil
+}
+
+func (tc *timeControl) toTimeControl() chess2.TimeControl {
+	return chess2.TimeControl{
+		Base:      seconds(tc.Base),
+		Increment: seconds(tc.Increment),
+		Delay:     seconds(tc.Delay),
+		MoveLimit: seconds(tc.MoveLimit),
+	}
+}
+
+// Replays the moves and actions of the session to rebuild its GameRecord.
+func (s *session) load() error {
+	start, err := chess2.ParseEpd(s.Start)
+	if err != nil {
+		return err
+	}
+	s.record = chess2.NewGameRecord(start)
+	if s.TimeControl != nil {
+		if len(s.MoveTimes) != len(s.Moves) {
+			return errors.New("session is missing move times")
+		}
+		s.clock = chess2.NewClock(s.TimeControl.toTimeControl())
+		s.clock.Start(&start, s.Created)
+	}
+	interruptions := s.Interruptions
+	actions := s.Actions
+	replayActions := func(ply int) error {
+		for len(interruptions) > 0 && interruptions[0].Ply == ply {
+			if s.clock != nil {
+				s.clock.Interrupt(interruptions[0].Start)
+				if !interruptions[0].End.IsZero() {
+					s.clock.Resume(interruptions[0].End)
+				}
+			}
+			interruptions = interruptions[1:]
+		}
+		for len(actions) > 0 && actions[0].Ply == ply {
+			if err := s.replayAction(actions[0]); err != nil {
+				return err
+			}
+			actions = actions[1:]
+		}
+		return nil
+	}
+	for i, uci := range s.Moves {
+		if err := replayActions(i); err != nil {
+			return err
+		}
+		move, err := chess2.ParseUci(uci)
+		if err != nil {
+			return err
+		}
+		if err := s.record.ApplyMove(move); err != nil {
+			return err
+		}
+		if s.clock != nil {
+			game := s.record.Game()
+			s.clock.Press(&game, s.MoveTimes[i])
+		}
+	}
+	if err := replayActions(len(s.Moves)); err != nil {
+		return err
+	} else if len(actions) > 0 {
+		return errors.New("session has actions out of order")
+	} else if len(interruptions) > 0 {
+		return errors.New("session has interruptions out of order")
+	}
+	if s.clock != nil {
+		// A session is saved as soon as it is noticed that a player has
+		// run out of time.
+		s.checkTime(s.Updated)
+	}
+	return nil
+}
+
+// Adds a legal move, made at the given time, to the session.
+func (s *session) applyMove(move chess2.Move, now time.Time) error {
+	if err := s.record.ApplyMove(move); err != nil {
+		return err
+	}
+	s.Moves = append(s.Moves, move.String())
+	s.Duel = nil
+	s.Updated = now
+	if s.clock != nil {
+		s.MoveTimes = append(s.MoveTimes, now)
+		game := s.record.Game()
+		s.clock.Press(&game, now)
+	}
+	s.updateResult()
+	return nil
+}
+
+// Adds an action, taken at the given time, to the session.
+func (s *session) applyAction(action string, color chess2.Color, now time.Time) error {
+	a := sessionAction{
+		Action: action,
+		Color:  color.String(),
+		Ply:    len(s.Moves),
+		Time:   now,
+	}
+	if err := s.replayAction(a); err != nil {
+		return err
+	}
+	s.Actions = append(s.Actions, a)
+	s.Updated = now
+	s.updateResult()
+	return nil
+}
+
+// Performs an action which is already part of the session.
+func (s *session) replayAction(a sessionAction) error {
+	color, err := parseColor(a.Color)
+	if err != nil {
+		return err
+	}
+	if err := applyAction(s.record, a.Action, color); err != nil {
+		return err
+	}
+	game := s.record.Game()
+	if s.clock != nil && game.GameState() != chess2.GameInProgress {
+		s.clock.Stop(a.Time)
+	}
+	return nil
+}
+
+// Times the duel which is waiting for the given decision: while the opponent
+// of the player to move decides whether to challenge, their clock runs
+// instead.
+func (s *session) timeDuel(phase chess2.DuelPhase, now time.Time) {
+	if s.clock == nil {
+		return
+	}
+	last := len(s.Interruptions) - 1
+	interrupted := last >= 0 && s.Interruptions[last].Ply == len(s.Moves) &&
+		s.Interruptions[last].End.IsZero()
+	if phase == chess2.DuelPhaseChallenge && !interrupted {
+		s.Interruptions = append(s.Interruptions, interruption{Ply: len(s.Moves), Start: now})
+		s.clock.Interrupt(now)
+	} else if phase != chess2.DuelPhaseChallenge && interrupted {
+		s.Interruptions[last].End = now
+		s.clock.Resume(now)
+	}
+}
+
+// Returns the color of the player who was issued the token.
+func (s *session) seat(token string) (chess2.Color, error) {
+	for _, color := range []chess2.Color{chess2.ColorWhite, chess2.ColorBlack} {
+		if validToken(token, s.Tokens[chess2.ColorIdx(color)]) {
+			return color, nil
+		}
+	}
+	return chess2.ColorWhite, errSeatForbidden
+}
+
+// Ends the game if the player whose clock is running has run out of time at
+// the given time. Returns true if the game ended.
+func (s *session) checkTime(now time.Time) bool {
+	game := s.record.Game()
+	if s.clock == nil || game.GameState() != chess2.GameInProgress || !s.clock.Flagged(now) {
+		return false
+	}
+	color, _ := s.clock.Running()
+	s.record.Timeout(color)
+	s.Updated = now
+	s.updateResult()
+	return true
+}
+
+// Sets the result once the game is over, abandoning any move whose duels are
+// being decided.
+func (s *session) updateResult() {
+	game := s.record.Game()
+	switch game.GameState() {
+	case chess2.GameOverWhite:
+		s.Result = "white"
+	case chess2.GameOverBlack:
+		s.Result = "black"
+	case chess2.GameOverDraw:
+		s.Result = "draw"
+	default:
+		return
+	}
+	s.Duel = nil
+}
+
+// Returns a copy of the session which can be changed independently.
+func (s *session) clone() *session {
+	clone := *s
+	clone.Moves = append([]string(nil), s.Moves...)
+	clone.MoveTimes = append([]time.Time(nil), s.MoveTimes...)
+	clone.Interruptions = append([]interruption(nil), s.Interruptions...)
+	clone.Actions = append([]sessionAction(nil), s.Actions...)
+	if s.Duel != nil {
+		duel := *s.Duel
+		clone.Duel = &duel
+	}
+	if s.record != nil {
+		clone.record = s.record.Clone()
+	}
+	if s.clock != nil {
+		clock := *s.clock
+		clone.clock = &clock
+	}
+	return &clone
+}
+
+var errGameNotFound = errors.New("no such game")
+
+// A gameStore persists sessions. Implementations must be safe for concurrent
+// use, but callers are responsible for serializing updates to a single
+// session.
+type gameStore interface {
+	// Get loads the session with the given ID, or returns errGameNotFound.
+	Get(id string) (*session, error)
+	// Put saves the session, replacing any previous version.
+	Put(s *session) error
+}
+
+// memoryStore keeps sessions in memory. They are lost when the server exits.
+// The sessions are kept loaded, so unlike fileStore, the moves don't have to
+// be replayed for every request.
+type memoryStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*session
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{sessions: make(map[string]*session)}
+}
+
+func (m *memoryStore) Get(id string) (*session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, found := m.sessions[id]
+	if !found {
+		return nil, errGameNotFound
+	}
+	return s.clone(), nil
+}
+
+// Put saves the session, which must have been loaded.
+func (m *memoryStore) Put(s *session) error {
+	if s.record == nil {
+		return errors.New("session is not loaded")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[s.ID] = s.clone()
+	return nil
+}
+
+// fileStore keeps each session in a JSON file in a directory.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// Session IDs are generated by the server, so anything else is certainly not
+// in the store. This also keeps IDs from escaping the directory.
+var reSessionID = regexp.MustCompile("^[0-9a-f]+$")
+
+func (f *fileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *fileStore) Get(id string) (*session, error) {
+	if !reSessionID.MatchString(id) {
+		return nil, errGameNotFound
+	}
+	data, err := ioutil.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return nil, errGameNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (f *fileStore) Put(s *session) error {
+	if !reSessionID.MatchString(s.ID) {
+		return errors.New("invalid session ID")
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a crash never leaves a
+	// partially written session behind.
+	tmp, err := ioutil.TempFile(f.dir, s.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(s.ID))
+}
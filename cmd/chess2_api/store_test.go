@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Calls the function with a new fileStore in a temporary directory, and a
+// function which opens the directory again, as after a restart.
+func withFileStore(t *testing.T, f func(store *fileStore, reopen func() *fileStore)) {
+	dir, err := ioutil.TempDir("", "chess2_api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	reopen := func() *fileStore {
+		store, err := newFileStore(dir)
+		require.NoError(t, err)
+		return store
+	}
+	f(reopen(), reopen)
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	withFileStore(t, func(store *fileStore, reopen func() *fileStore) {
+		ts := newTestServer(t, store)
+		id := ts.newGame("c", "k", &timeControl{Base: 60, Increment: 1})
+		ts.play(id, 1, "e2e4", "d7d5")
+		ts.mustRequest("POST", "/games/"+id+"/actions", gin.H{"action": "offer_draw", "token": ts.tokens[id]["black"]})
+		before := ts.mustRequest("GET", "/games/"+id, nil)
+
+		sess, err := reopen().Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, id, sess.ID)
+		assert.Equal(t, []string{"e2e4", "d7d5"}, sess.Moves)
+		assert.Len(t, sess.MoveTimes, 2)
+		assert.Len(t, sess.Actions, 1)
+		assert.Equal(t, ts.tokens[id]["white"], sess.Tokens[0])
+		assert.Equal(t, ts.tokens[id]["black"], sess.Tokens[1])
+
+		restarted := newTestServer(t, reopen())
+		restarted.now = ts.now
+		assert.Equal(t, before, restarted.mustRequest("GET", "/games/"+id, nil))
+	})
+}
+
+func TestFileStoreNotFound(t *testing.T) {
+	withFileStore(t, func(store *fileStore, reopen func() *fileStore) {
+		for _, id := range []string{"0123456789abcdef", "../chess2_api", ""} {
+			_, err := store.Get(id)
+			assert.Equal(t, errGameNotFound, err, "ID: %s", id)
+		}
+		assert.Error(t, store.Put(&session{ID: "../escape"}))
+	})
+}
+
+func TestPendingDuelRestart(t *testing.T) {
+	withFileStore(t, func(store *fileStore, reopen func() *fileStore) {
+		ts := newTestServer(t, store)
+		id := ts.newGame("c", "c", nil)
+		ts.play(id, 0, "e2e4", "d7d5")
+		response := ts.play(id, 0, "e4d5")
+		duelID := response["pending_duel"].(map[string]interface{})["id"].(string)
+		ts.mustRequest("POST", "/duel/"+duelID+"/challenge", ts.bid(id, "black", 1))
+
+		restarted := newTestServer(t, reopen())
+		restarted.tokens = ts.tokens
+		response = restarted.mustRequest("GET", "/duel/"+duelID, nil)
+		pending := response["pending_duel"].(map[string]interface{})
+		assert.Equal(t, "response", pending["phase"])
+		assert.Equal(t, "e4d5", pending["move"])
+		response = restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Equal(t, duelID, response["pending_duel"].(map[string]interface{})["id"])
+
+		response = restarted.mustRequest("POST", "/duel/"+duelID+"/response", restarted.bid(id, "white", 2))
+		assert.Equal(t, "e4d5:12", response["last_move"])
+		assert.Nil(t, response["pending_duel"])
+		assert.Equal(t, "rnbqkbnr/ppp1pppp/8/3P4/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2 cc 22", response["epd"])
+
+		// The duel is over, so it isn't resumed by the next restart.
+		restarted = newTestServer(t, reopen())
+		response = restarted.mustRequest("GET", "/games/"+id, nil)
+		assert.Nil(t, response["pending_duel"])
+		code, _ := restarted.request("GET", "/duel/"+duelID, nil)
+		assert.Equal(t, 404, code)
+	})
+}
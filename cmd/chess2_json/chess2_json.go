@@ -8,12 +8,107 @@ import (
 	"sort"
 
 	"github.com/CGamesPlay/chess2/pkg/chess2"
+
+	"github.com/spf13/pflag"
 )
 
+var armyFiles = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
+
 type requestStruct struct {
 	Armies string `json:"armies"`
+	// Chess960 is the number of the Chess960 position to start the armies
+	// from, if any.
+	Chess960 *int `json:"chess960"`
+	// Stones are the starting stones for the armies, written as in an EPD
+	// like "33" or "12/9".
+	Stones string `json:"stones"`
 	Epd    string `json:"epd"`
-	Move   string `json:"move"`
+	// Moves are the moves played since the position, before the move or
+	// action, so that repetitions of earlier positions are known.
+	Moves []string `json:"moves"`
+	Move  string   `json:"move"`
+	// Action is resign, offer_draw, accept_draw, decline_draw, or
+	// claim_draw, taken by Color. DrawOffer is the player who has offered a
+	// draw in the position, if any.
+	Action    string `json:"action"`
+	Color     string `json:"color"`
+	DrawOffer string `json:"draw_offer"`
+}
+
+// Sets the stones of a new game, written as in an EPD.
+func setStones(game *chess2.Game, value string) error {
+	stones, max, err := chess2.ParseStones(value)
+	if err != nil {
+		return fmt.Errorf("Invalid stones")
+	}
+	return game.SetStones(stones[0], stones[1], max)
+}
+
+func parseColor(value string) (chess2.Color, error) {
+	switch value {
+	case "white":
+		return chess2.ColorWhite, nil
+	case "black":
+		return chess2.ColorBlack, nil
+	}
+	return chess2.ColorWhite, fmt.Errorf("color must be white or black")
+}
+
+// Performs the action on the GameRecord.
+func applyAction(record *chess2.GameRecord, action string, color chess2.Color) error {
+	switch action {
+	case "resign":
+		return record.Resign(color)
+	case "offer_draw":
+		return record.OfferDraw(color)
+	case "accept_draw":
+		return record.AcceptDraw(color)
+	case "decline_draw":
+		return record.DeclineDraw(color)
+	case "claim_draw":
+		return record.ClaimDraw(color)
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// Plays the moves of the history on the GameRecord.
+func replayMoves(record *chess2.GameRecord, ucis []string) error {
+	for _, uci := range ucis {
+		move, err := chess2.ParseUci(uci)
+		if err == nil {
+			err = record.ApplyMove(move)
+		}
+		if err != nil {
+			return fmt.Errorf("illegal move in history: %s: %s", uci, err.Error())
+		}
+	}
+	return nil
+}
+
+// Takes the action in the request, responding with the game afterwards.
+func takeAction(record *chess2.GameRecord, request requestStruct) (map[string]interface{}, error) {
+	color, err := parseColor(request.Color)
+	if err != nil {
+		return nil, err
+	}
+	if request.DrawOffer != "" {
+		offer, err := parseColor(request.DrawOffer)
+		if err != nil {
+			return nil, err
+		} else if err := record.OfferDraw(offer); err != nil {
+			return nil, fmt.Errorf("invalid draw offer: %s", err.Error())
+		}
+	}
+	if err := applyAction(record, request.Action, color); err != nil {
+		return nil, err
+	}
+	response := formatGame(record.Game())
+	if offer, offered := record.DrawOffer(); offered {
+		response["draw_offer"] = offer.String()
+	} else {
+		response["draw_offer"] = nil
+	}
+	return response, nil
 }
 
 func formatGame(game chess2.Game) map[string]interface{} {
@@ -37,10 +132,20 @@ func formatGame(game chess2.Game) map[string]interface{} {
 	case chess2.GameOverDraw:
 		response["winner"] = "draw"
 	}
+	if result := game.Result(); result.Reason != chess2.EndReasonNone {
+		response["reason"] = result.Reason.String()
+	} else {
+		response["reason"] = nil
+	}
 	return response
 }
 
 func main() {
+	pflag.Parse()
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load army: ", err)
+		os.Exit(2)
+	}
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		data := scanner.Text()
@@ -51,15 +156,26 @@ func main() {
 			err = fmt.Errorf("Invalid JSON input")
 		} else if request.Armies != "" && request.Epd != "" {
 			err = fmt.Errorf("Either `epd` or `armies` must be provided; but not both")
+		} else if request.Chess960 != nil && request.Armies == "" {
+			err = fmt.Errorf("`chess960` requires `armies`")
+		} else if request.Stones != "" && request.Armies == "" {
+			err = fmt.Errorf("`stones` requires `armies`")
+		} else if request.Move != "" && request.Action != "" {
+			err = fmt.Errorf("Either `move` or `action` may be provided; but not both")
 		} else if request.Armies != "" {
 			if len(request.Armies) == 2 {
 				white, foundWhite := chess2.FindArmySymbol(rune(request.Armies[0]))
 				black, foundBlack := chess2.FindArmySymbol(rune(request.Armies[1]))
 				if !foundWhite || !foundBlack {
 					err = fmt.Errorf("Invalid armies")
+				} else if request.Chess960 != nil {
+					game, err = chess2.GameFromChess960(white, black, *request.Chess960)
 				} else {
 					game = chess2.GameFromArmies(white, black)
 				}
+				if err == nil && request.Stones != "" {
+					err = setStones(&game, request.Stones)
+				}
 			} else {
 				err = fmt.Errorf("Invalid armies")
 			}
@@ -67,8 +183,15 @@ func main() {
 			game, err = chess2.ParseEpd(request.Epd)
 		}
 		var response map[string]interface{}
+		record := chess2.NewGameRecord(game)
+		if err == nil {
+			err = replayMoves(record, request.Moves)
+			game = record.Game()
+		}
 		if err == nil {
-			if request.Move != "" {
+			if request.Action != "" {
+				response, err = takeAction(record, request)
+			} else if request.Move != "" {
 				var move chess2.Move
 				move, err = chess2.ParseUci(request.Move)
 				if err == nil {
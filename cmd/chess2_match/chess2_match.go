@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+
+	"github.com/spf13/pflag"
+)
+
+// allArmies is the EPD symbol of every built-in army, for --armies all.
+const allArmies = "cnerka"
+
+var (
+	engine1Cmd = pflag.String("engine1", "", "command line of the first engine")
+	engine2Cmd = pflag.String("engine2", "", "command line of the second engine")
+	numGames   = pflag.IntP("games", "n", 100, "maximum number of games to play")
+	armies     = pflag.String("armies", "cc", "comma-separated army pairings to play, or \"all\"")
+	openings   = pflag.String("openings", "", "EPD file of starting positions, used instead of --armies")
+	chess960   = pflag.Bool("chess960", false, "start each pair of games from a random Chess960 position")
+	stones     = pflag.String("stones", "", "starting stones as in an EPD, like 33 or 12/9")
+	tcFlag     = pflag.String("tc", "", "time control as seconds per game plus increment, like 10+0.1")
+	moveTime   = pflag.Int("movetime", 0, "milliseconds per move")
+	depth      = pflag.IntP("depth", "d", 0, "depth to search each move")
+	maxPlies   = pflag.Int("max-plies", 400, "number of plies after which the game is drawn")
+	useSprt    = pflag.Bool("sprt", false, "stop once a sequential probability ratio test finishes")
+	elo0       = pflag.Float64("elo0", 0, "Elo difference of the SPRT null hypothesis")
+	elo1       = pflag.Float64("elo1", 10, "Elo difference of the SPRT alternative hypothesis")
+	alpha      = pflag.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta       = pflag.Float64("beta", 0.05, "SPRT false negative rate")
+	armyFiles  = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
+)
+
+func main() {
+	pflag.Parse()
+	if err := runMatch(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Sets the starting stones of the game from --stones, if given.
+func setStones(game *chess2.Game) error {
+	if *stones == "" {
+		return nil
+	}
+	counts, max, err := chess2.ParseStones(*stones)
+	if err == nil {
+		err = game.SetStones(counts[0], counts[1], max)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid --stones: %s", *stones)
+	}
+	return nil
+}
+
+// Returns the position to start a game from, which is the given start with the
+// back ranks shuffled when --chess960 is given.
+func startingPosition(start chess2.Game, rng *rand.Rand) (chess2.Game, error) {
+	if !*chess960 {
+		return start, nil
+	}
+	game, err := chess2.GameFromChess960(
+		start.Army(chess2.ColorWhite), start.Army(chess2.ColorBlack),
+		rng.Intn(chess2.Chess960Positions))
+	if err != nil {
+		return chess2.Game{}, err
+	}
+	if err := setStones(&game); err != nil {
+		return chess2.Game{}, err
+	}
+	return game, nil
+}
+
+func runMatch() error {
+	if *engine1Cmd == "" || *engine2Cmd == "" {
+		return fmt.Errorf("both --engine1 and --engine2 are required")
+	}
+	tc, err := parseTimeControl()
+	if err != nil {
+		return err
+	}
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		return err
+	}
+	var starts []chess2.Game
+	if *openings != "" && *chess960 {
+		return fmt.Errorf("--openings can't be used with --chess960")
+	} else if *openings != "" && *stones != "" {
+		return fmt.Errorf("--openings can't be used with --stones")
+	} else if *openings != "" {
+		starts, err = readOpenings(*openings)
+	} else {
+		starts, err = parseArmies(*armies)
+	}
+	if err != nil {
+		return err
+	}
+	for i := range starts {
+		if err := setStones(&starts[i]); err != nil {
+			return err
+		}
+	}
+
+	engine1, err := startEngine(*engine1Cmd)
+	if err != nil {
+		return err
+	}
+	defer engine1.close()
+	engine2, err := startEngine(*engine2Cmd)
+	if err != nil {
+		return err
+	}
+	defer engine2.close()
+	if engine1.name == engine2.name {
+		engine1.name += " (1)"
+		engine2.name += " (2)"
+	}
+
+	test := sprt{elo0: *elo0, elo1: *elo1, alpha: *alpha, beta: *beta}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var s score
+	var start chess2.Game
+	for i := 0; i < *numGames; i++ {
+		// Each starting position is played twice, with the engines swapping
+		// colors.
+		if i%2 == 0 {
+			start, err = startingPosition(starts[(i/2)%len(starts)], rng)
+			if err != nil {
+				return err
+			}
+		}
+		engines := [2]*engine{engine1, engine2}
+		if i%2 == 1 {
+			engines[0], engines[1] = engine2, engine1
+		}
+		result, err := playGame(engines, start, tc, *maxPlies)
+		if err != nil {
+			return err
+		}
+
+		outcome := "1/2-1/2"
+		switch result.state {
+		case chess2.GameOverWhite:
+			outcome = "1-0"
+		case chess2.GameOverBlack:
+			outcome = "0-1"
+		}
+		switch {
+		case result.state == chess2.GameOverDraw:
+			s.draws++
+		case (result.state == chess2.GameOverWhite) == (i%2 == 0):
+			s.wins++
+		default:
+			s.losses++
+		}
+		fmt.Printf("Game %d: %s vs %s: %s", i+1, engines[0].name, engines[1].name, outcome)
+		if result.reason != "" {
+			fmt.Printf(" {%s}", result.reason)
+		}
+		fmt.Printf(" (%s)\n", chess2.EncodeEpd(start))
+		fmt.Printf("Score of %s vs %s: %d - %d - %d [%.3f] %d\n",
+			engine1.name, engine2.name, s.wins, s.losses, s.draws, s.mean(), s.games())
+
+		if *useSprt && test.status(s) != hypothesisNone {
+			break
+		}
+	}
+
+	elo, margin := s.elo()
+	fmt.Printf("Elo difference: %s +/- %s\n", formatElo(elo), formatElo(margin))
+	if *useSprt {
+		lower, upper := test.bounds()
+		fmt.Printf("SPRT: llr %.2f, lbound %.2f, ubound %.2f", test.llr(s), lower, upper)
+		switch test.status(s) {
+		case hypothesisNone:
+			fmt.Printf(" - no hypothesis was accepted\n")
+		default:
+			fmt.Printf(" - %s was accepted\n", test.status(s))
+		}
+	}
+	return nil
+}
+
+func formatElo(elo float64) string {
+	if math.IsInf(elo, 0) || math.IsNaN(elo) {
+		return "inf"
+	}
+	return fmt.Sprintf("%.1f", elo)
+}
+
+func parseTimeControl() (timeControl, error) {
+	tc := timeControl{
+		depth:    *depth,
+		moveTime: time.Duration(*moveTime) * time.Millisecond,
+	}
+	if *tcFlag != "" {
+		parts := strings.SplitN(*tcFlag, "+", 2)
+		base, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || base <= 0 {
+			return tc, fmt.Errorf("invalid time control: %s", *tcFlag)
+		}
+		tc.base = time.Duration(base * float64(time.Second))
+		if len(parts) == 2 {
+			inc, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || inc < 0 {
+				return tc, fmt.Errorf("invalid time control: %s", *tcFlag)
+			}
+			tc.inc = time.Duration(inc * float64(time.Second))
+		}
+	} else if tc.depth == 0 && tc.moveTime == 0 {
+		tc.moveTime = 100 * time.Millisecond
+	}
+	return tc, nil
+}
+
+// Parses a list of army pairings like "cc,nk" into starting positions.
+func parseArmies(list string) ([]chess2.Game, error) {
+	if list == "all" {
+		var games []chess2.Game
+		for _, white := range allArmies {
+			for _, black := range allArmies {
+				w, _ := chess2.FindArmySymbol(white)
+				b, _ := chess2.FindArmySymbol(black)
+				games = append(games, chess2.GameFromArmies(w, b))
+			}
+		}
+		return games, nil
+	}
+	var games []chess2.Game
+	for _, pair := range strings.Split(list, ",") {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid armies: %s", pair)
+		}
+		white, foundWhite := chess2.FindArmySymbol(rune(pair[0]))
+		black, foundBlack := chess2.FindArmySymbol(rune(pair[1]))
+		if !foundWhite || !foundBlack {
+			return nil, fmt.Errorf("invalid armies: %s", pair)
+		}
+		games = append(games, chess2.GameFromArmies(white, black))
+	}
+	return games, nil
+}
+
+// Reads starting positions from an EPD file with one position per line. Any
+// operations after the position, such as those in the perft files, are
+// ignored.
+func readOpenings(filename string) ([]chess2.Game, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var games []chess2.Game
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		epd := strings.TrimSpace(strings.SplitN(scanner.Text(), ";", 2)[0])
+		if epd == "" {
+			continue
+		}
+		game, err := chess2.ParseEpd(epd)
+		if err != nil {
+			return nil, fmt.Errorf("%v (epd: %s)", err, epd)
+		}
+		games = append(games, game)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(games) == 0 {
+		return nil, fmt.Errorf("%s: no positions", filename)
+	}
+	return games, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// How long an engine is given to report its move after being told to
+	// stop.
+	stopGrace = 5 * time.Second
+	// How long an engine is given to bid in a duel when there is no clock.
+	duelTimeout = 10 * time.Second
+)
+
+var errTimeout = errors.New("timed out")
+
+// An engine is a running engine process which speaks the chess2_uci protocol.
+type engine struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// lines receives each line of the engine's output. It is closed when the
+	// engine exits.
+	lines chan string
+}
+
+// Starts the given command line and waits for it to complete the handshake.
+func startEngine(command string) (*engine, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no engine command given")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	e := &engine{
+		name:  args[0],
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan string, 64),
+	}
+	go func() {
+		defer close(e.lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			e.lines <- scanner.Text()
+		}
+	}()
+
+	e.send("uci")
+	for {
+		line, ok := <-e.lines
+		if !ok {
+			return nil, fmt.Errorf("%s: exited during handshake", command)
+		}
+		if strings.HasPrefix(line, "id name ") {
+			e.name = strings.TrimPrefix(line, "id name ")
+		} else if line == "uciok" {
+			break
+		}
+	}
+	if err := e.sync(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *engine) send(format string, args ...interface{}) {
+	fmt.Fprintf(e.stdin, format+"\n", args...)
+}
+
+// Waits until the engine has finished processing every command sent so far.
+func (e *engine) sync() error {
+	e.send("isready")
+	_, err := e.expect("readyok", 0)
+	return err
+}
+
+// Waits for a line beginning with the given keyword and returns the rest of
+// it. Other lines, such as info lines, are discarded. A timeout of 0 waits
+// forever.
+func (e *engine) expect(keyword string, timeout time.Duration) (string, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return "", fmt.Errorf("%s: engine exited", e.name)
+			}
+			if line == keyword || strings.HasPrefix(line, keyword+" ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, keyword)), nil
+			}
+		case <-deadline:
+			return "", errTimeout
+		}
+	}
+}
+
+// Starts a search and waits for the best move. If the search takes longer
+// than the timeout, the engine is stopped and errTimeout is returned.
+func (e *engine) bestMove(goArgs string, timeout time.Duration) (string, time.Duration, error) {
+	start := time.Now()
+	e.send("go %s", goArgs)
+	move, err := e.expect("bestmove", timeout)
+	elapsed := time.Since(start)
+	if err == errTimeout {
+		e.send("stop")
+		if _, err := e.expect("bestmove", stopGrace); err != nil {
+			return "", elapsed, err
+		}
+		return "", elapsed, errTimeout
+	}
+	return move, elapsed, err
+}
+
+// Waits for a bid in a duel, which is reported on a line beginning with the
+// given keyword. If the bid takes longer than the timeout, errTimeout is
+// returned.
+func (e *engine) duelBid(keyword string, timeout time.Duration) (string, time.Duration, error) {
+	start := time.Now()
+	bid, err := e.expect(keyword, timeout)
+	return bid, time.Since(start), err
+}
+
+// Asks the engine to exit, and waits for it to do so.
+func (e *engine) close() error {
+	e.send("quit")
+	e.stdin.Close()
+	for range e.lines {
+	}
+	return e.cmd.Wait()
+}
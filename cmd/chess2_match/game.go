@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+)
+
+// A timeControl limits how long the engines may think about each move.
+type timeControl struct {
+	// Depth and MoveTime limit every search. They are ignored if Base is set.
+	depth    int
+	moveTime time.Duration
+	// Each player has base time for the whole game, plus inc added after
+	// each move.
+	base, inc time.Duration
+}
+
+// The arguments to the go command for the player to move, given the time left
+// on both clocks.
+func (tc timeControl) goArgs(clocks [2]time.Duration) string {
+	if tc.base > 0 {
+		return fmt.Sprintf("wtime %d btime %d winc %d binc %d",
+			clocks[0].Milliseconds(), clocks[1].Milliseconds(),
+			tc.inc.Milliseconds(), tc.inc.Milliseconds())
+	}
+	var args []string
+	if tc.depth > 0 {
+		args = append(args, fmt.Sprintf("depth %d", tc.depth))
+	}
+	if tc.moveTime > 0 {
+		args = append(args, fmt.Sprintf("movetime %d", tc.moveTime.Milliseconds()))
+	}
+	return strings.Join(args, " ")
+}
+
+// A gameResult is the outcome of a single game.
+type gameResult struct {
+	state chess2.GameState
+	// reason explains why the game ended.
+	reason string
+	// moves is every move played, including duels.
+	moves []string
+}
+
+// Plays a game from the given position between the engines, which are indexed
+// by color.
+func playGame(engines [2]*engine, start chess2.Game, tc timeControl, maxPlies int) (gameResult, error) {
+	for _, e := range engines {
+		e.send("ucinewgame")
+		if err := e.sync(); err != nil {
+			return gameResult{}, err
+		}
+	}
+
+	var result gameResult
+	game := start
+	clocks := [2]time.Duration{tc.base, tc.base}
+	forfeit := func(colorIdx int, format string, args ...interface{}) (gameResult, error) {
+		result.state = chess2.GameOverWhite
+		if colorIdx == 0 {
+			result.state = chess2.GameOverBlack
+		}
+		result.reason = fmt.Sprintf(format, args...)
+		return result, nil
+	}
+	// Duels are timed like moves, and charged to the clock of the player
+	// bidding. Without a clock, each bid has duelTimeout.
+	duelBid := func(colorIdx int, keyword string) (string, error) {
+		timeout := duelTimeout
+		if tc.base > 0 {
+			if clocks[colorIdx] <= 0 {
+				return "", errTimeout
+			}
+			timeout = clocks[colorIdx]
+		}
+		bid, elapsed, err := engines[colorIdx].duelBid(keyword, timeout)
+		if tc.base > 0 {
+			clocks[colorIdx] -= elapsed
+		}
+		return bid, err
+	}
+	position := func() string {
+		cmd := "position epd " + chess2.EncodeEpd(start)
+		if len(result.moves) > 0 {
+			cmd += " moves " + strings.Join(result.moves, " ")
+		}
+		return cmd
+	}
+
+	for game.GameState() == chess2.GameInProgress {
+		if len(result.moves) >= maxPlies {
+			result.state = chess2.GameOverDraw
+			result.reason = "move limit"
+			return result, nil
+		}
+		colorIdx := chess2.ColorIdx(game.ToMove())
+		attacker, defender := engines[colorIdx], engines[1-colorIdx]
+
+		var timeout time.Duration
+		if tc.base > 0 {
+			if clocks[colorIdx] <= 0 {
+				return forfeit(colorIdx, "%s lost on time", attacker.name)
+			}
+			timeout = clocks[colorIdx]
+		}
+		attacker.send(position())
+		reply, elapsed, err := attacker.bestMove(tc.goArgs(clocks), timeout)
+		if err == errTimeout {
+			return forfeit(colorIdx, "%s lost on time", attacker.name)
+		} else if err != nil {
+			return result, err
+		}
+		if tc.base > 0 {
+			clocks[colorIdx] += tc.inc - elapsed
+		}
+		fields := strings.Fields(reply)
+		if len(fields) == 0 {
+			return forfeit(colorIdx, "%s made no move", attacker.name)
+		}
+		move, err := chess2.ParseUci(fields[0])
+		if err != nil {
+			return forfeit(colorIdx, "%s made an invalid move %s", attacker.name, fields[0])
+		}
+		pending, err := chess2.NewPendingMove(game, move)
+		if err != nil {
+			return forfeit(colorIdx, "%s made an illegal move %s", attacker.name, fields[0])
+		}
+
+		if pending.Phase() != chess2.DuelPhaseComplete {
+			defender.send(position())
+		}
+		for pending.Phase() != chess2.DuelPhaseComplete {
+			switch pending.Phase() {
+			case chess2.DuelPhaseChallenge:
+				defender.send("duel challenge %s %d", pending.Move(), pending.Index())
+				bid, err := duelBid(1-colorIdx, "bestchallenge")
+				if err == errTimeout {
+					return forfeit(1-colorIdx, "%s lost on time", defender.name)
+				} else if err != nil {
+					return result, err
+				}
+				if bid == "none" {
+					err = pending.Decline()
+				} else if n, convErr := strconv.Atoi(bid); convErr != nil {
+					err = convErr
+				} else {
+					err = pending.Challenge(n)
+				}
+				if err != nil {
+					return forfeit(1-colorIdx, "%s made an invalid challenge %s", defender.name, bid)
+				}
+			case chess2.DuelPhaseResponse:
+				attacker.send("duel response %s %d", pending.Move(), pending.Index())
+				bid, err := duelBid(colorIdx, "bestresponse")
+				if err == errTimeout {
+					return forfeit(colorIdx, "%s lost on time", attacker.name)
+				} else if err != nil {
+					return result, err
+				}
+				n, convErr := strconv.Atoi(strings.TrimRight(bid, "+-"))
+				if convErr != nil {
+					err = convErr
+				} else {
+					err = pending.Respond(n, !strings.HasSuffix(bid, "-"))
+				}
+				if err != nil {
+					return forfeit(colorIdx, "%s made an invalid response %s", attacker.name, bid)
+				}
+			}
+		}
+
+		result.moves = append(result.moves, pending.Move().String())
+		if game, err = pending.Apply(); err != nil {
+			return result, err
+		}
+	}
+	final := game.Result()
+	result.state = final.State
+	result.reason = final.Reason.String()
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubEngineEnv is set in the environment of the test binary when it is run
+// as a stub engine by TestStubEngine.
+const stubEngineEnv = "CHESS2_MATCH_STUB_ENGINE"
+
+// Starts the test binary as a stub engine which behaves in the given way.
+func startStubEngine(t *testing.T, behavior string) *engine {
+	os.Setenv(stubEngineEnv, "1")
+	defer os.Unsetenv(stubEngineEnv)
+	e, err := startEngine(os.Args[0] + " -test.run=^TestStubEngine$ -- " + behavior)
+	require.NoError(t, err)
+	return e
+}
+
+// TestStubEngine isn't a test. It is run by startStubEngine as an engine which
+// plays the first legal move, preferring captures, and never challenges.
+// Depending on its behavior, it instead:
+//   - hang: never finishes a search until it is stopped.
+//   - illegal: always moves e2e5.
+//   - silent: never answers a duel.
+func TestStubEngine(t *testing.T) {
+	if os.Getenv(stubEngineEnv) == "" {
+		return
+	}
+	defer os.Exit(0)
+	behavior := flag.Arg(0)
+	var game chess2.Game
+	searching := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Printf("id name stub-%s\nuciok\n", behavior)
+		case "isready":
+			fmt.Println("readyok")
+		case "position":
+			var moves []string
+			for i, field := range fields {
+				if field == "moves" {
+					fields, moves = fields[:i], fields[i+1:]
+					break
+				}
+			}
+			game, _ = chess2.ParseEpd(strings.Join(fields[2:], " "))
+			for _, str := range moves {
+				move, _ := chess2.ParseUci(str)
+				game = game.ApplyMove(move)
+			}
+		case "go":
+			switch behavior {
+			case "hang":
+				searching = true
+			case "illegal":
+				fmt.Println("bestmove e2e5")
+			default:
+				moves := game.GenerateLegalMoves()
+				best := moves[0]
+				for _, move := range moves {
+					if len(game.DuelOpportunities(move)) > 0 {
+						best = move
+						break
+					}
+				}
+				fmt.Printf("bestmove %s\n", best)
+			}
+		case "stop":
+			if searching {
+				searching = false
+				fmt.Println("bestmove 0000")
+			}
+		case "duel":
+			if behavior == "silent" {
+				continue
+			} else if fields[1] == "challenge" {
+				fmt.Println("bestchallenge none")
+			} else {
+				fmt.Println("bestresponse 0+")
+			}
+		case "quit":
+			return
+		}
+	}
+}
+
+func TestPlayGame(t *testing.T) {
+	const capture = "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 cc 33"
+	cases := map[string]struct {
+		white, black string
+		start        string
+		tc           timeControl
+		maxPlies     int
+		state        chess2.GameState
+		reason       string
+		moves        []string
+	}{
+		"move limit": {
+			white: "first", black: "first",
+			start:    capture,
+			tc:       timeControl{depth: 1},
+			maxPlies: 3,
+			state:    chess2.GameOverDraw,
+			reason:   "move limit",
+			moves:    []string{"e4d5", "d8d5", "a2a4"},
+		},
+		"timeout on a move": {
+			white: "hang", black: "first",
+			start:  capture,
+			tc:     timeControl{base: 100 * time.Millisecond},
+			state:  chess2.GameOverBlack,
+			reason: "stub-hang lost on time",
+		},
+		"timeout in a duel": {
+			white: "first", black: "silent",
+			start:  capture,
+			tc:     timeControl{base: 100 * time.Millisecond},
+			state:  chess2.GameOverWhite,
+			reason: "stub-silent lost on time",
+		},
+		"illegal move": {
+			white: "first", black: "illegal",
+			start:    "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1 cc 33",
+			tc:       timeControl{depth: 1},
+			maxPlies: 10,
+			state:    chess2.GameOverWhite,
+			reason:   "stub-illegal made an illegal move e2e5",
+		},
+	}
+	for name, config := range cases {
+		start, err := chess2.ParseEpd(config.start)
+		require.NoError(t, err, "Case: %s", name)
+		white := startStubEngine(t, config.white)
+		black := startStubEngine(t, config.black)
+		maxPlies := config.maxPlies
+		if maxPlies == 0 {
+			maxPlies = 400
+		}
+		result, err := playGame([2]*engine{white, black}, start, config.tc, maxPlies)
+		assert.NoError(t, err, "Case: %s", name)
+		assert.Equal(t, config.state, result.state, "Case: %s", name)
+		assert.Equal(t, config.reason, result.reason, "Case: %s", name)
+		assert.Equal(t, config.moves, result.moves, "Case: %s", name)
+		assert.NoError(t, white.close(), "Case: %s", name)
+		assert.NoError(t, black.close(), "Case: %s", name)
+	}
+}
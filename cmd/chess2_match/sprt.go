@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+)
+
+// A score is the results of a match from the point of view of the first
+// engine.
+type score struct {
+	wins, draws, losses int
+}
+
+func (s score) games() int {
+	return s.wins + s.draws + s.losses
+}
+
+// Returns the average points per game, where a win is 1 and a draw is 0.5.
+func (s score) mean() float64 {
+	return (float64(s.wins) + float64(s.draws)/2) / float64(s.games())
+}
+
+// Returns the variance of the points scored in a single game.
+func (s score) variance() float64 {
+	mean := s.mean()
+	n := float64(s.games())
+	return (float64(s.wins)*math.Pow(1-mean, 2) +
+		float64(s.draws)*math.Pow(0.5-mean, 2) +
+		float64(s.losses)*math.Pow(mean, 2)) / n
+}
+
+// Converts an expected score into an Elo difference.
+func scoreToElo(mean float64) float64 {
+	return -400 * math.Log10(1/mean-1)
+}
+
+// Converts an Elo difference into an expected score.
+func eloToScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Returns the estimated Elo difference between the engines and the margin of
+// its 95% confidence interval. The results are infinite if one engine has
+// scored every point.
+func (s score) elo() (float64, float64) {
+	if s.games() == 0 {
+		return 0, math.Inf(1)
+	}
+	mean := s.mean()
+	stderr := math.Sqrt(s.variance() / float64(s.games()))
+	low := scoreToElo(math.Max(mean-1.96*stderr, 0))
+	high := scoreToElo(math.Min(mean+1.96*stderr, 1))
+	return scoreToElo(mean), (high - low) / 2
+}
+
+// An sprt is a sequential probability ratio test of whether the difference
+// between the engines is elo0 (the null hypothesis) or elo1.
+type sprt struct {
+	elo0, elo1  float64
+	alpha, beta float64
+}
+
+// Returns the bounds that the log-likelihood ratio must cross for the test to
+// accept H0 (lower) or H1 (upper).
+func (t sprt) bounds() (float64, float64) {
+	return math.Log(t.beta / (1 - t.alpha)), math.Log((1 - t.beta) / t.alpha)
+}
+
+// Returns the log-likelihood ratio of the hypotheses given the score, using
+// the normal approximation to the distribution of game results.
+func (t sprt) llr(s score) float64 {
+	if s.games() == 0 {
+		return 0
+	}
+	variance := s.variance()
+	if variance == 0 {
+		return 0
+	}
+	s0, s1 := eloToScore(t.elo0), eloToScore(t.elo1)
+	return float64(s.games()) * (s1 - s0) * (2*s.mean() - s0 - s1) / (2 * variance)
+}
+
+// A hypothesis is the outcome of an sprt.
+type hypothesis int
+
+const (
+	// hypothesisNone means that the test hasn't finished.
+	hypothesisNone = hypothesis(iota)
+	hypothesisH0
+	hypothesisH1
+)
+
+func (h hypothesis) String() string {
+	switch h {
+	case hypothesisH0:
+		return "H0"
+	case hypothesisH1:
+		return "H1"
+	default:
+		return "none"
+	}
+}
+
+// Returns the hypothesis accepted by the test, if any.
+func (t sprt) status(s score) hypothesis {
+	lower, upper := t.bounds()
+	llr := t.llr(s)
+	switch {
+	case llr <= lower:
+		return hypothesisH0
+	case llr >= upper:
+		return hypothesisH1
+	}
+	return hypothesisNone
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEloConversion(t *testing.T) {
+	// Reference values from the standard Elo table.
+	cases := map[string]struct {
+		elo, score float64
+	}{
+		"even":       {0, 0.5},
+		"+100":       {100, 0.6400649998},
+		"-200":       {-200, 0.2402530734},
+		"75 percent": {190.8485018879, 0.75},
+		"70 percent": {147.1907141178, 0.7},
+	}
+	for name, config := range cases {
+		assert.InDelta(t, config.score, eloToScore(config.elo), 1e-9, "Case: %s", name)
+		assert.InDelta(t, config.elo, scoreToElo(config.score), 1e-6, "Case: %s", name)
+	}
+}
+
+func TestScoreElo(t *testing.T) {
+	cases := map[string]struct {
+		score          score
+		mean, variance float64
+		elo, margin    float64
+	}{
+		// The mean is 0.7 and the variance 0.16, so the interval is
+		// 0.7 +/- 1.96 * 0.04.
+		"60/20/20": {
+			score: score{60, 20, 20}, mean: 0.7, variance: 0.16,
+			elo: 147.1907141178, margin: 66.0146386282,
+		},
+		"even": {
+			score: score{100, 200, 100}, mean: 0.5, variance: 0.125,
+			elo: 0, margin: 24.1147068981,
+		},
+		"all wins": {
+			score: score{10, 0, 0}, mean: 1, variance: 0,
+			elo: math.Inf(1), margin: math.NaN(),
+		},
+	}
+	for name, config := range cases {
+		assert.InDelta(t, config.mean, config.score.mean(), 1e-9, "Case: %s", name)
+		assert.InDelta(t, config.variance, config.score.variance(), 1e-9, "Case: %s", name)
+		elo, margin := config.score.elo()
+		if math.IsInf(config.elo, 0) {
+			assert.Equal(t, config.elo, elo, "Case: %s", name)
+		} else {
+			assert.InDelta(t, config.elo, elo, 1e-9, "Case: %s", name)
+		}
+		if math.IsNaN(config.margin) {
+			assert.True(t, math.IsNaN(margin), "Case: %s", name)
+		} else {
+			assert.InDelta(t, config.margin, margin, 1e-9, "Case: %s", name)
+		}
+	}
+
+	elo, margin := score{}.elo()
+	assert.Equal(t, 0.0, elo)
+	assert.Equal(t, math.Inf(1), margin)
+}
+
+func TestSprt(t *testing.T) {
+	test := sprt{elo0: 0, elo1: 10, alpha: 0.05, beta: 0.05}
+	lower, upper := test.bounds()
+	// ln(0.05 / 0.95) and ln(0.95 / 0.05).
+	assert.InDelta(t, -2.9444389792, lower, 1e-9)
+	assert.InDelta(t, 2.9444389792, upper, 1e-9)
+
+	cases := map[string]struct {
+		score  score
+		llr    float64
+		status hypothesis
+	}{
+		"no games":   {score{}, 0, hypothesisNone},
+		"all draws":  {score{0, 10, 0}, 0, hypothesisNone},
+		"even":       {score{100, 200, 100}, -0.3311857092, hypothesisNone},
+		"ahead":      {score{60, 20, 20}, 1.7337133119, hypothesisNone},
+		"accept H1":  {score{520, 160, 320}, 6.6761144124, hypothesisH1},
+		"even again": {score{400, 200, 400}, -0.5174776706, hypothesisNone},
+		"accept H0":  {score{300, 400, 500}, -9.7851947950, hypothesisH0},
+	}
+	for name, config := range cases {
+		assert.InDelta(t, config.llr, test.llr(config.score), 1e-9, "Case: %s", name)
+		assert.Equal(t, config.status, test.status(config.score), "Case: %s", name)
+	}
+}
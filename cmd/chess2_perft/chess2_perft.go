@@ -18,14 +18,23 @@ var (
 	maxDepth   = pflag.IntP("depth", "d", 2, "depth for perft")
 	bruteforce = pflag.BoolP("brute-force", "b", false, "use brute force search")
 	classic    = pflag.Bool("classic", false, "use classic chess rules")
+	duels      = pflag.Bool("duels", false, "count every combination of duels for captures")
 	divide     = pflag.Bool("divide", false, "split results for first move")
+	threads    = pflag.IntP("threads", "t", 1, "number of threads to count moves with")
+	hashSize   = pflag.Int("hash", 0, "number of positions in the perft hash table")
 	cpuProfile = pflag.String("cpu-profile", "", "filename for CPU profile")
 	memProfile = pflag.String("mem-profile", "", "filename for memory profile")
+	armyFiles  = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
 )
 
 func main() {
 	pflag.Parse()
 
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load army: ", err)
+		os.Exit(2)
+	}
+
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
@@ -61,6 +70,14 @@ func main() {
 }
 
 func handleInput() bool {
+	options := chess2.PerftOptions{
+		Threads:    *threads,
+		Bruteforce: *bruteforce,
+		Duels:      *duels,
+	}
+	if *hashSize > 0 {
+		options.Table = chess2.NewPerftTable(*hashSize)
+	}
 	scanner := bufio.NewScanner(os.Stdin)
 	success := true
 	for scanner.Scan() {
@@ -68,9 +85,9 @@ func handleInput() bool {
 		var result string
 		var err error
 		if *divide {
-			result, err = dividePerft(epd)
+			result, err = dividePerft(epd, options)
 		} else {
-			result, err = runPerft(epd)
+			result, err = runPerft(epd, options)
 		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v (epd: %s)\n", err, epd)
@@ -100,7 +117,7 @@ func parseEpd(epd string) (chess2.Game, error) {
 
 // Output a summary of the perfts for maxDepth-1 for each valid move from the
 // given epd.
-func dividePerft(epd string) (string, error) {
+func dividePerft(epd string, options chess2.PerftOptions) (string, error) {
 	game, err := parseEpd(epd)
 	if err != nil {
 		return "", err
@@ -112,26 +129,11 @@ func dividePerft(epd string) (string, error) {
 		return sb.String(), nil
 	}
 
-	var moves []chess2.Move
-	if *bruteforce {
-		chess2.BruteforceMoveList(func(m chess2.Move) {
-			if err := game.ValidateLegalMove(m); err == nil {
-				moves = append(moves, m)
-			}
-		})
-	} else {
-		moves = game.GenerateLegalMoves()
-	}
-
+	moves := chess2.PerftMoves(game, options)
 	for _, m := range moves {
 		if *maxDepth > 1 {
 			child := game.ApplyMove(m)
-			var results []uint64
-			if *bruteforce {
-				results = chess2.PerftBruteforce(child, *maxDepth-1)
-			} else {
-				results = chess2.Perft(child, *maxDepth-1)
-			}
+			results := chess2.PerftWithOptions(child, *maxDepth-1, options)
 			sb.WriteString(fmt.Sprintf("%v: %d\n", m, results[*maxDepth-2]))
 		} else {
 			sb.WriteString(fmt.Sprintf("%v: 1\n", m))
@@ -143,7 +145,7 @@ func dividePerft(epd string) (string, error) {
 // Take in a formatted input string and run a perft test. The input string is
 // an EPD string, optionally followed by a semicolon and slash-delimited list
 // of numbers, corresponding to the perft at each depth.
-func runPerft(input string) (string, error) {
+func runPerft(input string, options chess2.PerftOptions) (string, error) {
 	parts := strings.SplitN(input, ";", 2)
 	epd := parts[0]
 	var checkValues []uint64
@@ -163,12 +165,7 @@ func runPerft(input string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	var result []uint64
-	if *bruteforce {
-		result = chess2.PerftBruteforce(game, *maxDepth)
-	} else {
-		result = chess2.Perft(game, *maxDepth)
-	}
+	result := chess2.PerftWithOptions(game, *maxDepth, options)
 	for i := 0; i < len(checkValues) && i < *maxDepth; i++ {
 		if checkValues[i] != result[i] {
 			return "", fmt.Errorf("expected %d, found %d at depth %d", checkValues[i], result[i], i+1)
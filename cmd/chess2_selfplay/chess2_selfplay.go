@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+
+	"github.com/spf13/pflag"
+)
+
+// allArmies is the EPD symbol of every built-in army, for --armies all.
+const allArmies = "cnerka"
+
+var (
+	numGames    = pflag.IntP("games", "n", 100, "number of games to play")
+	armies      = pflag.String("armies", "all", "comma-separated army pairings to play, or \"all\"")
+	chess960    = pflag.Bool("chess960", false, "start each game from a random Chess960 position")
+	stones      = pflag.String("stones", "", "starting stones as in an EPD, like 33 or 12/9")
+	policyName  = pflag.String("policy", "search", "move policy: search or random")
+	depth       = pflag.IntP("depth", "d", 2, "depth searched by the search policy")
+	nodes       = pflag.Uint64("nodes", 0, "nodes searched by the search policy")
+	randomPlies = pflag.Int("random-plies", 8, "number of random plies at the start of each game")
+	maxPlies    = pflag.Int("max-plies", 400, "number of plies after which the game is drawn")
+	threads     = pflag.IntP("threads", "t", 1, "number of games to play at once")
+	seed        = pflag.Int64("seed", 0, "random seed; 0 uses the current time")
+	output      = pflag.StringP("output", "o", "", "file to write the dataset to; default stdout")
+	armyFiles   = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
+)
+
+// A record is a single line of the dataset, describing one position and the
+// move played from it.
+type record struct {
+	Game int    `json:"game"`
+	Ply  int    `json:"ply"`
+	Epd  string `json:"epd"`
+	// Move includes the duels that were fought, in move notation.
+	Move string `json:"move"`
+	// Score is the policy's evaluation of the position for the player to move,
+	// if it has one.
+	Score *int `json:"score,omitempty"`
+	// Winner is "white", "black" or "draw".
+	Winner string `json:"winner"`
+}
+
+func main() {
+	pflag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Sets the starting stones of the game from --stones, if given.
+func setStones(game *chess2.Game) error {
+	if *stones == "" {
+		return nil
+	}
+	counts, max, err := chess2.ParseStones(*stones)
+	if err == nil {
+		err = game.SetStones(counts[0], counts[1], max)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid --stones: %s", *stones)
+	}
+	return nil
+}
+
+// Returns the position to start a game from, which is the given start with the
+// back ranks shuffled when --chess960 is given.
+func startingPosition(start chess2.Game, rng *rand.Rand) (chess2.Game, error) {
+	if !*chess960 {
+		return start, nil
+	}
+	game, err := chess2.GameFromChess960(
+		start.Army(chess2.ColorWhite), start.Army(chess2.ColorBlack),
+		rng.Intn(chess2.Chess960Positions))
+	if err != nil {
+		return chess2.Game{}, err
+	}
+	if err := setStones(&game); err != nil {
+		return chess2.Game{}, err
+	}
+	return game, nil
+}
+
+func run() error {
+	if *policyName != "search" && *policyName != "random" {
+		return fmt.Errorf("unknown policy: %s", *policyName)
+	}
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		return err
+	}
+	starts, err := parseArmies(*armies)
+	if err != nil {
+		return err
+	}
+	for i := range starts {
+		if err := setStones(&starts[i]); err != nil {
+			return err
+		}
+	}
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	var mutex sync.Mutex
+	var writeErr error
+	encoder := json.NewEncoder(w)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	if *threads < 1 {
+		*threads = 1
+	}
+	for i := 0; i < *threads; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(*seed + int64(worker)))
+			p := newPolicy(rng)
+			for n := range work {
+				var records []record
+				start, err := startingPosition(starts[n%len(starts)], rng)
+				if err == nil {
+					records, err = playGame(n, start, p, rng)
+				}
+				mutex.Lock()
+				if err != nil && writeErr == nil {
+					writeErr = err
+				}
+				for _, r := range records {
+					if err := encoder.Encode(r); err != nil && writeErr == nil {
+						writeErr = err
+					}
+				}
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	for n := 0; n < *numGames; n++ {
+		work <- n
+	}
+	close(work)
+	wg.Wait()
+	return writeErr
+}
+
+func newPolicy(rng *rand.Rand) policy {
+	if *policyName == "random" {
+		return &randomPolicy{rng: rng}
+	}
+	return newSearchPolicy(chess2.SearchLimits{Depth: *depth, Nodes: *nodes})
+}
+
+// Plays a single game from the given position, returning a record for each
+// position in it.
+func playGame(n int, start chess2.Game, p policy, rng *rand.Rand) ([]record, error) {
+	var records []record
+	opening := &randomPolicy{rng: rng}
+	game := start
+	for game.GameState() == chess2.GameInProgress && len(records) < *maxPlies {
+		r := record{Game: n, Ply: len(records), Epd: chess2.EncodeEpd(game)}
+		var move chess2.Move
+		if len(records) < *randomPlies {
+			move, _, _ = opening.chooseMove(&game)
+		} else {
+			var score int
+			var scored bool
+			if move, score, scored = p.chooseMove(&game); scored {
+				r.Score = &score
+			}
+		}
+		pending, err := chess2.NewPendingMove(game, move)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s (epd: %s)", move, err, r.Epd)
+		}
+		if err := fightDuels(pending, p, rng); err != nil {
+			return nil, fmt.Errorf("%s: %s (epd: %s)", move, err, r.Epd)
+		}
+		r.Move = pending.Move().String()
+		if game, err = pending.Apply(); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	winner := "draw"
+	switch game.GameState() {
+	case chess2.GameOverWhite:
+		winner = "white"
+	case chess2.GameOverBlack:
+		winner = "black"
+	}
+	for i := range records {
+		records[i].Winner = winner
+	}
+	return records, nil
+}
+
+// Decides every duel of the pending move using the bids of the policy.
+func fightDuels(pending *chess2.PendingMove, p policy, rng *rand.Rand) error {
+	game := pending.Game()
+	for {
+		var err error
+		switch pending.Phase() {
+		case chess2.DuelPhaseComplete:
+			return nil
+		case chess2.DuelPhaseChallenge:
+			duel := p.Bid(&game, pending.Move(), pending.Index(), chess2.DuelChallenger).Sample(rng)
+			if duel.IsStarted() {
+				err = pending.Challenge(duel.Challenge())
+			} else {
+				err = pending.Decline()
+			}
+		case chess2.DuelPhaseResponse:
+			duel := p.Bid(&game, pending.Move(), pending.Index(), chess2.DuelResponder).Sample(rng)
+			err = pending.Respond(duel.Response(), duel.Gain())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Parses a list of army pairings like "cc,nk" into starting positions.
+func parseArmies(list string) ([]chess2.Game, error) {
+	if list == "all" {
+		var pairs []string
+		for _, white := range allArmies {
+			for _, black := range allArmies {
+				pairs = append(pairs, string([]rune{white, black}))
+			}
+		}
+		list = strings.Join(pairs, ",")
+	}
+	var games []chess2.Game
+	for _, pair := range strings.Split(list, ",") {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid armies: %s", pair)
+		}
+		white, foundWhite := chess2.FindArmySymbol(rune(pair[0]))
+		black, foundBlack := chess2.FindArmySymbol(rune(pair[1]))
+		if !foundWhite || !foundBlack {
+			return nil, fmt.Errorf("invalid armies: %s", pair)
+		}
+		games = append(games, chess2.GameFromArmies(white, black))
+	}
+	return games, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs chess2_selfplay with the given arguments, and returns the decoded
+// records of the dataset.
+func runSelfplay(t *testing.T, args ...string) []record {
+	dir, err := ioutil.TempDir("", "chess2_selfplay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "dataset.jsonl")
+	require.NoError(t, pflag.CommandLine.Parse(append(args, "--output", filename)))
+	require.NoError(t, run())
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r), "Line: %s", scanner.Text())
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestSelfplay(t *testing.T) {
+	cases := map[string]struct {
+		policy string
+		// scored is true if the policy evaluates the positions after the
+		// random plies.
+		scored bool
+	}{
+		"random": {policy: "random"},
+		"search": {policy: "search", scored: true},
+	}
+	for name, config := range cases {
+		args := []string{
+			"--games", "3", "--armies", "cc,nk", "--seed", "1", "--threads", "1",
+			"--policy", config.policy, "--depth", "1", "--random-plies", "4", "--max-plies", "30",
+		}
+		records := runSelfplay(t, args...)
+		assert.Equal(t, records, runSelfplay(t, args...), "Case: %s  the same seed must play the same games", name)
+		checkRecords(t, name, records, config.scored)
+	}
+}
+
+// Replays each game from its records, checking that each position follows
+// from the move played in the one before it, and that the result matches.
+func checkRecords(t *testing.T, name string, records []record, scored bool) {
+	games := make(map[int][]record)
+	for _, r := range records {
+		games[r.Game] = append(games[r.Game], r)
+	}
+	require.Len(t, games, 3, "Case: %s", name)
+	duels := 0
+	for n, game := range games {
+		require.NotEmpty(t, game, "Case: %s  Game: %d", name, n)
+		assert.LessOrEqual(t, len(game), 30, "Case: %s  Game: %d", name, n)
+		current, err := chess2.ParseEpd(game[0].Epd)
+		require.NoError(t, err, "Case: %s  Game: %d", name, n)
+		for ply, r := range game {
+			assert.Equal(t, ply, r.Ply, "Case: %s  Game: %d", name, n)
+			assert.Equal(t, chess2.EncodeEpd(current), r.Epd, "Case: %s  Game: %d  Ply: %d", name, n, ply)
+			assert.Equal(t, scored && ply >= 4, r.Score != nil, "Case: %s  Game: %d  Ply: %d", name, n, ply)
+			move, err := chess2.ParseUci(r.Move)
+			require.NoError(t, err, "Case: %s  Game: %d  Ply: %d", name, n, ply)
+			plain := move
+			plain.Duels = [3]chess2.Duel{}
+			require.NoError(t, current.ValidateLegalMove(plain), "Case: %s  Game: %d  Move: %s", name, n, r.Move)
+			require.NoError(t, current.ValidateDuels(move), "Case: %s  Game: %d  Move: %s", name, n, r.Move)
+			for i := range current.DuelOpportunities(plain) {
+				if move.Duels[i].IsStarted() {
+					assert.True(t, move.Duels[i].IsComplete(), "Case: %s  Game: %d  Move: %s", name, n, r.Move)
+					duels++
+				}
+			}
+			current = current.ApplyMove(move)
+		}
+
+		winner := "draw"
+		switch current.GameState() {
+		case chess2.GameOverWhite:
+			winner = "white"
+		case chess2.GameOverBlack:
+			winner = "black"
+		case chess2.GameInProgress:
+			assert.Len(t, game, 30, "Case: %s  Game: %d", name, n)
+		}
+		for _, r := range game {
+			assert.Equal(t, winner, r.Winner, "Case: %s  Game: %d", name, n)
+		}
+	}
+	assert.NotZero(t, duels, "Case: %s  no duels were fought", name)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+)
+
+// A policy decides which moves and duel bids a player makes. Both players in a
+// self-play game use the same policy.
+type policy interface {
+	chess2.DuelStrategy
+	// chooseMove returns the move to play in the given game, which is in
+	// progress. If the policy evaluated the position, it also returns the
+	// score from the point of view of the player to move, in centipawns.
+	chooseMove(game *chess2.Game) (move chess2.Move, score int, scored bool)
+}
+
+// randomPolicy plays uniformly random legal moves and bids.
+type randomPolicy struct {
+	rng *rand.Rand
+}
+
+func (p *randomPolicy) chooseMove(game *chess2.Game) (chess2.Move, int, bool) {
+	moves := game.GenerateLegalMoves()
+	return moves[p.rng.Intn(len(moves))], 0, false
+}
+
+// Bid implements chess2.DuelStrategy.
+func (p *randomPolicy) Bid(game *chess2.Game, move chess2.Move, index int, role chess2.DuelRole) chess2.MixedDuel {
+	var candidates []chess2.Duel
+	if role == chess2.DuelChallenger {
+		candidates = append(candidates, chess2.Duel{})
+	}
+	for bid := 0; bid <= 2; bid++ {
+		candidate := move
+		if role == chess2.DuelChallenger {
+			candidate.Duels[index] = chess2.DuelWithChallenge(bid)
+		} else {
+			candidate.Duels[index] = chess2.DuelWithResponse(move.Duels[index], bid, p.rng.Intn(2) == 0)
+		}
+		if game.ValidateDuels(candidate) == nil {
+			candidates = append(candidates, candidate.Duels[index])
+		}
+	}
+	result := chess2.MixedDuel{Duels: candidates}
+	for range candidates {
+		result.Probabilities = append(result.Probabilities, 1/float64(len(candidates)))
+	}
+	return result
+}
+
+// searchPolicy plays the best move found by a search, and bids according to
+// the default duel strategy.
+type searchPolicy struct {
+	*chess2.DefaultDuelStrategy
+	searcher *chess2.Searcher
+	limits   chess2.SearchLimits
+}
+
+func newSearchPolicy(limits chess2.SearchLimits) *searchPolicy {
+	return &searchPolicy{
+		DefaultDuelStrategy: chess2.NewDefaultDuelStrategy(),
+		searcher:            chess2.NewSearcher(1 << 16),
+		limits:              limits,
+	}
+}
+
+func (p *searchPolicy) chooseMove(game *chess2.Game) (chess2.Move, int, bool) {
+	result := p.searcher.Search(*game, p.limits)
+	return result.BestMove, result.Score, true
+}
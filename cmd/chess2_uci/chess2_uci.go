@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+
+	"github.com/spf13/pflag"
+)
+
+var armyFiles = pflag.StringArray("army", nil, "JSON file defining a custom army; can be repeated")
+
+const (
+	engineName   = "chess2"
+	engineAuthor = "CGamesPlay"
+	// Approximate size of a transposition table entry, used to convert the
+	// Hash option from megabytes.
+	ttEntryBytes = 24
+	defaultHash  = 16
+	maxHash      = 4096
+	// When the time remaining is given without a move count, the engine
+	// assumes that it has this many moves left to make.
+	defaultMovesToGo = 30
+)
+
+// An engine holds the state of a single UCI session.
+type engine struct {
+	out      sync.Mutex
+	w        io.Writer
+	game     chess2.Game
+	searcher *chess2.Searcher
+	duels    chess2.DuelStrategy
+	rng      *rand.Rand
+	hash     int
+	// done is closed when the running search finishes. It is nil when there
+	// is no search running.
+	done chan struct{}
+}
+
+func newEngine(w io.Writer) *engine {
+	e := &engine{
+		w:     w,
+		game:  chess2.GameFromArmies(chess2.ArmyClassic, chess2.ArmyClassic),
+		duels: chess2.NewDefaultDuelStrategy(),
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		hash:  defaultHash,
+	}
+	e.newSearcher()
+	return e
+}
+
+func (e *engine) newSearcher() {
+	e.searcher = chess2.NewSearcher(e.hash << 20 / ttEntryBytes)
+	e.searcher.Info = e.sendInfo
+}
+
+// Writes a single line of output. Output from a running search is
+// interleaved with the responses to other commands, so lines must not be
+// written any other way.
+func (e *engine) send(format string, args ...interface{}) {
+	e.out.Lock()
+	defer e.out.Unlock()
+	fmt.Fprintf(e.w, format+"\n", args...)
+}
+
+func (e *engine) sendInfo(info chess2.SearchInfo) {
+	pv := make([]string, len(info.PV))
+	for i, move := range info.PV {
+		pv[i] = move.String()
+	}
+	e.send("info depth %d score %s nodes %d time %d pv %s",
+		info.Depth, formatScore(info.Score), info.Nodes,
+		info.Time.Milliseconds(), strings.Join(pv, " "))
+}
+
+// Formats a score from Search as centipawns, or as the number of moves until
+// mate. Negative mate scores mean that the engine is being mated.
+func formatScore(score int) string {
+	if !chess2.IsMateScore(score) {
+		return fmt.Sprintf("cp %d", score)
+	}
+	if score > 0 {
+		return fmt.Sprintf("mate %d", (chess2.MateScore-score+1)/2)
+	}
+	return fmt.Sprintf("mate -%d", (chess2.MateScore+score+1)/2)
+}
+
+// Stops the running search, if any, and waits for it to report its result.
+func (e *engine) stop() {
+	if e.done == nil {
+		return
+	}
+	e.searcher.Stop()
+	<-e.done
+	e.done = nil
+}
+
+// Waits for the running search, if any, to finish on its own.
+func (e *engine) wait() {
+	if e.done == nil {
+		return
+	}
+	<-e.done
+	e.done = nil
+}
+
+// Handles a single line of input. Returns false if the engine should exit.
+func (e *engine) handle(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	var err error
+	switch fields[0] {
+	case "uci":
+		e.send("id name %s", engineName)
+		e.send("id author %s", engineAuthor)
+		e.send("option name Hash type spin default %d min 0 max %d", defaultHash, maxHash)
+		e.send("uciok")
+	case "isready":
+		e.send("readyok")
+	case "setoption":
+		e.stop()
+		err = e.setOption(fields[1:])
+	case "ucinewgame":
+		e.stop()
+		e.newSearcher()
+	case "position":
+		e.stop()
+		err = e.position(fields[1:])
+	case "go":
+		e.stop()
+		err = e.goSearch(fields[1:])
+	case "stop":
+		e.stop()
+	case "duel":
+		e.stop()
+		err = e.duel(fields[1:])
+	case "quit":
+		e.stop()
+		return false
+	default:
+		err = fmt.Errorf("unknown command: %s", fields[0])
+	}
+	if err != nil {
+		e.send("info string %s", err.Error())
+	}
+	return true
+}
+
+// setoption name Hash value <megabytes>
+func (e *engine) setOption(args []string) error {
+	if len(args) != 4 || args[0] != "name" || args[2] != "value" {
+		return fmt.Errorf("usage: setoption name <name> value <value>")
+	}
+	if !strings.EqualFold(args[1], "Hash") {
+		return fmt.Errorf("unknown option: %s", args[1])
+	}
+	hash, err := strconv.Atoi(args[3])
+	if err != nil || hash < 0 || hash > maxHash {
+		return fmt.Errorf("invalid Hash value: %s", args[3])
+	}
+	e.hash = hash
+	e.newSearcher()
+	return nil
+}
+
+// position (startpos | armies <armies> | epd <epd>) [moves <move>...]
+func (e *engine) position(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: position (startpos | armies <armies> | epd <epd>) [moves <move>...]")
+	}
+	var moves []string
+	for i, arg := range args {
+		if arg == "moves" {
+			moves = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var game chess2.Game
+	switch args[0] {
+	case "startpos":
+		game = chess2.GameFromArmies(chess2.ArmyClassic, chess2.ArmyClassic)
+	case "armies":
+		if len(args) != 2 || len(args[1]) != 2 {
+			return fmt.Errorf("invalid armies")
+		}
+		white, foundWhite := chess2.FindArmySymbol(rune(args[1][0]))
+		black, foundBlack := chess2.FindArmySymbol(rune(args[1][1]))
+		if !foundWhite || !foundBlack {
+			return fmt.Errorf("invalid armies: %s", args[1])
+		}
+		game = chess2.GameFromArmies(white, black)
+	case "epd":
+		var err error
+		if game, err = chess2.ParseEpd(strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid position: %s", args[0])
+	}
+
+	for _, str := range moves {
+		move, err := chess2.ParseUci(str)
+		if err != nil {
+			return fmt.Errorf("%s: %s", str, err.Error())
+		}
+		if err := game.ValidateLegalMove(move); err != nil {
+			return fmt.Errorf("illegal move %s: %s", str, err.Error())
+		}
+		game = game.ApplyMove(move)
+	}
+	e.game = game
+	return nil
+}
+
+// go [depth <plies>] [nodes <nodes>] [movetime <ms>] [wtime <ms>] [btime <ms>]
+// [winc <ms>] [binc <ms>] [movestogo <moves>] [infinite]
+func (e *engine) goSearch(args []string) error {
+	limits, err := parseGo(args, e.game.ToMove())
+	if err != nil {
+		return err
+	}
+	// There is no move to search for once the game is over. The search is
+	// still answered, so that a client waiting for bestmove isn't left
+	// hanging.
+	if e.game.GameState() != chess2.GameInProgress {
+		e.send("info string game is over")
+		e.send("bestmove (none)")
+		return nil
+	}
+
+	game := e.game
+	done := make(chan struct{})
+	e.done = done
+	go func() {
+		defer close(done)
+		result := e.searcher.Search(game, limits)
+		e.send("bestmove %s", result.BestMove)
+	}()
+	return nil
+}
+
+// Converts the arguments to go into limits for a search by the given player.
+// When only the remaining time is given, the move gets an even share of it
+// over the moves left to go, plus half of the increment.
+func parseGo(args []string, toMove chess2.Color) (chess2.SearchLimits, error) {
+	var limits chess2.SearchLimits
+	var times, incs [2]time.Duration
+	movesToGo := defaultMovesToGo
+	for i := 0; i < len(args); i++ {
+		if args[i] == "infinite" {
+			continue
+		}
+		if i+1 >= len(args) {
+			return limits, fmt.Errorf("missing value for %s", args[i])
+		}
+		value, err := strconv.ParseUint(args[i+1], 10, 64)
+		if err != nil {
+			return limits, fmt.Errorf("invalid value for %s: %s", args[i], args[i+1])
+		}
+		ms := time.Duration(value) * time.Millisecond
+		switch args[i] {
+		case "depth":
+			limits.Depth = int(value)
+		case "nodes":
+			limits.Nodes = value
+		case "movetime":
+			limits.MoveTime = ms
+		case "wtime":
+			times[0] = ms
+		case "btime":
+			times[1] = ms
+		case "winc":
+			incs[0] = ms
+		case "binc":
+			incs[1] = ms
+		case "movestogo":
+			if value > 0 {
+				movesToGo = int(value)
+			}
+		default:
+			return limits, fmt.Errorf("unknown parameter: %s", args[i])
+		}
+		i++
+	}
+
+	colorIdx := chess2.ColorIdx(toMove)
+	if limits.MoveTime == 0 && times[colorIdx] > 0 {
+		limits.MoveTime = times[colorIdx]/time.Duration(movesToGo) + incs[colorIdx]/2
+	}
+	return limits, nil
+}
+
+// duel challenge <move> <index>
+// duel response <move> <index>
+//
+// The move includes the duels decided so far. For a response, the duel at the
+// index must hold the defender's challenge.
+func (e *engine) duel(args []string) error {
+	if len(args) != 3 || args[0] != "challenge" && args[0] != "response" {
+		return fmt.Errorf("usage: duel (challenge | response) <move> <index>")
+	}
+	move, err := chess2.ParseUci(args[1])
+	if err != nil {
+		return fmt.Errorf("%s: %s", args[1], err.Error())
+	}
+	index, err := strconv.Atoi(args[2])
+	if err != nil || index < 0 || index >= len(move.Duels) {
+		return fmt.Errorf("invalid duel index: %s", args[2])
+	}
+	plain := move
+	plain.Duels = [3]chess2.Duel{}
+	if err := e.game.ValidateLegalMove(plain); err != nil {
+		return fmt.Errorf("illegal move %s: %s", args[1], err.Error())
+	}
+	if index >= len(e.game.DuelOpportunities(plain)) {
+		return fmt.Errorf("move %s does not have a capture %d", args[1], index)
+	}
+
+	if args[0] == "challenge" {
+		for i := index; i < len(move.Duels); i++ {
+			move.Duels[i] = chess2.Duel{}
+		}
+		if err := e.game.ValidateDuels(move); err != nil {
+			return fmt.Errorf("invalid duels %s: %s", args[1], err.Error())
+		}
+		duel := e.duels.Bid(&e.game, move, index, chess2.DuelChallenger).Sample(e.rng)
+		if !duel.IsStarted() {
+			e.send("bestchallenge none")
+		} else {
+			e.send("bestchallenge %d", duel.Challenge())
+		}
+		return nil
+	}
+
+	if d := move.Duels[index]; !d.IsStarted() || d.IsComplete() {
+		return fmt.Errorf("duel %d of %s is not waiting for a response", index, args[1])
+	}
+	for i := index + 1; i < len(move.Duels); i++ {
+		move.Duels[i] = chess2.Duel{}
+	}
+	if err := e.game.ValidateDuels(move); err != nil {
+		return fmt.Errorf("invalid duels %s: %s", args[1], err.Error())
+	}
+	duel := e.duels.Bid(&e.game, move, index, chess2.DuelResponder).Sample(e.rng)
+	// The response is written the same way as in a move, without the
+	// challenge.
+	e.send("bestresponse %s", duel.String()[1:])
+	return nil
+}
+
+func main() {
+	pflag.Parse()
+	if err := chess2.LoadArmyFiles(*armyFiles); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load army: ", err)
+		os.Exit(2)
+	}
+	e := newEngine(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if !e.handle(scanner.Text()) {
+			return
+		}
+	}
+	// Let a search that was started before the end of the input finish.
+	e.wait()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CGamesPlay/chess2/pkg/chess2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends each line to a new engine, waiting for each search to finish, and
+// returns the output. The progress reported by searches is left out.
+func runEngine(lines ...string) []string {
+	var out bytes.Buffer
+	e := newEngine(&out)
+	e.rng = rand.New(rand.NewSource(1))
+	for _, line := range lines {
+		e.handle(line)
+		e.wait()
+	}
+	var output []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line != "" && !strings.HasPrefix(line, "info depth ") {
+			output = append(output, line)
+		}
+	}
+	return output
+}
+
+func TestHandle(t *testing.T) {
+	cases := map[string]struct {
+		input []string
+		// Each line of output must match the corresponding expression.
+		output []string
+	}{
+		"uci": {
+			input:  []string{"uci", "isready"},
+			output: []string{"id name chess2", "id author CGamesPlay", "option name Hash type spin default 16 min 0 max 4096", "uciok", "readyok"},
+		},
+		"unknown command": {
+			input:  []string{"", "foo bar"},
+			output: []string{"info string unknown command: foo"},
+		},
+		"setoption": {
+			input: []string{"setoption name Hash value 1", "setoption name hash", "setoption name Threads value 2", "setoption name Hash value 5000"},
+			output: []string{
+				"info string usage: setoption name <name> value <value>",
+				"info string unknown option: Threads",
+				"info string invalid Hash value: 5000",
+			},
+		},
+		"position errors": {
+			input: []string{
+				"position",
+				"position foo",
+				"position armies c",
+				"position armies cz",
+				"position epd foo",
+				"position startpos moves e2e4 zz",
+				"position startpos moves e2e5",
+			},
+			output: []string{
+				`info string usage: position \(startpos \| armies <armies> \| epd <epd>\) \[moves <move>\.\.\.\]`,
+				"info string invalid position: foo",
+				"info string invalid armies",
+				"info string invalid armies: cz",
+				"info string .+",
+				"info string zz: .+",
+				"info string illegal move e2e5: .+",
+			},
+		},
+		"position moves": {
+			input:  []string{"position armies ck moves e2e4 d7d5", "go depth 1"},
+			output: []string{`bestmove [a-h][1-8][a-h][1-8]\S*`},
+		},
+		"go errors": {
+			input: []string{"go depth", "go depth x", "go foo 1"},
+			output: []string{
+				"info string missing value for depth",
+				"info string invalid value for depth: x",
+				"info string unknown parameter: foo",
+			},
+		},
+		"go after checkmate": {
+			input:  []string{"position epd 8/8/8/7k/6QR/8/8/4K3 b - - 0 1 cc 33", "go depth 1"},
+			output: []string{"info string game is over", `bestmove \(none\)`},
+		},
+		"go after midline victory": {
+			input:  []string{"position epd 4k3/8/8/4K3/8/8/8/8 b - - 0 1 cc 33", "go wtime 1000 btime 1000"},
+			output: []string{"info string game is over", `bestmove \(none\)`},
+		},
+		"duel challenge": {
+			input:  []string{"position startpos moves e2e4 d7d5", "duel challenge e4d5 0"},
+			output: []string{"bestchallenge (none|[0-2])"},
+		},
+		"duel response": {
+			input:  []string{"position startpos moves e2e4 d7d5", "duel response e4d5:1 0"},
+			output: []string{"bestresponse [0-2][+-]?"},
+		},
+		"duel errors": {
+			input: []string{
+				"position startpos moves e2e4 d7d5",
+				"duel challenge e4d5",
+				"duel accept e4d5 0",
+				"duel challenge zz 0",
+				"duel challenge e4d5 x",
+				"duel challenge e4d5 -1",
+				"duel challenge e4d5 3",
+				"duel challenge e4d5 1",
+				"duel challenge e2e3 0",
+				"duel challenge d2d4 0",
+				"duel response e4d5 0",
+				"duel response e4d5:12 0",
+			},
+			output: []string{
+				`info string usage: duel \(challenge \| response\) <move> <index>`,
+				`info string usage: duel \(challenge \| response\) <move> <index>`,
+				"info string zz: .+",
+				"info string invalid duel index: x",
+				"info string invalid duel index: -1",
+				"info string invalid duel index: 3",
+				"info string move e4d5 does not have a capture 1",
+				"info string illegal move e2e3: .+",
+				"info string move d2d4 does not have a capture 0",
+				"info string duel 0 of e4d5 is not waiting for a response",
+				"info string duel 0 of e4d5:12 is not waiting for a response",
+			},
+		},
+	}
+	for name, config := range cases {
+		output := runEngine(config.input...)
+		if assert.Len(t, output, len(config.output), "Case: %s  Output: %v", name, output) {
+			for i, expected := range config.output {
+				assert.Regexp(t, "^"+expected+"$", output[i], "Case: %s", name)
+			}
+		}
+	}
+}
+
+func TestHandleQuit(t *testing.T) {
+	var out bytes.Buffer
+	e := newEngine(&out)
+	assert.True(t, e.handle("isready"))
+	assert.False(t, e.handle("quit"))
+	assert.Equal(t, "readyok\n", out.String())
+}
+
+func TestHandleBestMove(t *testing.T) {
+	output := runEngine("position armies ck moves e2e4", "go depth 2")
+	require.Len(t, output, 1)
+	require.True(t, strings.HasPrefix(output[0], "bestmove "), "Output: %s", output[0])
+	move, err := chess2.ParseUci(strings.TrimPrefix(output[0], "bestmove "))
+	require.NoError(t, err)
+	game, err := chess2.ParseEpd("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1 ck 33")
+	require.NoError(t, err)
+	assert.NoError(t, game.ValidateLegalMove(move))
+}
+
+func TestParseGo(t *testing.T) {
+	ms := time.Millisecond
+	cases := map[string]struct {
+		args   string
+		toMove chess2.Color
+		limits chess2.SearchLimits
+		err    string
+	}{
+		"empty": {
+			args: "",
+		},
+		"infinite": {
+			args: "infinite",
+		},
+		"depth and nodes": {
+			args:   "depth 5 nodes 1000",
+			limits: chess2.SearchLimits{Depth: 5, Nodes: 1000},
+		},
+		"movetime": {
+			args:   "movetime 250 wtime 60000",
+			limits: chess2.SearchLimits{MoveTime: 250 * ms},
+		},
+		"white time": {
+			args:   "wtime 60000 btime 30000",
+			limits: chess2.SearchLimits{MoveTime: 2000 * ms},
+		},
+		"black time": {
+			args:   "wtime 60000 btime 30000",
+			toMove: chess2.ColorBlack,
+			limits: chess2.SearchLimits{MoveTime: 1000 * ms},
+		},
+		"increment": {
+			args:   "wtime 60000 btime 30000 winc 1000 binc 2000",
+			toMove: chess2.ColorBlack,
+			limits: chess2.SearchLimits{MoveTime: 2000 * ms},
+		},
+		"moves to go": {
+			args:   "wtime 60000 winc 1000 movestogo 10",
+			limits: chess2.SearchLimits{MoveTime: 6500 * ms},
+		},
+		"zero moves to go": {
+			args:   "wtime 60000 movestogo 0",
+			limits: chess2.SearchLimits{MoveTime: 2000 * ms},
+		},
+		"other player's time": {
+			args: "btime 30000",
+		},
+		"missing value": {
+			args: "depth 5 nodes",
+			err:  "missing value for nodes",
+		},
+		"invalid value": {
+			args: "movetime -1",
+			err:  "invalid value for movetime: -1",
+		},
+		"unknown parameter": {
+			args: "mate 3",
+			err:  "unknown parameter: mate",
+		},
+	}
+	for name, config := range cases {
+		limits, err := parseGo(strings.Fields(config.args), config.toMove)
+		if config.err != "" {
+			assert.EqualError(t, err, config.err, "Case: %s", name)
+			continue
+		}
+		assert.NoError(t, err, "Case: %s", name)
+		assert.Equal(t, config.limits, limits, "Case: %s", name)
+	}
+}
@@ -0,0 +1,93 @@
+package chess2
+
+// builtinArmyDefinitions are the definitions of the six Chess 2 armies, in the
+// format read by ParseArmyDefinition.
+var builtinArmyDefinitions = map[Army]string{
+	ArmyClassic: `{
+		"name": "Classic",
+		"symbol": "c",
+		"pieces": {
+			"king": {"name": "Classic King", "castles": true, "moves": [
+				{"kind": "leap", "vector": [1, 0]},
+				{"kind": "leap", "vector": [1, 1]}
+			]}
+		}
+	}`,
+	ArmyNemesis: `{
+		"name": "Nemesis",
+		"symbol": "n",
+		"pieces": {
+			"queen": {"name": "Nemesis", "captures": "kings", "capturable_by": "kings", "moves": [
+				{"kind": "ride", "vector": [1, 0]},
+				{"kind": "ride", "vector": [1, 1]}
+			]},
+			"pawn": {"name": "Nemesis Pawn", "moves": [
+				{"kind": "pawn"},
+				{"kind": "toward_kings"}
+			]}
+		}
+	}`,
+	ArmyEmpowered: `{
+		"name": "Empowered",
+		"symbol": "e",
+		"pieces": {
+			"queen": {"name": "Abdicated Queen", "moves": [
+				{"kind": "leap", "vector": [1, 0]},
+				{"kind": "leap", "vector": [1, 1]}
+			]},
+			"bishop": {"name": "Empowered Bishop", "borrow": ["knight", "rook"], "moves": [
+				{"kind": "ride", "vector": [1, 1]}
+			]},
+			"knight": {"name": "Empowered Knight", "borrow": ["bishop", "rook"], "moves": [
+				{"kind": "leap", "vector": [1, 2]}
+			]},
+			"rook": {"name": "Empowered Rook", "borrow": ["bishop", "knight"], "moves": [
+				{"kind": "ride", "vector": [1, 0]}
+			]}
+		}
+	}`,
+	ArmyReaper: `{
+		"name": "Reaper",
+		"symbol": "r",
+		"pieces": {
+			"queen": {"name": "Reaper", "captures": "not_kings", "moves": [
+				{"kind": "teleport", "exclude_last_rank": true}
+			]},
+			"rook": {"name": "Ghost", "captures": "none", "capturable_by": "none", "moves": [
+				{"kind": "teleport"}
+			]}
+		}
+	}`,
+	ArmyTwoKings: `{
+		"name": "Two Kings",
+		"symbol": "k",
+		"back_rank": "RNBKKBNR",
+		"king_turn": true,
+		"promotions": ["rook", "bishop", "knight"],
+		"pieces": {
+			"king": {"name": "Warrior King", "whirlwind": true, "moves": [
+				{"kind": "leap", "vector": [1, 0]},
+				{"kind": "leap", "vector": [1, 1]}
+			]}
+		}
+	}`,
+	ArmyAnimals: `{
+		"name": "Animals",
+		"symbol": "a",
+		"pieces": {
+			"queen": {"name": "Jungle Queen", "moves": [
+				{"kind": "ride", "vector": [1, 0]},
+				{"kind": "leap", "vector": [1, 2]}
+			]},
+			"bishop": {"name": "Tiger", "stays_on_capture": true, "moves": [
+				{"kind": "ride", "vector": [1, 1], "range": 2}
+			]},
+			"knight": {"name": "Wild Horse", "captures_own": true, "moves": [
+				{"kind": "leap", "vector": [1, 2]}
+			]},
+			"rook": {"name": "Elephant", "rampage": true, "capturable_within": 2, "moves": [
+				{"kind": "ride", "vector": [1, 0], "range": 3}
+			]}
+		}
+	}`,
+}
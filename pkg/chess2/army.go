@@ -0,0 +1,674 @@
+package chess2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// An ArmyDefinition describes the pieces of an army and how they move. The move
+// generator and validator interpret the definition, so new armies can be
+// played without changing the rules engine. The six Chess 2 armies are
+// described the same way; see ParseArmyDefinition for the JSON format, and
+// RegisterArmy to play a custom army.
+type ArmyDefinition struct {
+	// Name is the name of the army, like "Animals".
+	Name string `json:"name"`
+	// Symbol is the lowercase letter used for the army in EPD.
+	Symbol string `json:"symbol"`
+	// BackRank is the first rank of the starting position, in FEN, from the
+	// a-file to the h-file. The default is "RNBQKBNR".
+	BackRank string `json:"back_rank,omitempty"`
+	// KingTurn gives the army a king-turn after each of its turns, where it
+	// may move one of its kings or pass.
+	KingTurn bool `json:"king_turn,omitempty"`
+	// Promotions are the types that pawns may promote to, and the types that
+	// may be dropped with GameFlagDrops. The default is queen, rook, bishop
+	// and knight.
+	Promotions []string `json:"promotions,omitempty"`
+	// Pieces describes the special pieces of the army, by the type which
+	// they replace: "king", "queen", "bishop", "knight", "rook" or "pawn".
+	// Types which aren't listed are the basic pieces from classic chess.
+	Pieces map[string]PieceDefinition `json:"pieces,omitempty"`
+}
+
+// A PieceDefinition describes how a single piece of an army moves and
+// captures.
+type PieceDefinition struct {
+	// Name is the name of the piece, like "Wild Horse".
+	Name string `json:"name"`
+	// Moves lists the ways that the piece can move. Unless restricted below,
+	// every move can also capture.
+	Moves []MoveDefinition `json:"moves"`
+	// Borrow lists the types of pieces whose moves this piece can also make
+	// while a friendly piece of that type is orthogonally adjacent to it.
+	Borrow []string `json:"borrow,omitempty"`
+	// Captures restricts what the piece can capture: "kings" for only kings,
+	// "not_kings" for anything but enemy kings, or "none". The default is
+	// any enemy piece.
+	Captures string `json:"captures,omitempty"`
+	// CapturesOwn allows the piece to capture its own pieces, other than
+	// kings.
+	CapturesOwn bool `json:"captures_own,omitempty"`
+	// CapturableBy restricts what can capture the piece: "kings" for only
+	// kings, or "none". The default is any piece.
+	CapturableBy string `json:"capturable_by,omitempty"`
+	// CapturableWithin, if set, only allows the piece to be captured by
+	// pieces at most this many squares away.
+	CapturableWithin int `json:"capturable_within,omitempty"`
+	// DuelRank is the dueling rank of the piece. The default is the rank of
+	// the basic piece of the same type, see DuelingRank.
+	DuelRank int `json:"duel_rank,omitempty"`
+	// Value is the material value of the piece in centipawns, used by
+	// DefaultEvaluator. The default is the value of the basic piece of the
+	// same type. The built-in armies leave this unset, since their values are
+	// part of DefaultEvaluator.
+	Value int `json:"value,omitempty"`
+	// Castles allows a king to castle with the rooks in the corners. It must
+	// start on the e-file, and in a Chess960 start it stays between the
+	// rooks when the back rank is shuffled.
+	Castles bool `json:"castles,omitempty"`
+	// Whirlwind allows a king to capture every adjacent piece during a
+	// king-turn, as long as none of them is a friendly king.
+	Whirlwind bool `json:"whirlwind,omitempty"`
+	// Rampage makes the piece's rides ignore the pieces in the way, capturing
+	// every piece that it passes, including its own pieces other than kings.
+	// It can only stop early if the next square is off the board or holds a
+	// piece it can't capture, or if it doesn't pass any pieces.
+	Rampage bool `json:"rampage,omitempty"`
+	// StaysOnCapture makes the piece capture without moving, like the Tiger.
+	StaysOnCapture bool `json:"stays_on_capture,omitempty"`
+}
+
+// A MoveDefinition describes one of the ways that a piece can move.
+type MoveDefinition struct {
+	// Kind is one of:
+	//
+	// - "leap": jump to the square Vector away.
+	// - "ride": take steps of Vector through empty squares, up to Range
+	//   steps if it is set.
+	// - "hop": ride up to the first piece, and land on the square just
+	//   past it.
+	// - "teleport": move to any square on the board.
+	// - "pawn": advance like a pawn, and capture diagonally forward. Range
+	//   is 2 if the pawn may advance two squares from its starting rank.
+	// - "toward_kings": step to an adjacent empty square in the direction of
+	//   an enemy king, like the Nemesis Pawn. Pawns which can do this might
+	//   move backward, so none of their moves reset the fifty move counter.
+	//
+	// The last two are only for pawns, which must have a "pawn" move.
+	Kind string `json:"kind"`
+	// Vector is the [file, rank] offset of the move. Every reflection and
+	// rotation of the vector is included, so [1, 2] describes all of the
+	// moves of a knight.
+	Vector [2]int `json:"vector"`
+	// Range is the most steps a ride can take. Zero means no limit.
+	Range int `json:"range,omitempty"`
+	// ExcludeLastRank stops a teleport from reaching the opponent's back
+	// rank.
+	ExcludeLastRank bool `json:"exclude_last_rank,omitempty"`
+}
+
+// Restrictions on which pieces can be captured, see
+// PieceDefinition.Captures and PieceDefinition.CapturableBy.
+type captureRule int
+
+const (
+	captureAny = captureRule(iota)
+	captureKings
+	captureNotKings
+	captureNone
+)
+
+var captureRuleNames = map[string]captureRule{
+	"":          captureAny,
+	"kings":     captureKings,
+	"not_kings": captureNotKings,
+	"none":      captureNone,
+}
+
+// A vector is a single direction of a ride or hop.
+type vector struct {
+	dx, dy, limit int
+}
+
+// pieceRules is the compiled form of a PieceDefinition.
+type pieceRules struct {
+	// special is set for pieces listed in the ArmyDefinition.
+	special  bool
+	name     string
+	value    int
+	duelRank int
+	// leaps are the squares reached by leaps from each square.
+	leaps [64]uint64
+	// diagRange and orthRange are the number of steps that the piece rides
+	// diagonally and orthogonally, using the attack tables.
+	diagRange, orthRange int
+	// rides and hops are the other rides and hops, in every direction.
+	rides, hops []vector
+	// teleports are the squares that a teleport reaches, for each color.
+	teleport  bool
+	teleports [2]uint64
+	borrows   []PieceType
+	captures  captureRule
+	// capturableBy and capturableWithin restrict the pieces which can
+	// capture this one.
+	capturableBy     captureRule
+	capturableWithin int
+	capturesOwn      bool
+	castles          bool
+	whirlwind        bool
+	rampage          bool
+	// rampageRange is the longest rampage.
+	rampageRange   int
+	staysOnCapture bool
+	doubleStep     bool
+	towardKings    bool
+}
+
+// armyRules is the compiled form of an ArmyDefinition.
+type armyRules struct {
+	definition ArmyDefinition
+	pieces     [6]pieceRules
+	backRank   string
+	kingTurn   bool
+	promotions []PieceType
+	// protected are the types of pieces which can't always be captured.
+	protected []PieceType
+	// rampagers are the types of pieces which rampage.
+	rampagers []PieceType
+	// complexThreats is set if a move can be blocked other than along a
+	// rank, file or diagonal, or if pieces can be jumped over. The legality
+	// filter can't reason about these, so it checks every move instead.
+	complexThreats bool
+}
+
+var (
+	typeNames = map[string]PieceType{
+		"king":   TypeKing,
+		"queen":  TypeQueen,
+		"bishop": TypeBishop,
+		"knight": TypeKnight,
+		"rook":   TypeRook,
+		"pawn":   TypePawn,
+	}
+
+	// basicPieces are the moves of the pieces in classic chess.
+	basicPieces = map[PieceType]PieceDefinition{
+		TypeKing: {Moves: []MoveDefinition{
+			{Kind: "leap", Vector: [2]int{1, 0}},
+			{Kind: "leap", Vector: [2]int{1, 1}},
+		}},
+		TypeQueen: {Moves: []MoveDefinition{
+			{Kind: "ride", Vector: [2]int{1, 0}},
+			{Kind: "ride", Vector: [2]int{1, 1}},
+		}},
+		TypeBishop: {Moves: []MoveDefinition{{Kind: "ride", Vector: [2]int{1, 1}}}},
+		TypeKnight: {Moves: []MoveDefinition{{Kind: "leap", Vector: [2]int{1, 2}}}},
+		TypeRook:   {Moves: []MoveDefinition{{Kind: "ride", Vector: [2]int{1, 0}}}},
+		TypePawn:   {Moves: []MoveDefinition{{Kind: "pawn", Range: 2}}},
+	}
+
+	// rangeMask is the mask of all squares within the given distance.
+	rangeMask = buildRangeMask()
+
+	// builtinArmies are the rules of the built-in armies, indexed by the
+	// Army shifted right by 4. Index 0 is ArmyNone, whose pieces are all
+	// basic.
+	builtinArmies = compileBuiltinArmies()
+	// customArmies are the rules of the armies added by RegisterArmy.
+	customArmies []*armyRules
+)
+
+func buildRangeMask() (results [8][64]uint64) {
+	for r := range results {
+		for a := 0; a < 64; a++ {
+			for b := 0; b < 64; b++ {
+				if SquareDistance(Square{Address: uint8(a)}, Square{Address: uint8(b)}) <= r {
+					results[r][a] |= 1 << uint(b)
+				}
+			}
+		}
+	}
+	return
+}
+
+func compileBuiltinArmies() (results [8]*armyRules) {
+	basic, err := ArmyDefinition{Name: "basic"}.compile()
+	if err != nil {
+		panic(err)
+	}
+	results[0] = basic
+	for army, data := range builtinArmyDefinitions {
+		def, err := ParseArmyDefinition([]byte(data))
+		if err != nil {
+			panic(err)
+		}
+		rules, err := def.compile()
+		if err != nil {
+			panic(err)
+		}
+		results[army>>4] = rules
+	}
+	return
+}
+
+// lookupArmy returns the rules of the given army. Unknown armies are treated
+// like ArmyNone.
+func lookupArmy(army Army) *armyRules {
+	if army >= 0 && army < 0x100 {
+		if rules := builtinArmies[army>>4&7]; rules != nil {
+			return rules
+		}
+	} else if idx := int(army>>8) - 1; idx >= 0 && idx < len(customArmies) {
+		return customArmies[idx]
+	}
+	return builtinArmies[0]
+}
+
+// armyRules returns the rules of the army of the given player.
+func (g *Game) armyRules(colorIdx int) *armyRules {
+	return lookupArmy(g.armies[colorIdx])
+}
+
+// pieceRules returns the rules for a piece of the given player and type.
+func (g *Game) pieceRules(colorIdx int, t PieceType) *pieceRules {
+	return &lookupArmy(g.armies[colorIdx]).pieces[pieceTypeIdx(t)]
+}
+
+// Returns true if pawns of the army may promote to the given type.
+func (a *armyRules) canPromote(t PieceType) bool {
+	for _, promotion := range a.promotions {
+		if promotion == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseArmyDefinition parses an army definition from JSON and checks that it
+// is valid. The fields are named like the fields of ArmyDefinition, in
+// snake_case. For example, the Animals army is:
+//
+//	{
+//	  "name": "Animals",
+//	  "symbol": "a",
+//	  "pieces": {
+//	    "queen": {"name": "Jungle Queen", "moves": [
+//	      {"kind": "ride", "vector": [1, 0]},
+//	      {"kind": "leap", "vector": [1, 2]}
+//	    ]},
+//	    "bishop": {"name": "Tiger", "stays_on_capture": true, "moves": [
+//	      {"kind": "ride", "vector": [1, 1], "range": 2}
+//	    ]},
+//	    "knight": {"name": "Wild Horse", "captures_own": true, "moves": [
+//	      {"kind": "leap", "vector": [1, 2]}
+//	    ]},
+//	    "rook": {"name": "Elephant", "rampage": true, "capturable_within": 2, "moves": [
+//	      {"kind": "ride", "vector": [1, 0], "range": 3}
+//	    ]}
+//	  }
+//	}
+func ParseArmyDefinition(data []byte) (ArmyDefinition, error) {
+	var def ArmyDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return ArmyDefinition{}, ParseError(fmt.Sprintf("Invalid army definition: %s", err))
+	}
+	if _, err := def.compile(); err != nil {
+		return ArmyDefinition{}, err
+	}
+	return def, nil
+}
+
+// RegisterArmy makes a custom army available to GameFromArmies and to EPD and
+// PGN, using the symbol in its definition, and returns its Army value.
+// Registering a definition which is identical to an existing one returns the
+// existing Army. RegisterArmy isn't safe to call while games are being
+// played, so custom armies should be registered when a program starts.
+func RegisterArmy(def ArmyDefinition) (Army, error) {
+	symbol, size := utf8.DecodeRuneInString(def.Symbol)
+	if size != len(def.Symbol) || symbol < 'a' || symbol > 'z' {
+		return ArmyNone, armyError("symbol must be a lowercase letter: %q", def.Symbol)
+	}
+	if army, found := symbolToArmy[symbol]; found {
+		if reflect.DeepEqual(lookupArmy(army).definition, def) {
+			return army, nil
+		}
+		return ArmyNone, armyError("symbol is already used: %s", def.Symbol)
+	}
+	if len(customArmies) == 0xff {
+		return ArmyNone, armyError("too many armies")
+	}
+	rules, err := def.compile()
+	if err != nil {
+		return ArmyNone, err
+	}
+	customArmies = append(customArmies, rules)
+	army := Army(len(customArmies) << 8)
+	armyNames[army] = def.Name
+	armyToSymbol[army] = symbol
+	symbolToArmy[symbol] = army
+	return army, nil
+}
+
+// LoadArmyFile reads an army definition from a JSON file and registers it. See
+// ParseArmyDefinition and RegisterArmy.
+func LoadArmyFile(path string) (Army, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ArmyNone, err
+	}
+	def, err := ParseArmyDefinition(data)
+	if err != nil {
+		return ArmyNone, fmt.Errorf("%s: %s", path, err)
+	}
+	return RegisterArmy(def)
+}
+
+// LoadArmyFiles loads each of the given files with LoadArmyFile, stopping at
+// the first error.
+func LoadArmyFiles(paths []string) error {
+	for _, path := range paths {
+		if _, err := LoadArmyFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Definition returns the definition of the army, and false if the army
+// doesn't exist.
+func (a Army) Definition() (ArmyDefinition, bool) {
+	if _, found := armyToSymbol[a]; !found {
+		return ArmyDefinition{}, false
+	}
+	return lookupArmy(a).definition, true
+}
+
+func armyError(format string, args ...interface{}) error {
+	return ParseError("Invalid army definition: " + fmt.Sprintf(format, args...))
+}
+
+// Checks the definition and converts it to the form used by the move
+// generator.
+func (def ArmyDefinition) compile() (*armyRules, error) {
+	a := &armyRules{
+		definition: def,
+		backRank:   def.BackRank,
+		kingTurn:   def.KingTurn,
+	}
+	if a.backRank == "" {
+		a.backRank = "RNBQKBNR"
+	}
+	if len(a.backRank) != 8 || !strings.Contains(a.backRank, "K") {
+		return nil, armyError("back rank must have 8 pieces including a king: %s", a.backRank)
+	}
+	for _, code := range a.backRank {
+		if piece, err := ParseFenPiece(code); err != nil || piece.Color() != ColorWhite || piece.Type() == TypePawn {
+			return nil, armyError("invalid piece in back rank: %c", code)
+		}
+	}
+
+	if len(def.Promotions) == 0 {
+		a.promotions = promotions
+	}
+	for _, name := range def.Promotions {
+		t := typeNames[name]
+		if t == TypeNone || t == TypeKing || t == TypePawn {
+			return nil, armyError("invalid promotion: %s", name)
+		}
+		a.promotions = append(a.promotions, t)
+	}
+
+	for name := range def.Pieces {
+		if typeNames[name] == TypeNone {
+			return nil, armyError("unknown piece type: %s", name)
+		}
+	}
+	for t := TypeKing; t <= TypePawn; t++ {
+		name := t.String()
+		pieceDef, special := def.Pieces[name]
+		if !special {
+			pieceDef = basicPieces[t]
+		}
+		rules, err := compilePiece(t, pieceDef)
+		if err != nil {
+			return nil, armyError("%s: %s", name, err)
+		}
+		rules.special = special
+		if rules.whirlwind && !a.kingTurn {
+			return nil, armyError("%s: whirlwind attacks need a king-turn", name)
+		}
+		if rules.castles && (a.backRank[0] != 'R' || a.backRank[4] != 'K' || a.backRank[7] != 'R') {
+			return nil, armyError("%s: castling needs the king on the e-file and rooks in the corners", name)
+		}
+		if rules.capturableBy != captureAny || rules.capturableWithin != 0 {
+			a.protected = append(a.protected, t)
+		}
+		if rules.rampage {
+			a.rampagers = append(a.rampagers, t)
+		}
+		if len(rules.rides) > 0 || len(rules.hops) > 0 {
+			a.complexThreats = true
+		}
+		a.pieces[pieceTypeIdx(t)] = rules
+	}
+	return a, nil
+}
+
+// Checks a single piece definition and converts it to the form used by the
+// move generator.
+func compilePiece(t PieceType, def PieceDefinition) (pieceRules, error) {
+	r := pieceRules{
+		name:             def.Name,
+		value:            def.Value,
+		duelRank:         def.DuelRank,
+		capturableWithin: def.CapturableWithin,
+		capturesOwn:      def.CapturesOwn,
+		castles:          def.Castles,
+		whirlwind:        def.Whirlwind,
+		rampage:          def.Rampage,
+		staysOnCapture:   def.StaysOnCapture,
+	}
+	if r.duelRank == 0 {
+		r.duelRank = DuelingRank(t)
+	}
+	var found bool
+	if r.captures, found = captureRuleNames[def.Captures]; !found {
+		return r, fmt.Errorf("invalid captures: %s", def.Captures)
+	}
+	if r.capturableBy, found = captureRuleNames[def.CapturableBy]; !found || r.capturableBy == captureNotKings {
+		return r, fmt.Errorf("invalid capturable_by: %s", def.CapturableBy)
+	}
+	if r.capturableWithin < 0 || r.capturableWithin > 7 {
+		return r, fmt.Errorf("invalid capturable_within: %d", r.capturableWithin)
+	}
+	switch {
+	case t == TypeKing && (r.capturableBy != captureAny || r.capturableWithin != 0):
+		return r, fmt.Errorf("kings can always be captured")
+	case t != TypeKing && (r.castles || r.whirlwind):
+		return r, fmt.Errorf("only kings can castle or whirlwind")
+	case (t == TypeKing || t == TypePawn) && (r.rampage || r.staysOnCapture || len(def.Borrow) > 0):
+		return r, fmt.Errorf("kings and pawns can't rampage, stay on capture or borrow moves")
+	case r.rampage && len(def.Borrow) > 0:
+		return r, fmt.Errorf("rampaging pieces can't borrow moves")
+	case len(def.Moves) == 0:
+		return r, fmt.Errorf("no moves")
+	}
+	for _, name := range def.Borrow {
+		borrowed := typeNames[name]
+		if borrowed == TypeNone || borrowed == TypePawn {
+			return r, fmt.Errorf("invalid borrow: %s", name)
+		}
+		r.borrows = append(r.borrows, borrowed)
+	}
+
+	pawnMoves := 0
+	for _, move := range def.Moves {
+		dx, dy := abs(move.Vector[0]), abs(move.Vector[1])
+		needsVector := move.Kind == "leap" || move.Kind == "ride" || move.Kind == "hop"
+		if needsVector && (dx == 0 && dy == 0 || dx > 7 || dy > 7) {
+			return r, fmt.Errorf("invalid vector: %v", move.Vector)
+		} else if move.Range < 0 || move.Range > 7 {
+			return r, fmt.Errorf("invalid range: %d", move.Range)
+		} else if (move.Kind == "pawn" || move.Kind == "toward_kings") != (t == TypePawn) {
+			return r, fmt.Errorf("invalid move for %s: %s", t, move.Kind)
+		} else if r.rampage && (move.Kind != "ride" || dx+dy != 1 && (dx != 1 || dy != 1)) {
+			return r, fmt.Errorf("rampaging pieces can only ride along ranks, files and diagonals")
+		}
+		limit := move.Range
+		if limit == 0 {
+			limit = 7
+		}
+		switch move.Kind {
+		case "leap":
+			for _, v := range reflections(dx, dy) {
+				for sq := range r.leaps {
+					x, y := sq%8+v.dx, sq/8+v.dy
+					if x >= 0 && x < 8 && y >= 0 && y < 8 {
+						r.leaps[sq] |= SquareFromCoords(x, y).mask()
+					}
+				}
+			}
+		case "ride":
+			switch {
+			case dx == 1 && dy == 1:
+				r.diagRange = maxInt(r.diagRange, limit)
+			case dx+dy == 1:
+				r.orthRange = maxInt(r.orthRange, limit)
+			default:
+				for _, v := range reflections(dx, dy) {
+					r.rides = append(r.rides, vector{v.dx, v.dy, limit})
+				}
+			}
+			if r.rampage {
+				r.rampageRange = maxInt(r.rampageRange, limit)
+			}
+		case "hop":
+			for _, v := range reflections(dx, dy) {
+				r.hops = append(r.hops, vector{v.dx, v.dy, 0})
+			}
+		case "teleport":
+			r.teleport = true
+			for colorIdx := range r.teleports {
+				mask := maskFull
+				if move.ExcludeLastRank {
+					mask &^= maskRank[7*colorIdx]
+				}
+				r.teleports[colorIdx] |= mask
+			}
+		case "pawn":
+			pawnMoves++
+			if move.Range > 2 {
+				return r, fmt.Errorf("invalid range: %d", move.Range)
+			}
+			r.doubleStep = move.Range == 2
+		case "toward_kings":
+			r.towardKings = true
+		default:
+			return r, fmt.Errorf("invalid move kind: %s", move.Kind)
+		}
+	}
+	if t == TypePawn && pawnMoves != 1 {
+		return r, fmt.Errorf("pawns need exactly one pawn move")
+	}
+	return r, nil
+}
+
+// reflections returns every distinct direction obtained by reflecting and
+// rotating the vector.
+func reflections(dx, dy int) []vector {
+	var results []vector
+	for _, v := range []vector{
+		{dx, dy, 0}, {-dx, dy, 0}, {dx, -dy, 0}, {-dx, -dy, 0},
+		{dy, dx, 0}, {-dy, dx, 0}, {dy, -dx, 0}, {-dy, -dx, 0},
+	} {
+		duplicate := false
+		for _, seen := range results {
+			duplicate = duplicate || seen == v
+		}
+		if !duplicate {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// movesMask returns the squares reached by the moves of the given piece rules,
+// other than pawn moves, from the given square.
+func (g *Game) movesMask(r *pieceRules, color Color, from Square) uint64 {
+	occupied := g.board.occupiedMask()
+	if r.rampage {
+		occupied = 0
+	}
+	mask := r.leaps[from.Address]
+	if r.diagRange > 0 {
+		diag := diagMask[from.Address] & occupied
+		mask |= diagAttackMask[from.Address][diag] & rangeMask[r.diagRange][from.Address]
+	}
+	if r.orthRange > 0 {
+		orth := orthMask[from.Address] & occupied
+		mask |= orthAttackMask[from.Address][orth] & rangeMask[r.orthRange][from.Address]
+	}
+	for _, v := range r.rides {
+		x, y := from.X(), from.Y()
+		for i := 0; i < v.limit; i++ {
+			x, y = x+v.dx, y+v.dy
+			if x < 0 || x >= 8 || y < 0 || y >= 8 {
+				break
+			}
+			sq := SquareFromCoords(x, y).mask()
+			mask |= sq
+			if occupied&sq != 0 {
+				break
+			}
+		}
+	}
+	for _, v := range r.hops {
+		hurdle := false
+		for x, y := from.X()+v.dx, from.Y()+v.dy; x >= 0 && x < 8 && y >= 0 && y < 8; x, y = x+v.dx, y+v.dy {
+			sq := SquareFromCoords(x, y).mask()
+			if hurdle {
+				mask |= sq
+				break
+			}
+			hurdle = occupied&sq != 0
+		}
+	}
+	if r.teleport {
+		mask |= r.teleports[ColorIdx(color)]
+	}
+	return mask
+}
+
+// protectedMask returns the mask of pieces which can't be captured by the
+// given piece moving from the given square, because of the rules of the
+// defending army.
+func (g *Game) protectedMask(attacker Piece, from Square) uint64 {
+	mask := maskEmpty
+	for colorIdx := 0; colorIdx < 2; colorIdx++ {
+		army := g.armyRules(colorIdx)
+		for _, t := range army.protected {
+			rules := &army.pieces[pieceTypeIdx(t)]
+			pieces := g.board.colors[colorIdx] & g.board.pieceMask(t)
+			if rules.capturableBy == captureNone ||
+				rules.capturableBy == captureKings && attacker.Type() != TypeKing {
+				mask |= pieces
+			}
+			if rules.capturableWithin > 0 {
+				mask |= pieces &^ rangeMask[rules.capturableWithin][from.Address]
+			}
+		}
+	}
+	return mask
+}
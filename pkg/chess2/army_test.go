@@ -0,0 +1,173 @@
+package chess2
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fairyArmyJSON = `{
+	"name": "Fairy",
+	"symbol": "f",
+	"pieces": {
+		"queen": {"name": "Amazon", "duel_rank": 12, "value": 1200, "moves": [
+			{"kind": "ride", "vector": [1, 0]},
+			{"kind": "ride", "vector": [1, 1]},
+			{"kind": "leap", "vector": [1, 2]}
+		]},
+		"bishop": {"name": "Grasshopper", "moves": [
+			{"kind": "hop", "vector": [1, 0]},
+			{"kind": "hop", "vector": [1, 1]}
+		]},
+		"knight": {"name": "Camel", "moves": [
+			{"kind": "leap", "vector": [1, 3]}
+		]},
+		"rook": {"name": "Nightrider", "moves": [
+			{"kind": "ride", "vector": [1, 2]}
+		]}
+	}
+}`
+
+// Registers the Fairy army, which is shared by every test that needs it.
+func fairyArmy(t *testing.T) Army {
+	def, err := ParseArmyDefinition([]byte(fairyArmyJSON))
+	require.NoError(t, err)
+	army, err := RegisterArmy(def)
+	require.NoError(t, err)
+	return army
+}
+
+func TestBuiltinArmyDefinitions(t *testing.T) {
+	for army, symbol := range armyToSymbol {
+		if army >= 0x100 {
+			continue
+		}
+		def, found := army.Definition()
+		require.True(t, found, "Army: %s", army)
+		assert.Equal(t, army.String(), def.Name)
+		assert.Equal(t, string(symbol), def.Symbol)
+	}
+	assert.Equal(t, "Elephant", PieceNameAnimalsRook.String())
+	assert.Equal(t, "Warrior King", PieceNameTwoKingsKing.String())
+	assert.Equal(t, "Classic King", PieceNameClassicKing.String())
+	_, found := Army(0x7f00).Definition()
+	assert.False(t, found)
+}
+
+func TestInvalidArmyDefinitions(t *testing.T) {
+	cases := map[string]string{
+		"bad json":     `{"name": "Bad", "symbol": "x", "pieces": [}`,
+		"unknown type": `{"pieces": {"dragon": {"moves": [{"kind": "leap", "vector": [1, 0]}]}}}`,
+		"unknown kind": `{"pieces": {"rook": {"moves": [{"kind": "fly", "vector": [1, 0]}]}}}`,
+		"no moves":     `{"pieces": {"rook": {"moves": []}}}`,
+		"zero vector":  `{"pieces": {"rook": {"moves": [{"kind": "ride", "vector": [0, 0]}]}}}`,
+		"pawn move":    `{"pieces": {"rook": {"moves": [{"kind": "pawn"}]}}}`,
+		"pawn without pawn move": `{"pieces": {"pawn": {"moves": [
+			{"kind": "leap", "vector": [1, 0]}
+		]}}}`,
+		"no king":              `{"back_rank": "RNBQQBNR"}`,
+		"promote to king":      `{"promotions": ["king"]}`,
+		"castles off e-file":   `{"back_rank": "RNBKQBNR", "pieces": {"king": {"castles": true, "moves": [{"kind": "leap", "vector": [1, 0]}]}}}`,
+		"castles not king":     `{"pieces": {"rook": {"castles": true, "moves": [{"kind": "ride", "vector": [1, 0]}]}}}`,
+		"whirlwind":            `{"pieces": {"king": {"whirlwind": true, "moves": [{"kind": "leap", "vector": [1, 0]}]}}}`,
+		"rampage leap":         `{"pieces": {"rook": {"rampage": true, "moves": [{"kind": "leap", "vector": [1, 0]}]}}}`,
+		"protected king":       `{"pieces": {"king": {"capturable_by": "none", "moves": [{"kind": "leap", "vector": [1, 0]}]}}}`,
+		"unknown capture rule": `{"pieces": {"rook": {"captures": "queens", "moves": [{"kind": "ride", "vector": [1, 0]}]}}}`,
+	}
+	for name, data := range cases {
+		_, err := ParseArmyDefinition([]byte(data))
+		assert.Error(t, err, "Case: %s", name)
+	}
+}
+
+func TestRegisterArmy(t *testing.T) {
+	army := fairyArmy(t)
+	assert.Equal(t, army, fairyArmy(t), "registering twice returns the same army")
+	assert.Equal(t, "Fairy", army.String())
+	assert.Equal(t, army, symbolToArmy['f'])
+	assert.Equal(t, "Grasshopper", NewPiece(TypeBishop, army, ColorWhite).Name().String())
+	assert.Equal(t, 12, NewPiece(TypeQueen, army, ColorWhite).DuelingRank())
+	assert.Equal(t, 1200, NewDefaultEvaluator().PieceValue(NewPiece(TypeQueen, army, ColorWhite)))
+
+	def, err := ParseArmyDefinition([]byte(fairyArmyJSON))
+	require.NoError(t, err)
+	def.Name = "Other Fairy"
+	_, err = RegisterArmy(def)
+	assert.Error(t, err, "symbol is already used")
+	def.Symbol = "c"
+	_, err = RegisterArmy(def)
+	assert.Error(t, err, "symbol is a built-in army")
+	def.Symbol = "F"
+	_, err = RegisterArmy(def)
+	assert.Error(t, err, "symbol is not lowercase")
+
+	game := GameFromArmies(army, ArmyClassic)
+	parsed, err := ParseEpd(EncodeEpd(game))
+	require.NoError(t, err)
+	assert.Equal(t, game, parsed)
+}
+
+func TestLoadArmyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chess2")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fairy.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(fairyArmyJSON), 0644))
+	require.NoError(t, LoadArmyFiles([]string{path}))
+	assert.Equal(t, fairyArmy(t), symbolToArmy['f'])
+	assert.Error(t, LoadArmyFiles([]string{path, filepath.Join(dir, "missing.json")}))
+}
+
+func TestCustomArmyAttacks(t *testing.T) {
+	fairyArmy(t)
+	cases := map[string]struct {
+		epd    string
+		from   string
+		attack []string
+	}{
+		"grasshopper": {
+			epd:    "4k3/8/3P1p2/8/3B4/8/8/4K3 w - - 0 1 fc 33",
+			from:   "d4",
+			attack: []string{"d7", "g7"},
+		},
+		"camel": {
+			epd:    "4k3/8/8/8/3N4/8/8/7K w - - 0 1 fc 33",
+			from:   "d4",
+			attack: []string{"a3", "a5", "c1", "c7", "e1", "e7", "g3", "g5"},
+		},
+		"nightrider": {
+			epd:    "4k3/8/8/2p5/8/8/8/R3K3 w - - 0 1 fc 33",
+			from:   "a1",
+			attack: []string{"b3", "c2", "c5", "e3", "g4"},
+		},
+	}
+	for name, config := range cases {
+		game, err := ParseEpd(config.epd)
+		require.NoError(t, err, "Case: %s", name)
+		var expected uint64
+		for _, sq := range config.attack {
+			expected |= SquareFromName(sq).mask()
+		}
+		assert.Equal(t, expected, game.attackMask(SquareFromName(config.from)), "Case: %s", name)
+	}
+}
+
+func TestCustomArmyMoveGeneration(t *testing.T) {
+	army := fairyArmy(t)
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{army, ArmyClassic, ArmyAnimals, ArmyTwoKings}
+	for _, other := range armies {
+		for _, game := range []Game{GameFromArmies(army, other), GameFromArmies(other, army)} {
+			for ply := 0; ply < 40 && game.GameState() == GameInProgress; ply++ {
+				checkMoveGeneration(t, game)
+				moves := game.GenerateLegalMoves()
+				game = game.ApplyMove(moves[rng.Intn(len(moves))])
+			}
+		}
+	}
+}
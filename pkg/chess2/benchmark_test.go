@@ -25,10 +25,11 @@ func randomBoard() Board {
 // Generates a random, sometimes invalid game
 func randomGame() Game {
 	game := Game{
-		flags:  VariantChess2,
-		board:  randomBoard(),
-		armies: [2]Army{Army(rand.Uint64() & armyMask), Army(rand.Uint64() & armyMask)},
-		stones: [2]int{rand.Intn(7), rand.Intn(7)},
+		flags:     VariantChess2,
+		board:     randomBoard(),
+		armies:    [2]Army{Army(rand.Uint64() & armyMask), Army(rand.Uint64() & armyMask)},
+		stones:    [2]int{rand.Intn(7), rand.Intn(7)},
+		maxStones: DefaultMaxStones,
 	}
 	return game
 }
@@ -103,6 +103,8 @@ type Board struct {
 	pieces [6]uint64
 	// Mask of which squares are occupied by which color
 	colors [2]uint64
+	// Zobrist key of the pieces on the board
+	hash uint64
 }
 
 // PieceAt returns the piece at the given square in the receiver, and a
@@ -138,13 +140,24 @@ func (b *Board) SetPieceAt(s Square, p Piece) {
 	squareMask := s.mask()
 	b.colors[ColorIdx(p.Color())] |= squareMask
 	b.pieces[pieceTypeIdx(p.Type())] |= squareMask
+	b.hash ^= zobristPiece(p, s)
 }
 
 // ClearPieceAt adjusts the receiver to have an empty square at the provided
 // space.
 func (b *Board) ClearPieceAt(s Square) {
 	squareMask := s.mask()
+	if (b.colors[0]|b.colors[1])&squareMask == 0 {
+		return
+	}
+	colorIdx := 0
+	if b.colors[1]&squareMask != 0 {
+		colorIdx = 1
+	}
 	for i := range b.pieces {
+		if b.pieces[i]&squareMask != 0 {
+			b.hash ^= zobristPieces[colorIdx][i][s.Address]
+		}
 		b.pieces[i] &= ^squareMask
 	}
 	b.colors[0] &= ^squareMask
@@ -164,12 +177,17 @@ func (b *Board) MovePiece(from, to Square) {
 // find type are replaced by a corresponding piece of the same color of the
 // replace type.
 func (b *Board) ReplacePieces(color Color, find, replace PieceType) {
-	mask := b.colors[ColorIdx(color)]
-	idx := pieceTypeIdx(find)
-	mask &= b.pieces[idx]
-	b.pieces[idx] &= ^mask
-	idx = pieceTypeIdx(replace)
-	b.pieces[idx] |= mask
+	colorIdx := ColorIdx(color)
+	mask := b.colors[colorIdx]
+	findIdx := pieceTypeIdx(find)
+	mask &= b.pieces[findIdx]
+	b.pieces[findIdx] &= ^mask
+	replaceIdx := pieceTypeIdx(replace)
+	b.pieces[replaceIdx] |= mask
+	eachSquareInMask(mask, func(sq Square) {
+		b.hash ^= zobristPieces[colorIdx][findIdx][sq.Address]
+		b.hash ^= zobristPieces[colorIdx][replaceIdx][sq.Address]
+	})
 }
 
 func (b *Board) occupiedMask() uint64 {
@@ -0,0 +1,105 @@
+package chess2
+
+import (
+	"strings"
+)
+
+const (
+	// Chess960Positions is the number of starting positions available to
+	// GameFromChess960.
+	Chess960Positions = 960
+	// Chess960Standard is the number of the standard starting position.
+	Chess960Standard = 518
+)
+
+// The files that the pieces of the back rank start on in a standard game.
+var standardFiles = [8]int{0, 1, 2, 3, 4, 5, 6, 7}
+
+// The ways to place two knights on five empty squares, in the order used by
+// Scharnagl's numbering.
+var knightPlacements = [10][2]int{
+	{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2},
+	{1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4},
+}
+
+// GameFromChess960 initializes a new Game with the provided armies, with the
+// back ranks shuffled as in Chess960. Positions are numbered from 0 to 959
+// using Scharnagl's numbering, where 518 is the standard starting position,
+// and both players use the same arrangement.
+//
+// The arrangement moves each piece of an army's back rank to the square of
+// the standard piece in the same place, so that Two Kings' second king stands
+// where the queen would, and the king always starts between the rooks.
+// Castling follows the Chess960 rules, see ValidatePseudoLegalMove.
+func GameFromChess960(white, black Army, position int) (Game, error) {
+	if position < 0 || position >= Chess960Positions {
+		return Game{}, ParseError("Invalid Chess960 position")
+	}
+	return startingGame(white, black, chess960Files(position)), nil
+}
+
+// chess960Files returns the file of each piece of the standard back rank,
+// RNBQKBNR, in the given Chess960 position.
+func chess960Files(position int) [8]int {
+	var empty []int
+	take := func(idx int) int {
+		file := empty[idx]
+		empty = append(empty[:idx], empty[idx+1:]...)
+		return file
+	}
+	n := position
+	// The bishops go on opposite colors, counting from the a-file, then the
+	// queen and knights go on the remaining empty squares.
+	light := n%4*2 + 1
+	n /= 4
+	dark := n % 4 * 2
+	n /= 4
+	for file := 0; file < 8; file++ {
+		if file != light && file != dark {
+			empty = append(empty, file)
+		}
+	}
+	queen := take(n % 6)
+	knights := knightPlacements[n/6]
+	rightKnight := take(knights[1])
+	leftKnight := take(knights[0])
+	// The king goes between the rooks on the three squares left over.
+	return [8]int{empty[0], leftKnight, dark, queen, empty[1], light, rightKnight, empty[2]}
+}
+
+// startingGame returns a new game where the pieces of each army's back rank
+// start on the given files.
+func startingGame(white, black Army, files [8]int) Game {
+	var ranks [2][8]byte
+	for colorIdx, army := range []Army{white, black} {
+		backRank := lookupArmy(army).backRank
+		for i, file := range files {
+			ranks[colorIdx][file] = backRank[i]
+		}
+	}
+	fen := strings.ToLower(string(ranks[1][:])) +
+		"/pppppppp/8/8/8/8/PPPPPPPP/" + string(ranks[0][:])
+	board, err := ParseFen(fen)
+	if err != nil {
+		panic(err)
+	}
+	game := Game{
+		flags:     VariantChess2,
+		board:     board,
+		armies:    [2]Army{white, black},
+		stones:    [2]int{DefaultStones, DefaultStones},
+		maxStones: DefaultMaxStones,
+		epSquare:  InvalidSquare,
+	}
+	// Both players can castle with the rooks which started in the corners.
+	for _, y := range []int{0, 7} {
+		for _, i := range []int{0, 7} {
+			sq := SquareFromCoords(files[i], y)
+			if p, found := board.PieceAt(sq); found && p.Type() == TypeRook {
+				game.castlingRights |= sq.mask()
+			}
+		}
+	}
+	game.hash = game.stateHash()
+	return game
+}
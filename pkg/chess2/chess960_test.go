@@ -0,0 +1,97 @@
+package chess2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameFromChess960(t *testing.T) {
+	cases := map[string]struct {
+		white, black Army
+		position     int
+		epd          string
+	}{
+		"first": {
+			white:    ArmyClassic,
+			black:    ArmyClassic,
+			position: 0,
+			epd:      "bbqnnrkr/pppppppp/8/8/8/8/PPPPPPPP/BBQNNRKR w KFkf - 0 1 cc 33",
+		},
+		"standard": {
+			white:    ArmyClassic,
+			black:    ArmyAnimals,
+			position: Chess960Standard,
+			epd:      "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 ca 33",
+		},
+		"last": {
+			white:    ArmyNemesis,
+			black:    ArmyReaper,
+			position: 959,
+			epd:      "rkrnnqbb/pppppppp/8/8/8/8/PPPPPPPP/RKRNNQBB w CQcq - 0 1 nr 33",
+		},
+		// The second king of the Two Kings takes the place of the queen.
+		"two kings": {
+			white:    ArmyTwoKings,
+			black:    ArmyEmpowered,
+			position: 0,
+			epd:      "bbqnnrkr/pppppppp/8/8/8/8/PPPPPPPP/BBKNNRKR w KFkf - 0 1 ke 33",
+		},
+	}
+	for name, config := range cases {
+		game, err := GameFromChess960(config.white, config.black, config.position)
+		require.NoError(t, err, "Case: %s", name)
+		assert.Equal(t, config.epd, EncodeEpd(game), "Case: %s", name)
+		assert.Equal(t, game.computeHash(), game.Hash(), "Case: %s", name)
+	}
+
+	_, err := GameFromChess960(ArmyClassic, ArmyClassic, Chess960Positions)
+	assert.Error(t, err)
+	_, err = GameFromChess960(ArmyClassic, ArmyClassic, -1)
+	assert.Error(t, err)
+}
+
+func TestChess960Files(t *testing.T) {
+	seen := map[string]bool{}
+	for position := 0; position < Chess960Positions; position++ {
+		files := chess960Files(position)
+		var rank [8]byte
+		for i, file := range files {
+			require.Zero(t, rank[file], "Position: %d", position)
+			rank[file] = "RNBQKBNR"[i]
+		}
+		// The bishops are on opposite colors, and the king is between the
+		// rooks.
+		assert.NotEqual(t, files[2]%2, files[5]%2, "Position: %d", position)
+		assert.True(t, files[0] < files[4] && files[4] < files[7], "Position: %d", position)
+		seen[string(rank[:])] = true
+	}
+	assert.Len(t, seen, Chess960Positions)
+}
+
+func TestChess960Games(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals}
+	for _, white := range armies {
+		for _, black := range armies {
+			game, err := GameFromChess960(white, black, rng.Intn(Chess960Positions))
+			require.NoError(t, err)
+			for ply := 0; ply < 40 && game.GameState() == GameInProgress; ply++ {
+				checkMoveGeneration(t, game)
+				before := EncodeEpd(game)
+				moves := game.GenerateLegalMoves()
+				for _, move := range moves {
+					expected := game.ApplyMove(move)
+					undo := game.MakeMove(move)
+					require.Equal(t, EncodeEpd(expected), EncodeEpd(game), "EPD: %s  Move: %s", before, move)
+					require.Equal(t, expected.Hash(), game.Hash(), "EPD: %s  Move: %s", before, move)
+					game.UnmakeMove(undo)
+					require.Equal(t, before, EncodeEpd(game), "Move: %s", move)
+				}
+				game.MakeMove(moves[rng.Intn(len(moves))])
+			}
+		}
+	}
+}
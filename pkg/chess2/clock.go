@@ -0,0 +1,199 @@
+package chess2
+
+import "time"
+
+// A TimeControl describes how much time the players have to make their moves.
+// The zero value has no limits at all.
+//
+// Common time controls are:
+//
+//   - Sudden death: only Base is set.
+//   - Fischer increment: Increment is added to the clock after every turn.
+//   - Bronstein delay: the time used on each turn, up to Delay, is given back
+//     after the turn, so a player never gains time from moving quickly.
+type TimeControl struct {
+	// Base is the time each player has for the whole game. Zero means that
+	// there is no limit for the whole game.
+	Base time.Duration
+	// Increment is added to a player's clock after each of their turns.
+	Increment time.Duration
+	// Delay is the most time that is given back after each turn.
+	Delay time.Duration
+	// MoveLimit is the most time that a single turn may take. Zero means
+	// that there is no limit for a single turn.
+	MoveLimit time.Duration
+}
+
+// A Clock tracks the time remaining for each player under a TimeControl. A
+// Clock never reads the time itself: the current time is passed to each
+// method, so that it can be driven by a fake time source.
+//
+// A turn starts when the player has a move to make and ends once the
+// opponent is to move, so both moves of a Two Kings king-turn are part of the
+// same turn. A turn can be interrupted while the opponent decides something,
+// like whether to challenge a capture, and the opponent's clock runs instead.
+type Clock struct {
+	control   TimeControl
+	remaining [2]time.Duration
+	// toMove is the player whose turn it is, if running is set, and
+	// turnStart is when their turn began.
+	toMove    Color
+	turnStart time.Time
+	running   bool
+	// interrupted is set while the opponent is deciding something, and
+	// interruptStart is when they began. paused is the time taken by the
+	// earlier interruptions of the turn.
+	interrupted    bool
+	interruptStart time.Time
+	paused         time.Duration
+}
+
+// NewClock creates a stopped Clock where both players have the base time of
+// the given TimeControl.
+func NewClock(control TimeControl) *Clock {
+	return &Clock{
+		control:   control,
+		remaining: [2]time.Duration{control.Base, control.Base},
+	}
+}
+
+// TimeControl returns the time control used by the receiver.
+func (c *Clock) TimeControl() TimeControl {
+	return c.control
+}
+
+// Start starts the turn of the player to move in the given game. The clock
+// stays stopped if the game is over.
+func (c *Clock) Start(game *Game, now time.Time) {
+	c.toMove = game.ToMove()
+	c.turnStart = now
+	c.running = game.GameState() == GameInProgress
+	c.interrupted = false
+	c.paused = 0
+}
+
+// Press is called after a move is made, with the game after the move. If the
+// opponent is now to move, the current turn ends and the opponent's begins. If
+// the same player is still to move, as after the first move of a king-turn,
+// the turn continues. The clock stops once the game is over. Press doesn't
+// check whether the player ran out of time; see Flagged.
+func (c *Clock) Press(game *Game, now time.Time) {
+	if !c.running {
+		return
+	}
+	c.Resume(now)
+	if game.ToMove() != c.toMove || game.GameState() != GameInProgress {
+		c.endTurn(now)
+		c.Start(game, now)
+	}
+}
+
+// Stop ends the current turn without starting another one, for example when
+// the game ends for a reason other than a move.
+func (c *Clock) Stop(now time.Time) {
+	if c.running {
+		c.Resume(now)
+		c.endTurn(now)
+		c.running = false
+	}
+}
+
+// Interrupt pauses the turn of the player to move and starts the opponent's
+// clock, while the opponent decides something during the turn. An
+// interruption doesn't earn an increment or delay, but it is limited by the
+// MoveLimit. It has no effect if the clock is stopped or already interrupted.
+func (c *Clock) Interrupt(now time.Time) {
+	if c.running && !c.interrupted {
+		c.interrupted = true
+		c.interruptStart = now
+	}
+}
+
+// Resume charges the opponent for the time taken by an interruption and
+// carries on with the turn of the player to move. It has no effect if the
+// turn isn't interrupted.
+func (c *Clock) Resume(now time.Time) {
+	if !c.interrupted {
+		return
+	}
+	used := now.Sub(c.interruptStart)
+	c.interrupted = false
+	c.paused += used
+	if c.control.Base > 0 {
+		c.remaining[ColorIdx(OtherColor(c.toMove))] -= used
+	}
+}
+
+// Returns the time that the player to move has used on their turn so far.
+func (c *Clock) turnUsed(now time.Time) time.Duration {
+	used := now.Sub(c.turnStart) - c.paused
+	if c.interrupted {
+		used -= now.Sub(c.interruptStart)
+	}
+	return used
+}
+
+// Charges the player to move for the turn which ends at the given time.
+func (c *Clock) endTurn(now time.Time) {
+	if c.control.Base == 0 {
+		return
+	}
+	colorIdx := ColorIdx(c.toMove)
+	used := c.turnUsed(now)
+	refund := c.control.Delay
+	if used < refund {
+		refund = used
+	}
+	c.remaining[colorIdx] += refund - used + c.control.Increment
+}
+
+// Remaining returns the time the given player has left at the given time. If
+// the game has no Base time, this is always zero.
+func (c *Clock) Remaining(color Color, now time.Time) time.Duration {
+	remaining := c.remaining[ColorIdx(color)]
+	if c.control.Base == 0 || !c.running {
+		return remaining
+	} else if color == c.toMove {
+		remaining -= c.turnUsed(now)
+	} else if c.interrupted {
+		remaining -= now.Sub(c.interruptStart)
+	}
+	return remaining
+}
+
+// Running returns the player whose clock is running, which is the opponent of
+// the player to move while their turn is interrupted, and false if the clock
+// is stopped.
+func (c *Clock) Running() (Color, bool) {
+	if c.interrupted {
+		return OtherColor(c.toMove), c.running
+	}
+	return c.toMove, c.running
+}
+
+// Flagged returns true if the player whose clock is running has run out of
+// time at the given time, either for the whole game or for their current turn
+// or interruption.
+func (c *Clock) Flagged(now time.Time) bool {
+	if !c.running {
+		return false
+	}
+	color, used := c.toMove, c.turnUsed(now)
+	if c.interrupted {
+		color, used = OtherColor(c.toMove), now.Sub(c.interruptStart)
+	}
+	if c.control.MoveLimit > 0 && used > c.control.MoveLimit {
+		return true
+	}
+	return c.control.Base > 0 && c.Remaining(color, now) <= 0
+}
+
+// GameState returns GameOverWhite or GameOverBlack if the opponent has run out
+// of time at the given time, and GameInProgress otherwise.
+func (c *Clock) GameState(now time.Time) GameState {
+	if !c.Flagged(now) {
+		return GameInProgress
+	}
+	color, _ := c.Running()
+	return winner(OtherColor(color))
+}
@@ -0,0 +1,204 @@
+package chess2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	cases := map[string]struct {
+		control TimeControl
+		// Seconds taken by each move, starting with white's e2e4.
+		moves []float64
+		// Time remaining for each player after the moves.
+		white, black time.Duration
+		flagged      bool
+	}{
+		"no limits": {
+			moves: []float64{100, 100, 100},
+		},
+		"sudden death": {
+			control: TimeControl{Base: time.Minute},
+			moves:   []float64{10, 20, 5},
+			white:   45 * time.Second,
+			black:   40 * time.Second,
+		},
+		"sudden death flagged": {
+			control: TimeControl{Base: time.Minute},
+			moves:   []float64{10, 20, 51},
+			white:   -time.Second,
+			black:   40 * time.Second,
+			flagged: true,
+		},
+		"fischer increment": {
+			control: TimeControl{Base: time.Minute, Increment: 2 * time.Second},
+			moves:   []float64{1, 10, 1},
+			white:   60 * time.Second,
+			black:   52 * time.Second,
+		},
+		"bronstein delay": {
+			control: TimeControl{Base: time.Minute, Delay: 2 * time.Second},
+			moves:   []float64{1, 10, 3},
+			white:   57 * time.Second,
+			black:   52 * time.Second,
+		},
+		// The delay is only given back after the turn, so the player can't
+		// use it to avoid running out of time.
+		"bronstein delay flagged": {
+			control: TimeControl{Base: 10 * time.Second, Delay: 5 * time.Second},
+			moves:   []float64{11},
+			white:   -time.Second,
+			black:   10 * time.Second,
+			flagged: true,
+		},
+		"move limit": {
+			control: TimeControl{MoveLimit: 30 * time.Second},
+			moves:   []float64{29, 29, 31},
+			flagged: true,
+		},
+	}
+	ucis := []string{"e2e4", "e7e5", "g1f3", "b8c6"}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			game := GameFromArmies(ArmyClassic, ArmyClassic)
+			clock := NewClock(config.control)
+			clock.Start(&game, now)
+			for i, seconds := range config.moves {
+				require.False(t, clock.Flagged(now), "Case: %s  Move: %d", name, i)
+				now = now.Add(time.Duration(seconds * float64(time.Second)))
+				if i == len(config.moves)-1 {
+					// Leave the last turn running.
+					break
+				}
+				move, err := ParseUci(ucis[i])
+				require.NoError(t, err)
+				game = game.ApplyMove(move)
+				clock.Press(&game, now)
+			}
+			assert.Equal(t, config.white, clock.Remaining(ColorWhite, now), "Case: %s", name)
+			assert.Equal(t, config.black, clock.Remaining(ColorBlack, now), "Case: %s", name)
+			assert.Equal(t, config.flagged, clock.Flagged(now), "Case: %s", name)
+			if config.flagged {
+				assert.Equal(t, GameOverBlack, clock.GameState(now), "Case: %s", name)
+			} else {
+				assert.Equal(t, GameInProgress, clock.GameState(now), "Case: %s", name)
+			}
+		})
+	}
+}
+
+func TestClockKingTurn(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	game := GameFromArmies(ArmyTwoKings, ArmyClassic)
+	clock := NewClock(TimeControl{Base: time.Minute, Increment: time.Second})
+	clock.Start(&game, now)
+	for _, uci := range []string{"d2d4", "d1d2", "e7e5"} {
+		now = now.Add(10 * time.Second)
+		move, err := ParseUci(uci)
+		require.NoError(t, err)
+		game = game.ApplyMove(move)
+		clock.Press(&game, now)
+	}
+	// White's king-turn is a single turn, so the increment is only added
+	// once.
+	assert.Equal(t, 41*time.Second, clock.Remaining(ColorWhite, now))
+	assert.Equal(t, 51*time.Second, clock.Remaining(ColorBlack, now))
+	running, ok := clock.Running()
+	assert.True(t, ok)
+	assert.Equal(t, ColorWhite, running)
+}
+
+func TestClockGameOver(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	game, err := ParseEpd("k7/7R/8/8/8/8/8/K5R1 w - - 0 1 cc 33")
+	require.NoError(t, err)
+	clock := NewClock(TimeControl{Base: time.Minute})
+	clock.Start(&game, now)
+	now = now.Add(10 * time.Second)
+	move, err := ParseUci("g1g8")
+	require.NoError(t, err)
+	game = game.ApplyMove(move)
+	clock.Press(&game, now)
+	_, running := clock.Running()
+	assert.False(t, running)
+	now = now.Add(time.Hour)
+	assert.False(t, clock.Flagged(now))
+	assert.Equal(t, 50*time.Second, clock.Remaining(ColorWhite, now))
+	assert.Equal(t, time.Minute, clock.Remaining(ColorBlack, now))
+}
+
+func TestClockInterrupt(t *testing.T) {
+	cases := map[string]struct {
+		control TimeControl
+		// Seconds taken by white before the capture, by black to decide
+		// whether to challenge, and by white to respond.
+		move, challenge, response float64
+		white, black              time.Duration
+		flagged                   bool
+	}{
+		"fischer increment": {
+			control:   TimeControl{Base: time.Minute, Increment: time.Second},
+			move:      10,
+			challenge: 5,
+			response:  2,
+			white:     49 * time.Second,
+			black:     55 * time.Second,
+		},
+		// The delay covers the whole turn, not counting the interruption.
+		"bronstein delay": {
+			control:   TimeControl{Base: time.Minute, Delay: 5 * time.Second},
+			move:      2,
+			challenge: 10,
+			response:  2,
+			white:     time.Minute,
+			black:     50 * time.Second,
+		},
+		"defender flagged": {
+			control:   TimeControl{Base: time.Minute},
+			move:      10,
+			challenge: 61,
+			white:     50 * time.Second,
+			black:     -time.Second,
+			flagged:   true,
+		},
+		"defender move limit": {
+			control:   TimeControl{MoveLimit: 30 * time.Second},
+			move:      29,
+			challenge: 31,
+			flagged:   true,
+		},
+	}
+	for name, config := range cases {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		game := GameFromArmies(ArmyClassic, ArmyClassic)
+		clock := NewClock(config.control)
+		clock.Start(&game, now)
+		now = now.Add(time.Duration(config.move * float64(time.Second)))
+		clock.Interrupt(now)
+		running, _ := clock.Running()
+		assert.Equal(t, ColorBlack, running, "Case: %s", name)
+		now = now.Add(time.Duration(config.challenge * float64(time.Second)))
+		if config.flagged {
+			assert.True(t, clock.Flagged(now), "Case: %s", name)
+			assert.Equal(t, GameOverWhite, clock.GameState(now), "Case: %s", name)
+			assert.Equal(t, config.white, clock.Remaining(ColorWhite, now), "Case: %s", name)
+			assert.Equal(t, config.black, clock.Remaining(ColorBlack, now), "Case: %s", name)
+			continue
+		}
+		clock.Resume(now)
+		now = now.Add(time.Duration(config.response * float64(time.Second)))
+		assert.False(t, clock.Flagged(now), "Case: %s", name)
+		move, err := ParseUci("e2e4")
+		require.NoError(t, err)
+		game = game.ApplyMove(move)
+		clock.Press(&game, now)
+		running, _ = clock.Running()
+		assert.Equal(t, ColorBlack, running, "Case: %s", name)
+		assert.Equal(t, config.white, clock.Remaining(ColorWhite, now), "Case: %s", name)
+		assert.Equal(t, config.black, clock.Remaining(ColorBlack, now), "Case: %s", name)
+	}
+}
@@ -0,0 +1,306 @@
+package chess2
+
+import (
+	"math/rand"
+)
+
+// A DuelOpportunity describes a single piece captured by a move. Each capture
+// uses up one of the move's duels, whether or not the defender issues a
+// challenge.
+type DuelOpportunity struct {
+	// Square is where the captured piece stood.
+	Square Square
+	// Attacker is the capturing piece and Defender is the captured piece.
+	// Both include their armies.
+	Attacker, Defender Piece
+	// AttackerStones and DefenderStones are the stones each player holds
+	// before this duel, taking into account the duels earlier in the move.
+	AttackerStones, DefenderStones int
+	// MaxStones is the most stones that a player can hold.
+	MaxStones int
+}
+
+// IsDuelable returns true if the defender is allowed to challenge this
+// capture. Duels can't involve kings, and a player can't challenge the capture
+// of their own piece.
+func (o DuelOpportunity) IsDuelable() bool {
+	return o.Attacker.Type() != TypeKing && o.Defender.Type() != TypeKing &&
+		o.Attacker.Color() != o.Defender.Color()
+}
+
+// AttackerPays returns true if the attacker must pay a stone in order to duel
+// because the defender has a higher dueling rank.
+func (o DuelOpportunity) AttackerPays() bool {
+	return o.Attacker.DuelingRank() < o.Defender.DuelingRank()
+}
+
+// DuelOpportunities returns every capture made by the given move, in the order
+// that they happen. The duels already on the move are applied to earlier
+// captures, so a rampage which is stopped by a lost duel has no further
+// opportunities. The move is assumed to be pseudo-legal.
+func (g *Game) DuelOpportunities(move Move) []DuelOpportunity {
+	if move.IsDrop() || move.IsPass() {
+		return nil
+	}
+	var results []DuelOpportunity
+	p, _ := g.board.PieceAt(move.From)
+	p = p.WithArmy(g.armies[ColorIdx(p.Color())])
+	me := moveExecution{
+		epSquare:       g.epSquare,
+		attackerStones: g.stones[ColorIdx(g.toMove)],
+		defenderStones: g.stones[1-ColorIdx(g.toMove)],
+		duels:          move.Duels[:],
+		dryRun:         true,
+		captures:       &results,
+	}
+	g.handleAllCaptures(p, move, &me)
+	return results
+}
+
+// DuelRole is the part a player takes in a duel.
+type DuelRole int
+
+const (
+	// DuelChallenger is the defender, whose piece was captured, and who
+	// decides whether to issue a challenge.
+	DuelChallenger = DuelRole(iota)
+	// DuelResponder is the attacker, who responds to a challenge.
+	DuelResponder
+)
+
+// A MixedDuel is a probability distribution over duels. A pure strategy is a
+// MixedDuel with a single entry.
+type MixedDuel struct {
+	Duels         []Duel
+	Probabilities []float64
+}
+
+// PureDuel returns a MixedDuel which always chooses the given duel.
+func PureDuel(d Duel) MixedDuel {
+	return MixedDuel{Duels: []Duel{d}, Probabilities: []float64{1}}
+}
+
+// Sample chooses one of the duels at random according to the probabilities.
+func (m MixedDuel) Sample(rng *rand.Rand) Duel {
+	x := rng.Float64()
+	for i, p := range m.Probabilities {
+		if x < p {
+			return m.Duels[i]
+		}
+		x -= p
+	}
+	return m.Duels[len(m.Duels)-1]
+}
+
+// A DuelStrategy decides how many stones to bid in duels.
+type DuelStrategy interface {
+	// Bid returns the strategy of the player with the given role for the duel
+	// with the given index on the given move. The duels before the index must
+	// already be decided.
+	//
+	// For DuelChallenger, the returned duels are Duel{} to decline to
+	// challenge, or an incomplete duel created by DuelWithChallenge.
+	//
+	// For DuelResponder, move.Duels[index] must be an incomplete duel, and the
+	// returned duels are complete duels created from it by DuelWithResponse.
+	// Since bids are secret, the strategy must not depend on the challenge
+	// value of the incomplete duel.
+	Bid(game *Game, move Move, index int, role DuelRole) MixedDuel
+}
+
+// DefaultDuelStrategy treats each duel as a zero-sum game between the
+// challenger and the responder, where each player secretly chooses a bid. The
+// payoffs count the material lost when the attacker is killed (including any
+// pieces that a rampage would have gone on to capture) and the stones spent
+// and gained. The strategy plays an approximate equilibrium of that game, and
+// the challenger only challenges if the equilibrium favors them.
+type DefaultDuelStrategy struct {
+	// Evaluator provides the value of pieces and stones.
+	Evaluator *DefaultEvaluator
+	// Iterations is the number of rounds of fictitious play used to find the
+	// equilibrium. More iterations are more accurate.
+	Iterations int
+}
+
+// NewDefaultDuelStrategy creates a DefaultDuelStrategy using the values from
+// a DefaultEvaluator.
+func NewDefaultDuelStrategy() *DefaultDuelStrategy {
+	return &DefaultDuelStrategy{
+		Evaluator:  NewDefaultEvaluator(),
+		Iterations: 2000,
+	}
+}
+
+// minDuelProbability is the smallest probability that will be reported in a
+// MixedDuel. Smaller probabilities are artifacts of the approximation.
+const minDuelProbability = 0.02
+
+// Bid implements DuelStrategy.
+func (s *DefaultDuelStrategy) Bid(game *Game, move Move, index int, role DuelRole) MixedDuel {
+	opportunities := game.DuelOpportunities(move)
+	if index >= len(opportunities) || !opportunities[index].IsDuelable() {
+		if role == DuelChallenger {
+			return PureDuel(Duel{})
+		}
+		return PureDuel(DuelWithResponse(move.Duels[index], 0, true))
+	}
+	o := opportunities[index]
+
+	// Find the bids that are available to each player.
+	var challenges, responses []int
+	for bid := 0; bid <= 2; bid++ {
+		candidate := move
+		candidate.Duels[index] = DuelWithChallenge(bid)
+		if game.ValidateDuels(candidate) == nil {
+			challenges = append(challenges, bid)
+		}
+	}
+	paid := 0
+	if o.AttackerPays() {
+		paid = 1
+	}
+	for bid := 0; bid <= 2 && bid+paid <= o.AttackerStones; bid++ {
+		responses = append(responses, bid)
+	}
+	if len(challenges) == 0 || len(responses) == 0 {
+		// The duel can't happen at all.
+		if role == DuelChallenger {
+			return PureDuel(Duel{})
+		}
+		return PureDuel(DuelWithResponse(move.Duels[index], 0, true))
+	}
+
+	payoff := s.payoffs(game, move, index, o, challenges, responses)
+	value, challengeMix, responseMix := solveZeroSum(payoff, s.Iterations)
+
+	if role == DuelChallenger {
+		if value <= 0 {
+			return PureDuel(Duel{})
+		}
+		var result MixedDuel
+		for i, p := range challengeMix {
+			result.Duels = append(result.Duels, DuelWithChallenge(challenges[i]))
+			result.Probabilities = append(result.Probabilities, p)
+		}
+		return result.prune()
+	}
+
+	// Calling a bluff either gains the attacker a stone or costs the defender
+	// one. Gaining is better unless the attacker is already at the limit.
+	gain := o.AttackerStones-paid < o.MaxStones
+	var result MixedDuel
+	for i, p := range responseMix {
+		result.Duels = append(result.Duels, DuelWithResponse(move.Duels[index], responses[i], gain))
+		result.Probabilities = append(result.Probabilities, p)
+	}
+	return result.prune()
+}
+
+// Computes the payoff to the challenger of each combination of bids.
+func (s *DefaultDuelStrategy) payoffs(game *Game, move Move, index int, o DuelOpportunity, challenges, responses []int) [][]float64 {
+	stoneValue := float64(s.Evaluator.StoneValue)
+	// If the attacker dies, the defender saves the attacker's value, plus
+	// anything else the move would have gone on to capture.
+	killValue := float64(s.Evaluator.PieceValue(o.Attacker))
+	rest := move
+	rest.Duels[index] = Duel{}
+	for _, later := range game.DuelOpportunities(rest)[index+1:] {
+		if later.Defender.Color() != o.Attacker.Color() {
+			killValue += float64(s.Evaluator.PieceValue(later.Defender))
+		}
+	}
+	if o.Attacker.Type() == TypePawn && o.DefenderStones < o.MaxStones {
+		// Killing a pawn in a duel earns a stone.
+		killValue += stoneValue
+	}
+	paid := 0.0
+	if o.AttackerPays() {
+		paid = stoneValue
+	}
+
+	payoff := make([][]float64, len(challenges))
+	for i, c := range challenges {
+		payoff[i] = make([]float64, len(responses))
+		for j, r := range responses {
+			value := paid + stoneValue*float64(r-c)
+			if c > r {
+				value += killValue
+			} else if c == 0 && r == 0 {
+				value -= stoneValue
+			}
+			payoff[i][j] = value
+		}
+	}
+	return payoff
+}
+
+// Removes insignificant probabilities and renormalizes.
+func (m MixedDuel) prune() MixedDuel {
+	var result MixedDuel
+	total := 0.0
+	for i, p := range m.Probabilities {
+		if p >= minDuelProbability {
+			result.Duels = append(result.Duels, m.Duels[i])
+			result.Probabilities = append(result.Probabilities, p)
+			total += p
+		}
+	}
+	if total == 0 {
+		return PureDuel(m.Duels[0])
+	}
+	for i := range result.Probabilities {
+		result.Probabilities[i] /= total
+	}
+	return result
+}
+
+// solveZeroSum approximates the equilibrium of the zero-sum game with the
+// given payoff matrix for the row player, using fictitious play. It returns
+// the value of the game to the row player and the mixed strategies of both
+// players.
+func solveZeroSum(payoff [][]float64, iterations int) (float64, []float64, []float64) {
+	rows, cols := len(payoff), len(payoff[0])
+	rowCounts := make([]float64, rows)
+	colCounts := make([]float64, cols)
+	// Cumulative payoff of each pure strategy against the opponent's history.
+	rowTotals := make([]float64, rows)
+	colTotals := make([]float64, cols)
+	if iterations < 1 {
+		iterations = 1
+	}
+	for n := 0; n < iterations; n++ {
+		best := 0
+		for i := range rowTotals {
+			if rowTotals[i] > rowTotals[best] {
+				best = i
+			}
+		}
+		rowCounts[best]++
+		for j := range colTotals {
+			colTotals[j] += payoff[best][j]
+		}
+		best = 0
+		for j := range colTotals {
+			if colTotals[j] < colTotals[best] {
+				best = j
+			}
+		}
+		colCounts[best]++
+		for i := range rowTotals {
+			rowTotals[i] += payoff[i][best]
+		}
+	}
+	value := 0.0
+	for i := range rowCounts {
+		rowCounts[i] /= float64(iterations)
+	}
+	for j := range colCounts {
+		colCounts[j] /= float64(iterations)
+	}
+	for i := range rowCounts {
+		for j := range colCounts {
+			value += rowCounts[i] * colCounts[j] * payoff[i][j]
+		}
+	}
+	return value, rowCounts, colCounts
+}
@@ -0,0 +1,135 @@
+package chess2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuelOpportunities(t *testing.T) {
+	game, err := ParseEpd("4k3/Rppp4/8/8/8/8/8/4K3 w - - 0 1 ac 23")
+	require.NoError(t, err)
+	move, err := ParseUci("a7d7")
+	require.NoError(t, err)
+	opportunities := game.DuelOpportunities(move)
+	require.Len(t, opportunities, 3)
+	for i, sq := range []string{"b7", "c7", "d7"} {
+		assert.Equal(t, sq, opportunities[i].Square.String())
+		assert.True(t, opportunities[i].IsDuelable())
+		assert.False(t, opportunities[i].AttackerPays())
+		assert.Equal(t, NewPiece(TypeRook, ArmyAnimals, ColorWhite), opportunities[i].Attacker)
+	}
+	// Capturing pawns gains stones as the rampage goes
+	assert.Equal(t, 2, opportunities[0].AttackerStones)
+	assert.Equal(t, 3, opportunities[1].AttackerStones)
+	assert.Equal(t, 3, opportunities[2].DefenderStones)
+	assert.Equal(t, DefaultMaxStones, opportunities[2].MaxStones)
+
+	// Losing the first duel ends the rampage
+	move, err = ParseUci("a7d7:21")
+	require.NoError(t, err)
+	opportunities = game.DuelOpportunities(move)
+	require.Len(t, opportunities, 1)
+
+	move, err = ParseUci("e1e2")
+	require.NoError(t, err)
+	assert.Empty(t, game.DuelOpportunities(move))
+}
+
+func TestDefaultDuelStrategy(t *testing.T) {
+	cases := map[string]struct {
+		epd       string
+		move      string
+		challenge []string
+	}{
+		"defender without stones": {
+			epd:       "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 30",
+			move:      "d4e5",
+			challenge: []string{""},
+		},
+		"attacker without stones": {
+			epd:       "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 03",
+			move:      "d4e5",
+			challenge: []string{"1"},
+		},
+		"capture by king": {
+			epd:       "4k3/8/8/8/8/8/4p3/4K3 w - - 0 1 cc 33",
+			move:      "e1e2",
+			challenge: []string{""},
+		},
+		"attacker cannot pay to duel": {
+			epd:       "4k3/8/8/4b3/3P4/8/8/4K3 w - - 0 1 cc 03",
+			move:      "d4e5",
+			challenge: []string{""},
+		},
+	}
+	strategy := NewDefaultDuelStrategy()
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			move, err := ParseUci(config.move)
+			require.NoError(t, err, "Move: %s  Name: %s", config.move, name)
+			mix := strategy.Bid(&game, move, 0, DuelChallenger)
+			challenges := make([]string, len(mix.Duels))
+			for i, d := range mix.Duels {
+				challenges[i] = d.String()
+			}
+			assert.Equal(t, config.challenge, challenges, "Case: %s", name)
+		})
+	}
+}
+
+func TestDefaultDuelStrategyMixed(t *testing.T) {
+	game, err := ParseEpd("4k3/8/8/4p3/3B4/8/8/4K3 w - - 0 1 cc 33")
+	require.NoError(t, err)
+	move, err := ParseUci("d4e5")
+	require.NoError(t, err)
+	strategy := NewDefaultDuelStrategy()
+	rng := rand.New(rand.NewSource(1))
+
+	challenge := strategy.Bid(&game, move, 0, DuelChallenger)
+	require.True(t, len(challenge.Duels) > 1, "Challenge: %v", challenge)
+	total := 0.0
+	for i, d := range challenge.Duels {
+		assert.True(t, d.IsStarted())
+		assert.False(t, d.IsComplete())
+		total += challenge.Probabilities[i]
+	}
+	assert.InDelta(t, 1.0, total, 1e-9)
+
+	move.Duels[0] = challenge.Sample(rng)
+	response := strategy.Bid(&game, move, 0, DuelResponder)
+	require.NotEmpty(t, response.Duels)
+	for _, d := range response.Duels {
+		assert.True(t, d.IsComplete())
+		assert.Equal(t, move.Duels[0].Challenge(), d.Challenge())
+	}
+	move.Duels[0] = response.Sample(rng)
+	assert.NoError(t, game.ValidateLegalMove(move))
+
+	// The response doesn't depend on the secret challenge.
+	other := move
+	other.Duels[0] = DuelWithChallenge(2 - move.Duels[0].Challenge())
+	otherResponse := strategy.Bid(&game, other, 0, DuelResponder)
+	assert.Equal(t, response.Probabilities, otherResponse.Probabilities)
+}
+
+func TestDefaultDuelStrategyStoneLimit(t *testing.T) {
+	strategy := NewDefaultDuelStrategy()
+	for _, stones := range []string{"33", "33/3"} {
+		game, err := ParseEpd("4k3/8/8/4p3/3B4/8/8/4K3 w - - 0 1 cc " + stones)
+		require.NoError(t, err)
+		move, err := ParseUci("d4e5:0")
+		require.NoError(t, err)
+		response := strategy.Bid(&game, move, 0, DuelResponder)
+		require.NotEmpty(t, response.Duels)
+		// Calling a bluff costs the defender a stone when the attacker can't
+		// hold any more.
+		for _, d := range response.Duels {
+			assert.Equal(t, stones == "33", d.Gain(), "Stones: %s", stones)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package chess2
 import (
 	"fmt"
 	"strings"
+	"unicode"
 )
 
 func toMoveSymbol(c Color, kingTurn bool) rune {
@@ -18,22 +19,52 @@ func toMoveSymbol(c Color, kingTurn bool) rune {
 	return 'b'
 }
 
+// Writes the castling rights. A right to castle with the rook in the corner is
+// written KQkq as usual, and otherwise as the file of the rook, uppercase for
+// white, like Shredder-FEN.
 func castlingRights(sb *strings.Builder, rights uint64) {
 	if rights == 0 {
 		sb.WriteRune('-')
 	}
-	if rights&castleWhiteKingside != 0 {
-		sb.WriteRune('K')
-	}
-	if rights&castleWhiteQueenside != 0 {
-		sb.WriteRune('Q')
+	for _, y := range []int{7, 0} {
+		for x := 7; x >= 0; x-- {
+			sq := SquareFromCoords(x, y)
+			if rights&sq.mask() == 0 {
+				continue
+			}
+			code := rune('a' + x)
+			switch x {
+			case 0:
+				code = 'q'
+			case 7:
+				code = 'k'
+			}
+			if y == 7 {
+				code = unicode.ToUpper(code)
+			}
+			sb.WriteRune(code)
+		}
 	}
-	if rights&castleBlackKingside != 0 {
-		sb.WriteRune('k')
+}
+
+// Parses castling rights in the format written by castlingRights.
+func parseCastlingRights(str string) (uint64, error) {
+	var rights uint64
+	if str == "-" {
+		return 0, nil
 	}
-	if rights&castleBlackQueenside != 0 {
-		sb.WriteRune('q')
+	for _, code := range str {
+		if mask, found := castlingCodes[code]; found {
+			rights |= mask
+		} else if code >= 'A' && code <= 'H' {
+			rights |= SquareFromCoords(int(code-'A'), 7).mask()
+		} else if code >= 'a' && code <= 'h' {
+			rights |= SquareFromCoords(int(code-'a'), 0).mask()
+		} else {
+			return 0, ParseError("EPD has invalid castling rights")
+		}
 	}
+	return rights, nil
 }
 
 var (
@@ -70,10 +101,76 @@ func FindArmySymbol(symbol rune) (Army, bool) {
 	return army, found
 }
 
-// EncodeEpd returns the EPD of the given game object
+// Writes the reserves of both players, in the same format as the pieces in a
+// FEN, with white's pieces first.
+func encodeReserves(sb *strings.Builder, reserves [2][6]int) {
+	for colorIdx, color := range []Color{ColorWhite, ColorBlack} {
+		for _, t := range []PieceType{TypeQueen, TypeRook, TypeBishop, TypeKnight, TypePawn} {
+			for i := 0; i < reserves[colorIdx][pieceTypeIdx(t)]; i++ {
+				sb.WriteRune(EncodeFenPiece(NewPiece(t, ArmyNone, color)))
+			}
+		}
+	}
+}
+
+// Parses reserves in the format written by encodeReserves, in any order.
+func parseReserves(str string) ([2][6]int, error) {
+	var reserves [2][6]int
+	for _, code := range str {
+		piece, err := ParseFenPiece(code)
+		if err != nil || piece.Type() == TypeKing {
+			return reserves, ParseError("EPD has invalid reserve")
+		}
+		reserves[ColorIdx(piece.Color())][pieceTypeIdx(piece.Type())]++
+	}
+	return reserves, nil
+}
+
+// encodeStones writes the stones of each player, followed by the limit on
+// stones when it isn't DefaultMaxStones, like "12/9".
+func encodeStones(sb *strings.Builder, stones [2]int, max int) {
+	fmt.Fprintf(sb, "%d%d", stones[0], stones[1])
+	if max != DefaultMaxStones {
+		fmt.Fprintf(sb, "/%d", max)
+	}
+}
+
+// ParseStones parses the stones of each player and the limit on stones, as
+// written in an EPD like "33" or "12/9". The limit is DefaultMaxStones unless
+// it is given.
+func ParseStones(str string) ([2]int, int, error) {
+	var stones [2]int
+	max := DefaultMaxStones
+	if idx := strings.IndexRune(str, '/'); idx != -1 {
+		if len(str) != idx+2 || str[idx+1] < '0' || str[idx+1] > '0'+MaxStonesLimit {
+			return stones, 0, ParseError("EPD has invalid stones")
+		}
+		max = int(str[idx+1] - '0')
+		str = str[:idx]
+	}
+	if len(str) != 2 {
+		return stones, 0, ParseError("EPD has invalid stones")
+	}
+	for i := range stones {
+		if str[i] < '0' || int(str[i]-'0') > max {
+			return stones, 0, ParseError("EPD has invalid stones")
+		}
+		stones[i] = int(str[i] - '0')
+	}
+	return stones, max, nil
+}
+
+// EncodeEpd returns the EPD of the given game object. With GameFlagDrops, the
+// reserves follow the board in brackets, like "[QRbpp]". Games with a limit on
+// stones other than DefaultMaxStones write it after the stones, like "12/9".
 func EncodeEpd(game Game) string {
 	var sb strings.Builder
 	sb.WriteString(EncodeFen(game.board))
+	if game.flags&GameFlagDrops != 0 {
+		sb.WriteRune('[')
+		encodeReserves(&sb, game.reserves)
+		sb.WriteRune(']')
+	}
 	sb.WriteRune(' ')
 	sb.WriteRune(toMoveSymbol(game.toMove, game.kingTurn))
 	sb.WriteRune(' ')
@@ -85,14 +182,13 @@ func EncodeEpd(game Game) string {
 		sb.WriteRune('-')
 	}
 	sb.WriteString(fmt.Sprintf(
-		" %d %d %c%c %d%d",
+		" %d %d %c%c ",
 		game.halfmoveClock,
 		game.fullmoveNumber+1,
 		armyToSymbol[game.armies[0]],
 		armyToSymbol[game.armies[1]],
-		game.stones[0],
-		game.stones[1],
 	))
+	encodeStones(&sb, game.stones, game.maxStones)
 	return sb.String()
 }
 
@@ -112,24 +208,37 @@ func ParseEpdClassic(epd string) (Game, error) {
 }
 
 // ParseEpdFlags parses and EPD string and returns a game object. The flags on
-// the game can be adjusted.
+// the game can be adjusted. If the EPD has reserves, GameFlagDrops is added to
+// the flags.
 func ParseEpdFlags(epd string, flags GameFlags) (Game, error) {
 	game := Game{flags: flags}
 	// Split the EPD into components
-	var fenStr, castleStr, epStr string
+	var fenStr, castleStr, epStr, stonesStr string
 	var toMoveRune rune
-	var armyRunes, stoneRunes [2]rune
+	var armyRunes [2]rune
 	// EPD is: fen tomove castle epsquare hc fm armies stones operations
 	num, err := fmt.Sscanf(
-		epd, "%s %c %s %s %d %d %c%c %c%c",
+		epd, "%s %c %s %s %d %d %c%c %s",
 		&fenStr, &toMoveRune, &castleStr, &epStr,
 		&game.halfmoveClock, &game.fullmoveNumber,
 		&armyRunes[0], &armyRunes[1],
-		&stoneRunes[0], &stoneRunes[1],
+		&stonesStr,
 	)
-	if err != nil || num != 10 {
+	if err != nil || num != 9 {
 		return Game{}, ParseError("EPD invalid")
 	}
+	if start := strings.IndexRune(fenStr, '['); start != -1 {
+		if !strings.HasSuffix(fenStr, "]") {
+			return Game{}, ParseError("EPD has invalid reserve")
+		}
+		reserves, err := parseReserves(fenStr[start+1 : len(fenStr)-1])
+		if err != nil {
+			return Game{}, err
+		}
+		game.reserves = reserves
+		game.flags |= GameFlagDrops
+		fenStr = fenStr[:start]
+	}
 	board, err := ParseFen(fenStr)
 	if err != nil {
 		return Game{}, err
@@ -151,10 +260,8 @@ func ParseEpdFlags(epd string, flags GameFlags) (Game, error) {
 		return Game{}, ParseError("EPD has invalid to-move")
 	}
 
-	for symbol, value := range castlingCodes {
-		if strings.IndexRune(castleStr, symbol) != -1 {
-			game.castlingRights |= value
-		}
+	if game.castlingRights, err = parseCastlingRights(castleStr); err != nil {
+		return Game{}, err
 	}
 
 	if epStr == "-" {
@@ -177,19 +284,17 @@ func ParseEpdFlags(epd string, flags GameFlags) (Game, error) {
 		game.armies[i] = army
 	}
 
-	for i, stoneRune := range stoneRunes {
-		if stoneRune < '0' || stoneRune > '6' {
-			return Game{}, ParseError("EPD has invalid stones")
-		}
-		game.stones[i] = int(stoneRune - '0')
+	if game.stones, game.maxStones, err = ParseStones(stonesStr); err != nil {
+		return Game{}, err
 	}
 
 	// Finally, some sanity checking
 	if game.kingTurn {
-		if game.armies[ColorIdx(game.toMove)] != ArmyTwoKings {
-			return Game{}, ParseError("King turn for army other than two kings")
+		if !game.armyRules(ColorIdx(game.toMove)).kingTurn {
+			return Game{}, ParseError("King turn for army without king-turns")
 		}
 	}
+	game.hash = game.stateHash()
 	game.updateGameState()
 
 	return game, nil
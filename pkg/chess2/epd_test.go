@@ -12,6 +12,19 @@ func TestEncodeEpd(t *testing.T) {
 	require.Equal(t, expected, epd)
 }
 
+func TestParseEpdReserves(t *testing.T) {
+	epd := "4k3/8/8/8/8/8/8/4K3[QPPbn] w - - 0 1 cc 33"
+	game, err := ParseEpd("4k3/8/8/8/8/8/8/4K3[nPbQP] w - - 0 1 cc 33")
+	require.NoError(t, err)
+	require.Equal(t, epd, EncodeEpd(game))
+	require.Equal(t, 2, game.Reserve(ColorWhite, TypePawn))
+	require.Equal(t, 1, game.Reserve(ColorBlack, TypeKnight))
+	require.Equal(t, game.computeHash(), game.Hash())
+
+	_, err = ParseEpd("4k3/8/8/8/8/8/8/4K3[K] w - - 0 1 cc 33")
+	require.Error(t, err)
+}
+
 func TestParseEpd(t *testing.T) {
 	epd := "rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2 cc 33"
 	game, err := ParseEpd(epd)
@@ -19,3 +32,111 @@ func TestParseEpd(t *testing.T) {
 	result := EncodeEpd(game)
 	require.Equal(t, epd, result)
 }
+
+func TestParseEpdCastlingRights(t *testing.T) {
+	cases := map[string]struct {
+		epd      string
+		expected string
+		err      bool
+	}{
+		"corners": {
+			epd: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1 cc 33",
+		},
+		"rook files": {
+			epd: "1r3kr1/8/8/8/8/8/8/1R3KR1 w GBgb - 0 1 cc 33",
+		},
+		"rook files for corners": {
+			epd:      "r3k2r/8/8/8/8/8/8/R3K2R w HAha - 0 1 cc 33",
+			expected: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1 cc 33",
+		},
+		"mixed": {
+			epd: "r4kr1/8/8/8/8/8/8/1R3K1R w KBgq - 0 1 cc 33",
+		},
+		"invalid": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w KX - 0 1 cc 33",
+			err: true,
+		},
+	}
+	for name, config := range cases {
+		game, err := ParseEpd(config.epd)
+		if config.err {
+			require.Error(t, err, "Case: %s", name)
+			continue
+		}
+		require.NoError(t, err, "Case: %s", name)
+		expected := config.expected
+		if expected == "" {
+			expected = config.epd
+		}
+		require.Equal(t, expected, EncodeEpd(game), "Case: %s", name)
+	}
+}
+
+func TestParseEpdStones(t *testing.T) {
+	cases := map[string]struct {
+		epd      string
+		expected string
+		stones   [2]int
+		max      int
+		err      bool
+	}{
+		"default limit": {
+			epd:    "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 60",
+			stones: [2]int{6, 0},
+			max:    6,
+		},
+		"handicap": {
+			epd:    "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 15",
+			stones: [2]int{1, 5},
+			max:    6,
+		},
+		"raised limit": {
+			epd:    "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 12/9",
+			stones: [2]int{1, 2},
+			max:    9,
+		},
+		"default limit written out": {
+			epd:      "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33/6",
+			expected: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+			stones:   [2]int{3, 3},
+			max:      6,
+		},
+		"no stones": {
+			epd:    "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 00/0",
+			stones: [2]int{0, 0},
+			max:    0,
+		},
+		"over default limit": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 73",
+			err: true,
+		},
+		"over limit": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 34/3",
+			err: true,
+		},
+		"invalid limit": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33/x",
+			err: true,
+		},
+		"one player": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 3",
+			err: true,
+		},
+	}
+	for name, config := range cases {
+		game, err := ParseEpd(config.epd)
+		if config.err {
+			require.Error(t, err, "Case: %s", name)
+			continue
+		}
+		require.NoError(t, err, "Case: %s", name)
+		expected := config.expected
+		if expected == "" {
+			expected = config.epd
+		}
+		require.Equal(t, expected, EncodeEpd(game), "Case: %s", name)
+		require.Equal(t, config.stones, [2]int{game.Stones(ColorWhite), game.Stones(ColorBlack)}, "Case: %s", name)
+		require.Equal(t, config.max, game.MaxStones(), "Case: %s", name)
+		require.Equal(t, game.computeHash(), game.Hash(), "Case: %s", name)
+	}
+}
@@ -7,7 +7,8 @@ type IllegalMoveError int
 const (
 	// GameOverError is any move on a completed game.
 	GameOverError = IllegalMoveError(iota + 1)
-	// IllegalDropError is any drop move.
+	// IllegalDropError is a drop without GameFlagDrops, of a piece which is
+	// not in the player's reserve, or onto an occupied square.
 	IllegalDropError
 	// IllegalPassError is a pass outside of a king-turn.
 	IllegalPassError
@@ -42,6 +43,17 @@ const (
 	NotDuelableError
 	// MoveIntoCheckError is a move that results in a king being in check.
 	MoveIntoCheckError
+	// DuelOutOfTurnError is a challenge or response made when the pending move
+	// is waiting for a different decision.
+	DuelOutOfTurnError
+	// ActionOutOfTurnError is a resignation, draw offer, or draw claim by the
+	// player who is not to move, or an answer to a player's own draw offer.
+	ActionOutOfTurnError
+	// NoDrawOfferError is an answer to a draw offer when none was made.
+	NoDrawOfferError
+	// NoDrawToClaimError is a claim of a draw when neither the fifty move rule
+	// nor threefold repetition applies.
+	NoDrawToClaimError
 )
 
 func (code IllegalMoveError) Error() string {
@@ -76,6 +88,14 @@ func (code IllegalMoveError) Error() string {
 		return "cannot duel with kings"
 	case MoveIntoCheckError:
 		return "moving into check"
+	case DuelOutOfTurnError:
+		return "duel decision out of turn"
+	case ActionOutOfTurnError:
+		return "action out of turn"
+	case NoDrawOfferError:
+		return "no draw has been offered"
+	case NoDrawToClaimError:
+		return "no draw to claim"
 	default:
 		panic("invalid error code")
 	}
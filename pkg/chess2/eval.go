@@ -0,0 +1,262 @@
+package chess2
+
+import (
+	"math/bits"
+)
+
+// An Evaluator assigns a static score to a position. Evaluators are used by
+// Search to judge the positions at the leaves of the search tree.
+type Evaluator interface {
+	// Evaluate returns the value of the game from the perspective of the
+	// player to move, in centipawns. Positive values are good for the player to
+	// move. The game is always in progress when Evaluate is called by Search.
+	Evaluate(game *Game) int
+}
+
+// An EvalTerm is one component of an evaluation, with the contributions from
+// each player listed separately.
+type EvalTerm struct {
+	Name  string
+	White int
+	Black int
+}
+
+// Value returns the contribution of the term from white's perspective.
+func (t EvalTerm) Value() int {
+	return t.White - t.Black
+}
+
+var (
+	// defaultPieceValues are the values of each piece, in centipawns. Pieces
+	// not listed use the value of the basic piece of the same type.
+	defaultPieceValues = map[PieceName]int{
+		PieceNameBasicKing:   0,
+		PieceNameBasicQueen:  900,
+		PieceNameBasicBishop: 320,
+		PieceNameBasicKnight: 300,
+		PieceNameBasicRook:   500,
+		PieceNameBasicPawn:   100,
+		// The Nemesis can't be captured and walks through the enemy lines, but
+		// can only capture kings.
+		PieceNameNemesisQueen: 700,
+		// Nemesis pawns can always step towards the enemy king.
+		PieceNameNemesisPawn: 110,
+		// The Abdicated Queen only moves like a king, but the empowered
+		// pieces borrow each other's movement.
+		PieceNameEmpoweredQueen:  250,
+		PieceNameEmpoweredBishop: 400,
+		PieceNameEmpoweredKnight: 380,
+		PieceNameEmpoweredRook:   560,
+		// The Reaper can capture almost anywhere on the board; the Ghost can't
+		// capture but also can't be captured.
+		PieceNameReaperQueen:  850,
+		PieceNameReaperRook:   250,
+		PieceNameTwoKingsKing: 0,
+		// The Jungle Queen moves like a rook and a knight, the Tiger captures
+		// without moving, the Wild Horse can capture its own pieces, and the
+		// Elephant rampages through everything in its way.
+		PieceNameAnimalsQueen:  850,
+		PieceNameAnimalsBishop: 350,
+		PieceNameAnimalsKnight: 300,
+		PieceNameAnimalsRook:   550,
+	}
+	// startingPhase is the phase of a game with all pieces other than pawns on
+	// the board. Knights and bishops count 1, rooks 2 and queens 4.
+	startingPhase = 24
+)
+
+// DefaultEvaluator is a hand-tuned Evaluator that understands the Chess 2
+// rules. It considers material, using the value of each army's pieces and
+// counting the pieces in each reserve; the stones held by each player; how far
+// each player's kings are from crossing the midline; mobility; and the
+// advancement of pawns.
+type DefaultEvaluator struct {
+	// StoneValue is the value of each stone held.
+	StoneValue int
+	// MidlineValue is the penalty for each rank a king is away from crossing
+	// the midline, in the endgame. In the opening the penalty is reduced.
+	MidlineValue int
+	// MobilityValue is the value of each square attacked by a player's
+	// pieces.
+	MobilityValue int
+	// PawnAdvanceValue is the value of each rank a pawn has advanced.
+	PawnAdvanceValue int
+
+	// pieceValues are the values of the pieces of the built-in armies,
+	// indexed by army and piece type. The basic pieces use ArmyNone.
+	pieceValues [8][6]int
+	// customValues are the values set for pieces of custom armies.
+	customValues map[PieceName]int
+}
+
+// NewDefaultEvaluator creates a DefaultEvaluator with the default weights.
+func NewDefaultEvaluator() *DefaultEvaluator {
+	e := &DefaultEvaluator{
+		StoneValue:       40,
+		MidlineValue:     30,
+		MobilityValue:    4,
+		PawnAdvanceValue: 6,
+	}
+	for name, value := range defaultPieceValues {
+		e.SetPieceValue(name, value)
+	}
+	return e
+}
+
+// SetPieceValue changes the value of a piece. Setting the value of a basic
+// piece doesn't change the value of special pieces of the same type.
+func (e *DefaultEvaluator) SetPieceValue(name PieceName, value int) {
+	if armyIdx, typeIdx, found := pieceValueIdx(name); found {
+		e.pieceValues[armyIdx][typeIdx] = value
+		return
+	}
+	if e.customValues == nil {
+		e.customValues = make(map[PieceName]int)
+	}
+	e.customValues[name] = value
+}
+
+// PieceValue returns the value of the given piece. Pieces of custom armies
+// use the value from their PieceDefinition unless SetPieceValue was called for
+// them.
+func (e *DefaultEvaluator) PieceValue(p Piece) int {
+	name := p.Name()
+	if armyIdx, typeIdx, found := pieceValueIdx(name); found {
+		return e.pieceValues[armyIdx][typeIdx]
+	} else if value, found := e.customValues[name]; found {
+		return value
+	} else if value := p.rules().value; value != 0 {
+		return value
+	}
+	return e.pieceValues[0][pieceTypeIdx(p.Type())]
+}
+
+// pieceValueIdx returns the indexes of the named piece in pieceValues, if it
+// belongs to a built-in army.
+func pieceValueIdx(name PieceName) (int, int, bool) {
+	army := Army(int(name) & armyMask)
+	t := PieceType(int(name) & typeMask)
+	if army >= 0x100 || t < TypeKing || t > TypePawn {
+		return 0, 0, false
+	}
+	return int(army >> 4), pieceTypeIdx(t), true
+}
+
+// Evaluate implements Evaluator.
+func (e *DefaultEvaluator) Evaluate(game *Game) int {
+	var terms evalTerms
+	e.evaluate(game, &terms)
+	var score int
+	for _, term := range terms {
+		score += term.Value()
+	}
+	if game.toMove == ColorBlack {
+		return -score
+	}
+	return score
+}
+
+// Breakdown returns the terms which make up the evaluation of the given game.
+// The score returned by Evaluate is the sum of the values of the terms, from
+// the perspective of the player to move.
+func (e *DefaultEvaluator) Breakdown(game *Game) []EvalTerm {
+	var terms evalTerms
+	e.evaluate(game, &terms)
+	return terms[:]
+}
+
+// The terms computed by DefaultEvaluator.
+const (
+	evalMaterial = iota
+	evalStones
+	evalMidline
+	evalMobility
+	evalPawns
+	evalTermCount
+)
+
+var evalTermNames = [evalTermCount]string{
+	"material", "stones", "midline", "mobility", "pawns",
+}
+
+// evalTerms holds each term computed by DefaultEvaluator.
+type evalTerms [evalTermCount]EvalTerm
+
+// maxMobility is the most squares that count towards the mobility of a single
+// piece. Without it, pieces that can reach the entire board would dominate
+// the mobility term.
+const maxMobility = 14
+
+func (e *DefaultEvaluator) evaluate(game *Game, terms *evalTerms) {
+	phase := 0
+	for _, t := range []PieceType{TypeQueen, TypeRook, TypeBishop, TypeKnight} {
+		weight := 1
+		if t == TypeQueen {
+			weight = 4
+		} else if t == TypeRook {
+			weight = 2
+		}
+		phase += weight * bits.OnesCount64(game.board.pieceMask(t))
+	}
+	if phase > startingPhase {
+		phase = startingPhase
+	}
+	for colorIdx, color := range []Color{ColorWhite, ColorBlack} {
+		var values [evalTermCount]int
+		colorPieces := game.board.colorMask(color)
+		army := game.armies[colorIdx]
+		eachSquareInMask(colorPieces, func(sq Square) {
+			p, _ := game.board.PieceAt(sq)
+			values[evalMaterial] += e.PieceValue(p.WithArmy(army))
+			mobility := bits.OnesCount64(game.attackMask(sq) &^ colorPieces)
+			if mobility > maxMobility {
+				mobility = maxMobility
+			}
+			values[evalMobility] += mobility * e.MobilityValue
+		})
+
+		for idx, count := range game.reserves[colorIdx] {
+			if t := idxPieceType(idx); count > 0 && game.canDrop(colorIdx, t) {
+				piece := NewPiece(t, army, color)
+				values[evalMaterial] += count * e.PieceValue(piece)
+			}
+		}
+
+		values[evalStones] = e.StoneValue * game.stones[colorIdx]
+
+		// Kings have to cross rank 4 (for white) or rank 5 (for black). The
+		// closer the game is to the endgame, the more this matters.
+		if game.flags&GameFlagMidline != 0 {
+			kings := colorPieces & game.board.pieceMask(TypeKing)
+			weight := e.MidlineValue * (2*startingPhase - phase)
+			scale := 2 * startingPhase
+			eachSquareInMask(kings, func(sq Square) {
+				distance := sq.Y() - 3
+				if color == ColorBlack {
+					distance = 4 - sq.Y()
+				}
+				if distance > 0 {
+					values[evalMidline] -= distance * weight / scale
+				}
+			})
+		}
+
+		pawns := colorPieces & game.board.pieceMask(TypePawn)
+		eachSquareInMask(pawns, func(sq Square) {
+			advance := 6 - sq.Y()
+			if color == ColorBlack {
+				advance = sq.Y() - 1
+			}
+			values[evalPawns] += advance * e.PawnAdvanceValue
+		})
+
+		for i, value := range values {
+			terms[i].Name = evalTermNames[i]
+			if color == ColorWhite {
+				terms[i].White = value
+			} else {
+				terms[i].Black = value
+			}
+		}
+	}
+}
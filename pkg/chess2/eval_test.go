@@ -0,0 +1,77 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateSymmetry(t *testing.T) {
+	e := NewDefaultEvaluator()
+	for _, army := range []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals} {
+		game := GameFromArmies(army, army)
+		assert.Equal(t, 0, e.Evaluate(&game), "Army: %v", army)
+	}
+	white, err := ParseEpd("4k3/8/8/8/8/8/3P4/4K3 w - - 0 1 cc 33")
+	require.NoError(t, err)
+	black, err := ParseEpd("4k3/3p4/8/8/8/8/8/4K3 b - - 0 1 cc 33")
+	require.NoError(t, err)
+	assert.Equal(t, e.Evaluate(&white), e.Evaluate(&black))
+	assert.True(t, e.Evaluate(&white) > 0)
+}
+
+func TestEvaluateBreakdown(t *testing.T) {
+	e := NewDefaultEvaluator()
+	game, err := ParseEpd("r3k3/8/8/8/8/8/3P4/4K3 b - - 0 1 ca 52")
+	require.NoError(t, err)
+	terms := e.Breakdown(&game)
+	total := 0
+	names := make([]string, len(terms))
+	for i, term := range terms {
+		total += term.Value()
+		names[i] = term.Name
+	}
+	assert.Equal(t, []string{"material", "stones", "midline", "mobility", "pawns"}, names)
+	assert.Equal(t, -total, e.Evaluate(&game))
+	assert.Equal(t, EvalTerm{Name: "stones", White: 5 * e.StoneValue, Black: 2 * e.StoneValue}, terms[1])
+	elephant := NewPiece(TypeRook, ArmyAnimals, ColorBlack)
+	assert.Equal(t, EvalTerm{Name: "material", White: 100, Black: e.PieceValue(elephant)}, terms[0])
+}
+
+func TestEvaluateMidline(t *testing.T) {
+	e := NewDefaultEvaluator()
+	epds := []string{
+		"4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+		"4k3/8/8/8/8/8/4K3/8 w - - 0 1 cc 33",
+		"4k3/8/8/8/8/4K3/8/8 w - - 0 1 cc 33",
+		"4k3/8/8/8/4K3/8/8/8 w - - 0 1 cc 33",
+	}
+	last := 0
+	for i, epd := range epds {
+		game, err := ParseEpd(epd)
+		require.NoError(t, err, "EPD: %s", epd)
+		midline := e.Breakdown(&game)[2].Value()
+		if i > 0 {
+			assert.True(t, midline > last, "EPD: %s", epd)
+		}
+		last = midline
+	}
+
+	// Classic chess has no midline victory.
+	game, err := ParseEpdFlags("4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33", VariantClassic)
+	require.NoError(t, err)
+	assert.Equal(t, EvalTerm{Name: "midline"}, e.Breakdown(&game)[2])
+}
+
+func TestEvaluateArmyPieces(t *testing.T) {
+	e := NewDefaultEvaluator()
+	ghost := NewPiece(TypeRook, ArmyReaper, ColorWhite)
+	rook := NewPiece(TypeRook, ArmyClassic, ColorWhite)
+	assert.NotEqual(t, e.PieceValue(ghost), e.PieceValue(rook))
+	e.SetPieceValue(PieceNameReaperRook, 123)
+	assert.Equal(t, 123, e.PieceValue(ghost))
+	// Two Kings' second king is not material
+	game := GameFromArmies(ArmyTwoKings, ArmyClassic)
+	assert.Equal(t, -e.PieceValue(NewPiece(TypeQueen, ArmyClassic, ColorBlack)), e.Breakdown(&game)[0].Value())
+}
@@ -18,6 +18,61 @@ const (
 	GameOverDraw
 )
 
+// EndReason describes why a game ended.
+type EndReason int
+
+const (
+	// EndReasonNone means the game is not yet finished.
+	EndReasonNone = EndReason(iota)
+	// EndReasonCheckmate means the player to move had no legal moves while in
+	// check.
+	EndReasonCheckmate
+	// EndReasonStalemate means the player to move had no legal moves while not
+	// in check. This is a loss unless GameFlagStalemate is set.
+	EndReasonStalemate
+	// EndReasonMidline means a player moved all of their kings past the
+	// midline.
+	EndReasonMidline
+	// EndReasonFiftyMove means a draw by the fifty move rule.
+	EndReasonFiftyMove
+	// EndReasonRepetition means a draw by threefold repetition. Only a
+	// GameRecord can detect this.
+	EndReasonRepetition
+	// EndReasonTimeout means a player ran out of time. Only a GameRecord can
+	// end a game this way, see GameRecord.Timeout.
+	EndReasonTimeout
+	// EndReasonResignation means a player resigned, see GameRecord.Resign.
+	EndReasonResignation
+	// EndReasonAgreement means the players agreed to a draw, see
+	// GameRecord.OfferDraw.
+	EndReasonAgreement
+)
+
+var endReasonNames = map[EndReason]string{
+	EndReasonNone:        "none",
+	EndReasonCheckmate:   "checkmate",
+	EndReasonStalemate:   "stalemate",
+	EndReasonMidline:     "midline",
+	EndReasonFiftyMove:   "fifty_move",
+	EndReasonRepetition:  "repetition",
+	EndReasonTimeout:     "timeout",
+	EndReasonResignation: "resignation",
+	EndReasonAgreement:   "agreement",
+}
+
+func (r EndReason) String() string {
+	if name, found := endReasonNames[r]; found {
+		return name
+	}
+	return "invalid"
+}
+
+// A GameResult is the state of a game along with the reason that it ended.
+type GameResult struct {
+	State  GameState
+	Reason EndReason
+}
+
 // GameFlags can be used to change the rules of the game.
 type GameFlags uint
 
@@ -27,13 +82,23 @@ const (
 	// GameFlagStalemate causes stalemates to be treated as a draw instead of a
 	// loss.
 	GameFlagStalemate
+	// GameFlagRepetition causes a GameRecord to end the game in a draw when a
+	// position occurs for the third time.
+	GameFlagRepetition
+	// GameFlagDrops gives each player a reserve of pieces, which they can drop
+	// onto any empty square instead of moving. Pieces captured from the
+	// opponent, and attackers destroyed by winning a duel, join the reserve.
+	GameFlagDrops
 )
 
+// Both variants include GameFlagRepetition, so games played through a
+// GameRecord end in a draw on threefold repetition. Remove the flag to only
+// allow the draw to be claimed, see GameRecord.ClaimDraw.
 const (
 	// VariantChess2 is the default flag configuration for a Chess2 game.
-	VariantChess2 = GameFlagMidline
+	VariantChess2 = GameFlagMidline | GameFlagRepetition
 	// VariantClassic is the flag configuration for a game of classic Chess.
-	VariantClassic = GameFlagStalemate
+	VariantClassic = GameFlagStalemate | GameFlagRepetition
 )
 
 var (
@@ -41,9 +106,6 @@ var (
 	castleWhiteQueenside = SquareFromName("A1").mask()
 	castleBlackKingside  = SquareFromName("H8").mask()
 	castleBlackQueenside = SquareFromName("A8").mask()
-	castleKingside       = castleWhiteKingside | castleBlackKingside
-	castleQueenside      = castleWhiteQueenside | castleBlackQueenside
-	castles              = []uint64{castleWhiteKingside, castleWhiteQueenside, castleBlackKingside, castleBlackQueenside}
 )
 
 // buildSlidingAttackMask creates a bitmask for each of 64 squares of the
@@ -130,10 +192,6 @@ var (
 	diagMask, diagAttackMask = buildAttackTable([]int{-9, -7, 7, 9})
 	orthMask, orthAttackMask = buildAttackTable([]int{-8, -1, 1, 8})
 
-	// Masks describing the files that have to be empty for a castle.
-	queensideMask = uint64(0x0e0e0e0e0e0e0e0e)
-	kingsideMask  = uint64(0x6060606060606060)
-
 	// Masks describing the area past the midline for each color
 	whiteMidline = uint64(0x00000000ffffffff)
 	blackMidline = uint64(0xffffffff00000000)
@@ -193,6 +251,11 @@ type moveExecution struct {
 	duels          []Duel
 	dryRun         bool
 	err            error
+	// If not nil, every capture is recorded here.
+	captures *[]DuelOpportunity
+	// If not nil, the captured pieces are recorded here so that the move can
+	// be unmade.
+	undo *Undo
 }
 
 // A Game fully describes a Chess 2 game.
@@ -202,34 +265,39 @@ type Game struct {
 	castlingRights uint64
 	armies         [2]Army
 	stones         [2]int
-	toMove         Color
-	kingTurn       bool
-	gameState      GameState
+	// maxStones is the most stones that a player can hold.
+	maxStones int
+	// reserves is the number of pieces of each type that each player can
+	// drop, indexed like Board.pieces. Only used with GameFlagDrops.
+	reserves  [2][6]int
+	toMove    Color
+	kingTurn  bool
+	gameState GameState
+	endReason EndReason
+	// gameStateStale is set when gameState needs to be recomputed, see
+	// MakeMove.
+	gameStateStale bool
 	halfmoveClock  int
 	fullmoveNumber int
 	epSquare       Square
+	// Zobrist key of everything except the board, see Hash.
+	hash uint64
 }
 
-// GameFromArmies initializes a new Game with the provided armies.
+const (
+	// DefaultStones is the number of stones each player starts with.
+	DefaultStones = 3
+	// DefaultMaxStones is the most stones a player can hold, unless the
+	// game is set up with a different limit.
+	DefaultMaxStones = 6
+	// MaxStonesLimit is the highest limit that a game can be set up with.
+	MaxStonesLimit = 9
+)
+
+// GameFromArmies initializes a new Game with the provided armies. Each army
+// starts with the back rank from its ArmyDefinition.
 func GameFromArmies(white, black Army) Game {
-	board, err := ParseFen(FenDefault)
-	if err != nil {
-		panic(err)
-	}
-	if white == ArmyTwoKings {
-		board.ReplacePieces(ColorWhite, TypeQueen, TypeKing)
-	}
-	if black == ArmyTwoKings {
-		board.ReplacePieces(ColorBlack, TypeQueen, TypeKing)
-	}
-	return Game{
-		flags:          VariantChess2,
-		board:          board,
-		castlingRights: castleKingside | castleQueenside,
-		armies:         [2]Army{white, black},
-		stones:         [2]int{3, 3},
-		epSquare:       InvalidSquare,
-	}
+	return startingGame(white, black, standardFiles)
 }
 
 // ToMove returns the color who should make the next move.
@@ -239,9 +307,74 @@ func (g *Game) ToMove() Color {
 
 // GameState returns the current state of the game.
 func (g *Game) GameState() GameState {
+	if g.gameStateStale {
+		g.updateGameState()
+	}
 	return g.gameState
 }
 
+// Result returns the current state of the game and, if it is over, the reason
+// that it ended.
+func (g *Game) Result() GameResult {
+	state := g.GameState()
+	return GameResult{State: state, Reason: g.endReason}
+}
+
+// Hash returns a 64-bit Zobrist key for the current position. The key covers
+// the pieces on the board, the player to move, king-turns, castling rights,
+// the en passant square, the armies, and the stones and reserves of each
+// player. The move clocks are not included. Two games with the same position
+// have the same key.
+func (g *Game) Hash() uint64 {
+	return g.board.hash ^ g.hash
+}
+
+// Stones returns the number of stones held by the given player.
+func (g *Game) Stones(color Color) int {
+	return g.stones[ColorIdx(color)]
+}
+
+// MaxStones returns the most stones that a player can hold. Stones which
+// would be gained past the limit are lost.
+func (g *Game) MaxStones() int {
+	return g.maxStones
+}
+
+// SetStones changes the stones held by each player and the most stones that a
+// player can hold, which is at most MaxStonesLimit. It is meant for setting up
+// handicap games and other variations before the game starts.
+func (g *Game) SetStones(white, black, max int) error {
+	if max < 0 || max > MaxStonesLimit || white < 0 || white > max || black < 0 || black > max {
+		return ParseError("Invalid stones")
+	}
+	g.setStones(0, white)
+	g.setStones(1, black)
+	g.hash ^= zobristMaxStoneCount(g.maxStones) ^ zobristMaxStoneCount(max)
+	g.maxStones = max
+	return nil
+}
+
+// Army returns the army of the given player.
+func (g *Game) Army(color Color) Army {
+	return g.armies[ColorIdx(color)]
+}
+
+// Reserve returns the number of pieces of the given type in the reserve of the
+// given player. Reserves are always empty without GameFlagDrops.
+func (g *Game) Reserve(color Color, t PieceType) int {
+	if t < TypeKing || t > TypePawn {
+		return 0
+	}
+	return g.reserves[ColorIdx(color)][pieceTypeIdx(t)]
+}
+
+// Returns true if the given player may drop pieces of the given type. Kings
+// are never dropped, and other pieces can only be dropped if pawns can promote
+// to them, so the Two Kings can't drop queens.
+func (g *Game) canDrop(colorIdx int, t PieceType) bool {
+	return t == TypePawn || g.armyRules(colorIdx).canPromote(t)
+}
+
 // FullmoveNumber is the number of moves black has made, not counting
 // king-turns. This starts at 0 and becomes 1 on white's next regular turn.
 func (g *Game) FullmoveNumber() int {
@@ -249,51 +382,57 @@ func (g *Game) FullmoveNumber() int {
 }
 
 func (g *Game) updateGameState() {
+	g.gameStateStale = false
+	result := g.basicResult()
+	if result.State == GameInProgress && !g.hasLegalMoves() {
+		inCheck := g.IsInCheck(g.toMove)
+		if inCheck {
+			result.Reason = EndReasonCheckmate
+		} else {
+			result.Reason = EndReasonStalemate
+		}
+		if g.flags&GameFlagStalemate != 0 && !inCheck {
+			// This is a stalemate
+			result.State = GameOverDraw
+		} else if g.toMove == ColorWhite {
+			result.State = GameOverBlack
+		} else {
+			result.State = GameOverWhite
+		}
+	}
+	g.gameState = result.State
+	g.endReason = result.Reason
+}
+
+// Returns the result of the game considering only the rules that don't depend
+// on the legal moves.
+func (g *Game) basicResult() GameResult {
 	useMidline := g.flags&GameFlagMidline != 0
 	if useMidline && g.board.pieceMask(TypeKing) & ^whiteMidline == 0 {
 		// White has won by moving all kings past the midline
-		g.gameState = GameOverWhite
+		return GameResult{GameOverWhite, EndReasonMidline}
 	} else if useMidline && g.board.pieceMask(TypeKing) & ^blackMidline == 0 {
 		// Black has won by moving all kings past the midline
-		g.gameState = GameOverBlack
+		return GameResult{GameOverBlack, EndReasonMidline}
 	} else if g.halfmoveClock >= 50 {
 		// Draw via fifty move rule
-		g.gameState = GameOverDraw
-	} else if !g.hasLegalMoves() {
-		if g.flags&GameFlagStalemate != 0 && !g.IsInCheck(g.toMove) {
-			// This is a stalemate
-			g.gameState = GameOverDraw
-		} else if g.toMove == ColorWhite {
-			g.gameState = GameOverBlack
-		} else {
-			g.gameState = GameOverWhite
-		}
+		return GameResult{GameOverDraw, EndReasonFiftyMove}
 	}
+	return GameResult{GameInProgress, EndReasonNone}
 }
 
-// IsInCheck determines if the given player is currently in check, regardless of
-// if they are the player to move. If the game is over due to checkmate, this
-// method will return true for the losing player.
-func (g *Game) IsInCheck(color Color) bool {
-	otherArmy := g.armies[ColorIdx(OtherColor(color))]
-	kingMask := g.board.colorMask(color) & g.board.pieceMask(TypeKing)
-	enemyMask := g.board.colorMask(OtherColor(color))
-	if otherArmy == ArmyAnimals {
-		// Calculate check from elephants separately
-		//enemyMask &^= g.board.pieceMask(TypeRook)
-		// TODO - the color is not in check if a rampage would kill one of the
-		// other color's kings as well.
-	}
-	threatenedMask := g.fullAttackMask(enemyMask)
-	return threatenedMask&kingMask != 0
+// Returns true if no moves can be made, without looking for legal moves.
+func (g *Game) isGameOver() bool {
+	return g.gameState != GameInProgress || g.gameStateStale && g.basicResult().State != GameInProgress
 }
 
 // GenerateLegalMoves returns an array of all legal moves from the current board
 // state.
 func (g *Game) GenerateLegalMoves() []Move {
 	var results []Move
-	g.generateMoves(func(m Move) {
-		if err := g.ValidateLegalMove(m); err == nil {
+	filter := g.newLegalityFilter()
+	g.generatePseudoLegalMoves(func(m Move, p Piece) {
+		if filter.isLegal(m, p) {
 			results = append(results, m)
 		}
 	})
@@ -313,93 +452,15 @@ func (g *Game) hasLegalMoves() (result bool) {
 			panic(r)
 		}
 	}()
-	g.generateMoves(func(m Move) {
-		if g.ValidateLegalMove(m) == nil {
+	filter := g.newLegalityFilter()
+	g.generatePseudoLegalMoves(func(m Move, p Piece) {
+		if filter.isLegal(m, p) {
 			panic("legal moves exist")
 		}
 	})
 	return
 }
 
-// As generateMovesFrom, but for every square on the game board.
-func (g *Game) generateMoves(send func(Move)) {
-	fromMask := g.board.colorMask(g.toMove)
-	if g.kingTurn {
-		fromMask &= g.board.pieceMask(TypeKing)
-		send(MovePass)
-	}
-	eachSquareInMask(fromMask, func(from Square) {
-		g.generateMovesFrom(from, send)
-	})
-}
-
-// Generates a superset of pseudo-legal moves originating from the given square.
-// The returned set includes all pseudo-legal moves from this game state, but
-// may include illegal moves as well. Each move will only be sent once.
-func (g *Game) generateMovesFrom(from Square, sendOne func(Move)) {
-	piece, found := g.board.PieceAt(from)
-	piece = piece.WithArmy(g.armies[ColorIdx(piece.Color())])
-	if !found {
-		return
-	}
-
-	lastRank := maskRank[7*ColorIdx(piece.Color())]
-	// send the move, but enumerate all possible promotions if there are any
-	send := func(move Move) {
-		if piece.Type() == TypePawn && move.To.mask()&lastRank != 0 {
-			for t := range basicTypeNames {
-				move.Piece = NewPiece(t, ArmyNone, ColorWhite)
-				sendOne(move)
-			}
-		} else {
-			sendOne(move)
-		}
-	}
-
-	// Cover all normal sliding attacks
-	attackMask := g.attackMask(from)
-	eachSquareInMask(attackMask, func(to Square) {
-		send(Move{From: from, To: to})
-	})
-
-	if piece.Type() == TypePawn {
-		if piece.Army() == ArmyNemesis {
-			// Nemesis moves
-			for y := -1; y <= 1; y++ {
-				if from.Y()+y < 0 || from.Y()+y > 7 {
-					continue
-				}
-				for x := -1; x <= 1; x++ {
-					if from.X()+x < 0 || from.X()+x > 7 {
-						continue
-					}
-					to := SquareFromCoords(from.X()+x, from.Y()+y)
-					if to.mask()&attackMask == 0 {
-						send(Move{From: from, To: to})
-					}
-				}
-			}
-		} else {
-			// Pawn advancement
-			sign := ColorIdx(piece.Color())*2 - 1
-			for n := 1; n <= 2; n++ {
-				y := from.Y() + sign*n
-				if y >= 0 && y < 8 {
-					send(Move{From: from, To: SquareFromCoords(from.X(), y)})
-				}
-			}
-		}
-	} else if piece.Name() == PieceNameClassicKing {
-		// Castling
-		if from.X() == 4 {
-			send(Move{From: from, To: SquareFromCoords(from.X()-2, from.Y())})
-			send(Move{From: from, To: SquareFromCoords(from.X()+2, from.Y())})
-		}
-	} else if piece.Name() == PieceNameTwoKingsKing {
-		send(Move{From: from, To: from})
-	}
-}
-
 // GenerateDuels returns an array of Moves based on the given move,
 // corresponding to every legal combination of duels. The existing duels on the
 // move are ignored. The duels returns by this method always choose to gain a
@@ -458,7 +519,15 @@ func (g *Game) ApplyMove(move Move) Game {
 
 // Applies the move in-place.
 func (g *Game) applyMove(move Move) {
-	if move.IsDrop() {
+	g.makeMove(move, nil)
+}
+
+// Applies the move in-place, recording the captured pieces in undo if it is
+// not nil.
+func (g *Game) makeMove(move Move, undo *Undo) {
+	if move.IsDrop() && g.flags&GameFlagDrops == 0 {
+		// Without GameFlagDrops, a drop places the piece without taking a
+		// turn, which is useful for setting up positions.
 		p := move.Piece.WithArmy(g.armies[ColorIdx(move.Piece.Color())])
 		g.board.SetPieceAt(move.To, p)
 		return
@@ -469,13 +538,18 @@ func (g *Game) applyMove(move Move) {
 	epSquare := g.epSquare
 	if !g.kingTurn {
 		g.halfmoveClock++
-		g.epSquare = InvalidSquare
+		g.setEpSquare(InvalidSquare)
 	}
-	if g.armies[ColorIdx(movingPlayer)] == ArmyTwoKings && !g.kingTurn {
+	if g.armyRules(ColorIdx(movingPlayer)).kingTurn && !g.kingTurn {
 		g.kingTurn = true
+		g.hash ^= zobristKingTurn
 	} else {
-		g.kingTurn = false
+		if g.kingTurn {
+			g.kingTurn = false
+			g.hash ^= zobristKingTurn
+		}
 		g.toMove = OtherColor(movingPlayer)
+		g.hash ^= zobristBlackToMove
 	}
 	if g.toMove == ColorWhite && !g.kingTurn {
 		g.fullmoveNumber++
@@ -483,29 +557,44 @@ func (g *Game) applyMove(move Move) {
 
 	if move.IsPass() {
 		return
+	} else if move.IsDrop() {
+		colorIdx := ColorIdx(movingPlayer)
+		g.addReserve(colorIdx, move.Piece.Type(), -1)
+		g.board.SetPieceAt(move.To, move.Piece.WithArmy(g.armies[colorIdx]))
+		return
 	}
 
-	// Handle captures and duels
 	p, _ := g.board.PieceAt(move.From)
 	p = p.WithArmy(g.armies[ColorIdx(p.Color())])
+	rules := p.rules()
+	if rook := g.castlingRook(p, move); rook != InvalidSquare {
+		g.castle(p, move.From, rook)
+		if undo != nil {
+			undo.castlingRook = rook
+		}
+		return
+	}
+
+	// Handle captures and duels
 	me := moveExecution{
 		epSquare:       epSquare,
 		attackerStones: g.stones[ColorIdx(movingPlayer)],
 		defenderStones: g.stones[1-ColorIdx(movingPlayer)],
 		duels:          move.Duels[:],
 		dryRun:         false,
+		undo:           undo,
 	}
 	survived := g.handleAllCaptures(p, move, &me)
 
 	// Update stones
-	g.stones[ColorIdx(movingPlayer)] = me.attackerStones
-	g.stones[1-ColorIdx(movingPlayer)] = me.defenderStones
+	g.setStones(ColorIdx(movingPlayer), me.attackerStones)
+	g.setStones(1-ColorIdx(movingPlayer), me.defenderStones)
 	isZeroingMove := me.isCapture
 
 	// Move the piece
 	delta := int(move.To.Address) - int(move.From.Address)
 	if survived {
-		if p.Name() != PieceNameAnimalsBishop || !isZeroingMove {
+		if !rules.staysOnCapture || !isZeroingMove {
 			g.board.ClearPieceAt(move.From)
 			if move.Piece != InvalidPiece {
 				promoted := NewPiece(move.Piece.Type(), p.Army(), p.Color())
@@ -513,32 +602,22 @@ func (g *Game) applyMove(move Move) {
 			} else {
 				g.board.SetPieceAt(move.To, p)
 			}
+			if undo != nil {
+				undo.placed = true
+			}
 		}
 	} else {
 		g.board.ClearPieceAt(move.From)
 	}
-	if p.Type() == TypePawn && p.Army() != ArmyNemesis {
+	if p.Type() == TypePawn && !rules.towardKings {
 		isZeroingMove = true
 		if SquareDistance(move.From, move.To) > 1 {
-			g.epSquare = Square{Address: uint8(int(move.From.Address) + delta/2)}
+			g.setEpSquare(Square{Address: uint8(int(move.From.Address) + delta/2)})
 		}
-	} else if p.Name() == PieceNameClassicKing {
+	} else if rules.castles {
 		// Clear castling rights on king move
 		firstRank := maskRank[7-7*ColorIdx(p.Color())]
-		g.castlingRights &^= firstRank
-
-		// Move rook when castling
-		if delta == -2 {
-			g.board.MovePiece(
-				Square{Address: move.To.Address - 2},
-				Square{Address: move.To.Address + 1},
-			)
-		} else if delta == 2 {
-			g.board.MovePiece(
-				Square{Address: move.To.Address + 1},
-				Square{Address: move.To.Address - 1},
-			)
-		}
+		g.clearCastlingRights(firstRank)
 	}
 
 	// Update the halfmove clock
@@ -546,31 +625,59 @@ func (g *Game) applyMove(move Move) {
 		g.halfmoveClock = 0
 	}
 	// Clear castling right on rook move or rook capture
-	for _, mask := range castles {
-		if move.From.mask()&mask != 0 || move.To.mask()&mask != 0 {
-			g.castlingRights &^= mask
-		}
-	}
+	g.clearCastlingRights(move.From.mask() | move.To.mask())
 
 	return
 }
 
+// setEpSquare updates the en passant square and the position key.
+func (g *Game) setEpSquare(sq Square) {
+	if g.epSquare != InvalidSquare {
+		g.hash ^= zobristEpSquare[g.epSquare.Address]
+	}
+	g.epSquare = sq
+	if sq != InvalidSquare {
+		g.hash ^= zobristEpSquare[sq.Address]
+	}
+}
+
+// setStones updates the stones of a player and the position key.
+func (g *Game) setStones(colorIdx int, stones int) {
+	g.hash ^= zobristStoneCount(colorIdx, g.stones[colorIdx])
+	g.stones[colorIdx] = stones
+	g.hash ^= zobristStoneCount(colorIdx, stones)
+}
+
+// addReserve changes the number of pieces of the given type in a player's
+// reserve and updates the position key.
+func (g *Game) addReserve(colorIdx int, t PieceType, delta int) {
+	idx := pieceTypeIdx(t)
+	g.hash ^= zobristReserveCount(colorIdx, idx, g.reserves[colorIdx][idx])
+	g.reserves[colorIdx][idx] += delta
+	g.hash ^= zobristReserveCount(colorIdx, idx, g.reserves[colorIdx][idx])
+}
+
+// clearCastlingRights removes the given castling rights and updates the
+// position key.
+func (g *Game) clearCastlingRights(mask uint64) {
+	eachSquareInMask(g.castlingRights&mask, func(sq Square) {
+		g.hash ^= zobristCastling[sq.Address]
+	})
+	g.castlingRights &^= mask
+}
+
 func (g *Game) handleAllCaptures(p Piece, move Move, me *moveExecution) bool {
+	if g.castlingRook(p, move) != InvalidSquare {
+		// The king moving onto its own rook to castle isn't a capture.
+		return true
+	}
 	survived := true
 	diff := int(move.To.Address) - int(move.From.Address)
-	if p.Name() == PieceNameAnimalsRook {
-		var delta, step int
-		if diff <= -8 {
-			step = -8
-		} else if diff < 0 {
-			step = -1
-		} else if diff < 8 {
-			step = 1
-		} else {
-			step = 8
-		}
-		for {
-			delta += step
+	rules := p.rules()
+	if rules.rampage {
+		dx, dy := rampageDirection(move.From, move.To)
+		step := dy*8 + dx
+		for delta := step; ; delta += step {
 			if delta > 64 || delta < -64 {
 				panic("invalid rampage")
 			}
@@ -583,11 +690,9 @@ func (g *Game) handleAllCaptures(p Piece, move Move, me *moveExecution) bool {
 				break
 			}
 		}
-	} else if p.Name() == PieceNameTwoKingsKing && move.From == move.To {
+	} else if rules.whirlwind && move.From == move.To {
 		captureMask := dist1Mask[move.From.Address] & g.board.occupiedMask()
-		if g.armies[1-ColorIdx(p.Color())] == ArmyReaper {
-			captureMask &^= g.board.pieceMask(TypeRook) & g.board.colorMask(OtherColor(p.Color()))
-		}
+		captureMask &^= g.protectedMask(p, move.From)
 		eachSquareInMask(captureMask, func(target Square) {
 			g.handleCapture(p, target, me)
 		})
@@ -605,22 +710,49 @@ func (g *Game) handleAllCaptures(p Piece, move Move, me *moveExecution) bool {
 	return survived
 }
 
+// rampageDirection returns the single step in the direction of a rampage
+// between the given squares.
+func rampageDirection(from, to Square) (dx, dy int) {
+	if diff := to.X() - from.X(); diff != 0 {
+		dx = diff / abs(diff)
+	}
+	if diff := to.Y() - from.Y(); diff != 0 {
+		dy = diff / abs(diff)
+	}
+	return
+}
+
 func (g *Game) handleCapture(attacker Piece, target Square, me *moveExecution) bool {
 	defender, isCapture := g.board.PieceAt(target)
 	if !isCapture {
 		return true
 	}
+	defender = defender.WithArmy(g.armies[ColorIdx(defender.Color())])
 	me.isCapture = me.isCapture || isCapture
+	if me.captures != nil {
+		*me.captures = append(*me.captures, DuelOpportunity{
+			Square:         target,
+			Attacker:       attacker,
+			Defender:       defender,
+			AttackerStones: me.attackerStones,
+			DefenderStones: me.defenderStones,
+			MaxStones:      g.maxStones,
+		})
+	}
 	survived := true
 	if !me.dryRun {
 		g.board.ClearPieceAt(target)
+		if me.undo != nil {
+			me.undo.captured[me.undo.numCaptured] = capturedPiece{target, defender}
+			me.undo.numCaptured++
+		}
 	}
 	if len(me.duels) > 0 {
 		if d := me.duels[0]; d.IsStarted() {
 			if (attacker.Type() == TypeKing || defender.Type() == TypeKing || attacker.Color() == defender.Color()) && me.err == nil {
 				me.err = NotDuelableError
 			}
-			if DuelingRank(attacker.Type()) < DuelingRank(defender.Type()) {
+			if attacker.DuelingRank() < defender.DuelingRank() {
 				if me.attackerStones > 0 {
 					me.attackerStones--
 				} else if me.err == nil {
@@ -635,7 +767,7 @@ func (g *Game) handleCapture(attacker Piece, target Square, me *moveExecution) b
 			me.attackerStones -= d.Response()
 			if d.Challenge() == 0 && d.Response() == 0 {
 				if d.Gain() {
-					if me.attackerStones < 6 {
+					if me.attackerStones < g.maxStones {
 						me.attackerStones++
 					}
 				} else {
@@ -645,15 +777,23 @@ func (g *Game) handleCapture(attacker Piece, target Square, me *moveExecution) b
 				}
 			}
 			survived = d.Challenge() <= d.Response()
-			if !survived && attacker.Type() == TypePawn && me.defenderStones < 6 {
+			if !survived && attacker.Type() == TypePawn && me.defenderStones < g.maxStones {
 				me.defenderStones++
 			}
 		}
 		me.duels = me.duels[1:]
 	}
-	if defender.Color() != attacker.Color() && defender.Type() == TypePawn && me.attackerStones < 6 {
+	if defender.Color() != attacker.Color() && defender.Type() == TypePawn && me.attackerStones < g.maxStones {
 		me.attackerStones++
 	}
+	if !me.dryRun && g.flags&GameFlagDrops != 0 {
+		if defender.Color() != attacker.Color() && defender.Type() != TypeKing {
+			g.addReserve(ColorIdx(attacker.Color()), defender.Type(), 1)
+		}
+		if !survived {
+			g.addReserve(ColorIdx(defender.Color()), attacker.Type(), 1)
+		}
+	}
 	return survived
 }
 
@@ -664,13 +804,23 @@ func (g *Game) handleCapture(attacker Piece, target Square, me *moveExecution) b
 // the distance and direction rules for the piece moved; all intermediate
 // squares are empty (except for jump moves) or capturable (for the Elephant's
 // rampage); and the target square is empty or contains a capturable piece.
-// Additionally, a pass move is pseudo-legal during a king turn.
+// Additionally, a pass move is pseudo-legal during a king turn, and with
+// GameFlagDrops, dropping a piece from the player's reserve onto an empty
+// square is pseudo-legal, except during a king turn or for pawns on the first
+// or last rank.
+//
+// Castling follows the Chess960 rules: the king and rook finish on the same
+// squares as in a standard game, only they may be on the squares between
+// their starting and finishing squares, and the king may not start, pass
+// through or finish on a threatened square. A castle is written as the king
+// moving onto the rook, unless the king is on the e-file and the rook in the
+// corner, where it is written as the king moving two squares.
 func (g *Game) ValidatePseudoLegalMove(move Move) error {
 	// Basic checks
-	if g.gameState != GameInProgress {
+	if g.isGameOver() {
 		return GameOverError
 	} else if move.IsDrop() {
-		return IllegalDropError
+		return g.validateDrop(move)
 	} else if move.IsPass() {
 		if !g.kingTurn {
 			return IllegalPassError
@@ -680,21 +830,20 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 
 	// Check turn
 	piece, found := g.board.PieceAt(move.From)
-	piece = piece.WithArmy(g.armies[ColorIdx(piece.Color())])
 	if !found || piece.Color() != g.toMove {
 		return NotMovablePieceError
 	} else if g.kingTurn && piece.Type() != TypeKing {
 		return IllegalKingTurnError
 	}
+	piece = piece.WithArmy(g.armies[ColorIdx(piece.Color())])
+	rules := piece.rules()
 
 	// Check promotions
 	lastRank := maskRank[7*ColorIdx(piece.Color())]
 	if move.Piece != InvalidPiece {
 		if piece.Type() != TypePawn ||
 			move.To.mask()&lastRank == 0 ||
-			move.Piece.Type() == TypePawn ||
-			move.Piece.Type() == TypeKing ||
-			move.Piece.Type() == TypeQueen && piece.Army() == ArmyTwoKings {
+			!g.armyRules(ColorIdx(piece.Color())).canPromote(move.Piece.Type()) {
 			return IllegalPromotionError
 		}
 	} else if piece.Type() == TypePawn && move.To.mask()&lastRank != 0 {
@@ -708,14 +857,14 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 		sign := ColorIdx(piece.Color())*2 - 1
 		forwardDiff := sign * diff
 		_, targetOccupied := g.board.PieceAt(move.To)
-		if forwardDiff < 0 && piece.Army() != ArmyNemesis {
+		if forwardDiff < 0 && !rules.towardKings {
 			return UnreachableSquareError
 		} else if forwardDiff == 8 {
 			if targetOccupied {
 				return IllegalCaptureError
 			}
 			return validateNoDuels(move, TooManyDuelsError)
-		} else if forwardDiff == 16 && piece.Army() != ArmyNemesis {
+		} else if forwardDiff == 16 && rules.doubleStep {
 			// targetRank = 4 for white, 3 for black
 			targetRank := 4 - ColorIdx(piece.Color())
 			middleOccupied := betweenMask[move.From.Address][move.To.Address]&g.board.occupiedMask() != 0
@@ -732,7 +881,7 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 				return g.ValidateDuels(move)
 			}
 		}
-		if piece.Army() == ArmyNemesis && !targetOccupied {
+		if rules.towardKings && !targetOccupied {
 			// Check for nemesis move
 			mask := singleStepMask(move.From, g.board.pieceMask(TypeKing)&g.board.colorMask(OtherColor(piece.Color())))
 			if move.To.mask()&mask == 0 {
@@ -751,26 +900,19 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 	// Check castling
 	if piece.Type() == TypeKing {
 		diff := int(move.To.Address) - int(move.From.Address)
-		if diff == 2 || diff == -2 {
-			if piece.Name() != PieceNameClassicKing ||
-				g.castlingRights&requiredCastlingRight(piece.Color(), diff < 0) == 0 {
-				return IllegalCastleError
-			}
-			firstRank := maskRank[7-7*ColorIdx(piece.Color())]
-			if diff < 0 {
-				firstRank &= queensideMask
-			} else {
-				firstRank &= kingsideMask
-			}
-			if g.board.occupiedMask()&firstRank != 0 {
+		rook := g.castlingRook(piece, move)
+		if rook == InvalidSquare && (diff == 2 || diff == -2) {
+			return IllegalCastleError
+		} else if rook != InvalidSquare {
+			// Only the king and rook may be on the squares that they pass
+			// through, and the king can't pass through check.
+			kingTo, rookTo := castlingSquares(move.From, rook)
+			kingPath := rankSpan(move.From, kingTo)
+			others := g.board.occupiedMask() &^ move.From.mask() &^ rook.mask()
+			if others&(kingPath|rankSpan(rook, rookTo)) != 0 {
 				return IllegalCastleError
 			}
-			checkMask := Square{Address: move.From.Address + uint8(diff/2)}.mask()
-			checkMask |= move.From.mask()
-			checkMask |= move.To.mask()
-			threats := g.board.colorMask(OtherColor(piece.Color()))
-			threatenedMask := g.fullAttackMask(threats)
-			if threatenedMask&checkMask != 0 {
+			if g.threatMask(OtherColor(piece.Color()))&kingPath != 0 {
 				return IllegalCastleError
 			}
 			return validateNoDuels(move, NotDuelableError)
@@ -780,7 +922,7 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 
 	// Check whirlwind attack
 	if move.From == move.To {
-		if piece.Name() != PieceNameTwoKingsKing || !g.kingTurn {
+		if !rules.whirlwind || !g.kingTurn {
 			return IllegalWhirlwindAttackError
 		}
 		attackedKings := g.board.pieceMask(TypeKing) & g.board.colorMask(piece.Color()) & dist1Mask[move.From.Address]
@@ -796,37 +938,13 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 	}
 
 	// Check captures
-	// noncapturableMask is the mask of pieces that cannot be captured by the
-	// moving piece. In the case of an elephant, noncapturableMask is the
-	// mask of pieces that can stop a rampage.
-	noncapturableMask := maskEmpty
-	if piece.Name() == PieceNameAnimalsKnight {
-		// Cannot capture own king
-		noncapturableMask |= g.board.colorMask(piece.Color()) & g.board.pieceMask(TypeKing)
-	} else if piece.Name() != PieceNameAnimalsRook {
-		// Cannot capture own pieces
-		noncapturableMask |= g.board.colorMask(piece.Color())
-	}
-	for colorIdx := 0; colorIdx < 2; colorIdx++ {
-		army := g.armies[colorIdx]
-		colorPieces := g.board.colors[colorIdx]
-		if piece.Type() != TypeKing && army == ArmyNemesis {
-			// Cannot capture nemesis queen
-			noncapturableMask |= colorPieces & g.board.pieceMask(TypeQueen)
-		}
-		if army == ArmyReaper {
-			// Cannot capture reaper rook
-			noncapturableMask |= colorPieces & g.board.pieceMask(TypeRook)
-		}
-		if army == ArmyAnimals {
-			// Cannot capture elephants more than 3 spaces away
-			noncapturableMask |= colorPieces & g.board.pieceMask(TypeRook) & ^dist2Mask[move.From.Address]
-		}
-	}
-	// visitedSquares is a mask of squares visited by the move. Generally these
-	// need to be empty, except for the last one, for the move to be valid.
+	noncapturableMask := g.noncapturableMask(piece, move.From)
+	// visitedSquares is a mask of squares visited by the move, which need to
+	// be capturable. Other than during a rampage, only move.To is checked:
+	// the attackMask already stops rides at the first occupied square, and
+	// leaps and hops may pass over occupied squares.
 	visitedSquares := move.To.mask()
-	if piece.Name() != PieceNameReaperRook && piece.Name() != PieceNameReaperQueen {
+	if rules.rampage {
 		// These pieces attack each square they pass through
 		visitedSquares |= betweenMask[move.From.Address][move.To.Address]
 	}
@@ -834,34 +952,17 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 		return IllegalCaptureError
 	}
 
-	if piece.Name() == PieceNameAnimalsRook {
-		diff := int(move.To.Address) - int(move.From.Address)
-		if SquareDistance(move.From, move.To) < 3 && visitedSquares&g.board.occupiedMask() != 0 {
-			// Moving less than 3 spaces is only allowed if the elephant hits a
-			// noncapturable piece or the edge of the board, or if all of the
-			// spaces are empty.
-			var step int
-			var hitWall bool
-			if diff <= -8 {
-				step = -8
-				hitWall = move.To.Y() == 0
-			} else if diff < 0 {
-				step = -1
-				hitWall = move.To.X() == 0
-			} else if diff < 8 {
-				step = 1
-				hitWall = move.To.X() == 7
-			} else {
-				step = 8
-				hitWall = move.To.Y() == 7
-			}
-			if !hitWall {
-				checkAddr := int(move.To.Address) + step
-				if checkAddr >= 0 && checkAddr < 64 {
-					wall := Square{Address: uint8(checkAddr)}
-					if noncapturableMask&wall.mask() == 0 {
-						return IllegalRampageError
-					}
+	if rules.rampage {
+		if SquareDistance(move.From, move.To) < rules.rampageRange && visitedSquares&g.board.occupiedMask() != 0 {
+			// Moving less than the full distance is only allowed if the
+			// rampage hits a noncapturable piece or the edge of the board, or
+			// if all of the spaces are empty.
+			dx, dy := rampageDirection(move.From, move.To)
+			wallX, wallY := move.To.X()+dx, move.To.Y()+dy
+			if wallX >= 0 && wallX < 8 && wallY >= 0 && wallY < 8 {
+				wall := SquareFromCoords(wallX, wallY)
+				if noncapturableMask&wall.mask() == 0 {
+					return IllegalRampageError
 				}
 			}
 		}
@@ -874,6 +975,21 @@ func (g *Game) ValidatePseudoLegalMove(move Move) error {
 	return g.ValidateDuels(move)
 }
 
+// Returns an error describing why the given drop is not pseudo-legal.
+func (g *Game) validateDrop(move Move) error {
+	colorIdx := ColorIdx(g.toMove)
+	t := move.Piece.Type()
+	if g.flags&GameFlagDrops == 0 || g.kingTurn || move.Piece.Color() != g.toMove ||
+		!g.canDrop(colorIdx, t) || g.reserves[colorIdx][pieceTypeIdx(t)] == 0 {
+		return IllegalDropError
+	} else if _, occupied := g.board.PieceAt(move.To); occupied {
+		return IllegalDropError
+	} else if t == TypePawn && move.To.mask()&(maskRank[0]|maskRank[7]) != 0 {
+		return IllegalDropError
+	}
+	return validateNoDuels(move, TooManyDuelsError)
+}
+
 func validateNoDuels(move Move, err error) error {
 	for _, d := range move.Duels {
 		if d.IsStarted() {
@@ -912,71 +1028,38 @@ func (g *Game) attackMask(from Square) uint64 {
 		// No piece, nothing threatened
 		return 0
 	}
-	piece = piece.WithArmy(g.armies[ColorIdx(piece.Color())])
-	switch piece.Name() {
-	case PieceNameClassicKing, PieceNameBasicKing, PieceNameEmpoweredQueen, PieceNameTwoKingsKing:
-		return dist1Mask[from.Address]
-	case PieceNameBasicQueen:
-		diag := diagMask[from.Address] & g.board.occupiedMask()
-		orth := orthMask[from.Address] & g.board.occupiedMask()
-		return diagAttackMask[from.Address][diag] | orthAttackMask[from.Address][orth]
-	case PieceNameBasicBishop:
-		diag := diagMask[from.Address] & g.board.occupiedMask()
-		return diagAttackMask[from.Address][diag]
-	case PieceNameBasicKnight, PieceNameAnimalsKnight:
-		return knightMask[from.Address]
-	case PieceNameBasicRook:
-		orth := orthMask[from.Address] & g.board.occupiedMask()
-		return orthAttackMask[from.Address][orth]
-	case PieceNameBasicPawn, PieceNameNemesisPawn:
+	army := g.armyRules(ColorIdx(piece.Color()))
+	rules := &army.pieces[pieceTypeIdx(piece.Type())]
+	var mask uint64
+	if piece.Type() == TypePawn {
 		sign := ColorIdx(piece.Color())*2 - 1
-		mask := uint64(0)
 		if from.X() > 0 {
 			mask |= 1 << (int(from.Address) - 1 + 8*sign)
 		}
 		if from.X() < 7 {
 			mask |= 1 << (int(from.Address) + 1 + 8*sign)
 		}
-		return mask
-	case PieceNameNemesisQueen:
-		diag := diagMask[from.Address] & g.board.occupiedMask()
-		orth := orthMask[from.Address] & g.board.occupiedMask()
-		// Nemesis queen can only threaten kings
-		threat := ^g.board.occupiedMask() | g.board.pieceMask(TypeKing)
-		return (diagAttackMask[from.Address][diag] | orthAttackMask[from.Address][orth]) & threat
-	case PieceNameEmpoweredBishop, PieceNameEmpoweredKnight, PieceNameEmpoweredRook:
-		// Same-colored adjacent pieces, including the from square.
-		ownAdjacent := (adjacentMask[from.Address] | from.mask()) & g.board.colorMask(piece.Color())
-		mask := uint64(0)
-		if ownAdjacent&g.board.pieceMask(TypeRook) != 0 {
-			orth := orthMask[from.Address] & g.board.occupiedMask()
-			mask |= orthAttackMask[from.Address][orth]
-		}
-		if ownAdjacent&g.board.pieceMask(TypeBishop) != 0 {
-			diag := diagMask[from.Address] & g.board.occupiedMask()
-			mask |= diagAttackMask[from.Address][diag]
-		}
-		if ownAdjacent&g.board.pieceMask(TypeKnight) != 0 {
-			mask |= knightMask[from.Address]
+	} else {
+		mask = g.movesMask(rules, piece.Color(), from)
+	}
+	if len(rules.borrows) > 0 {
+		// Same-colored adjacent pieces lend their moves.
+		ownAdjacent := adjacentMask[from.Address] & g.board.colorMask(piece.Color())
+		for _, t := range rules.borrows {
+			if ownAdjacent&g.board.pieceMask(t) != 0 {
+				mask |= g.movesMask(&army.pieces[pieceTypeIdx(t)], piece.Color(), from)
+			}
 		}
-		return mask
-	case PieceNameReaperQueen:
-		candidates := ^maskRank[7*ColorIdx(piece.Color())]
-		kings := g.board.pieceMask(TypeKing) & g.board.colorMask(OtherColor(piece.Color()))
-		return candidates &^ kings
-	case PieceNameReaperRook:
-		return ^g.board.occupiedMask()
-	case PieceNameAnimalsQueen:
-		orth := orthMask[from.Address] & g.board.occupiedMask()
-		return orthAttackMask[from.Address][orth] | knightMask[from.Address]
-	case PieceNameAnimalsBishop:
-		diag := diagMask[from.Address] & g.board.occupiedMask()
-		return diagAttackMask[from.Address][diag] & dist2Mask[from.Address]
-	case PieceNameAnimalsRook:
-		return orthAttackMask[from.Address][0] & dist3Mask[from.Address]
-	default:
-		panic("Invalid piece type")
 	}
+	switch rules.captures {
+	case captureKings:
+		mask &= ^g.board.occupiedMask() | g.board.pieceMask(TypeKing)
+	case captureNotKings:
+		mask &^= g.board.pieceMask(TypeKing) & g.board.colorMask(OtherColor(piece.Color()))
+	case captureNone:
+		mask &^= g.board.occupiedMask()
+	}
+	return mask
 }
 
 func (g *Game) fullAttackMask(from uint64) (result uint64) {
@@ -1023,15 +1106,71 @@ func eachSquareInMask(mask uint64, f func(Square)) {
 	}
 }
 
-func requiredCastlingRight(color Color, isQueenside bool) uint64 {
-	if color == ColorWhite {
-		if isQueenside {
-			return castleWhiteQueenside
+// castlingRook returns the square of the rook that the given king castles with
+// in the move, or InvalidSquare if the move isn't a castle. See
+// ValidatePseudoLegalMove for how castles are written.
+func (g *Game) castlingRook(p Piece, move Move) Square {
+	y := move.From.Y()
+	if p.Type() != TypeKing || y != 7-7*ColorIdx(p.Color()) || move.To.Y() != y ||
+		!p.rules().castles {
+		return InvalidSquare
+	}
+	rook := move.To
+	if move.From.X() == 4 {
+		if g.castlingRights&move.To.mask() == 0 {
+			switch move.To.X() {
+			case 2:
+				rook = SquareFromCoords(0, y)
+			case 6:
+				rook = SquareFromCoords(7, y)
+			}
+		} else if move.To.X() == 0 || move.To.X() == 7 {
+			return InvalidSquare
 		}
-		return castleWhiteKingside
 	}
-	if isQueenside {
-		return castleBlackQueenside
+	if g.castlingRights&rook.mask() == 0 {
+		return InvalidSquare
+	}
+	if q, found := g.board.PieceAt(rook); !found || q.Type() != TypeRook || q.Color() != p.Color() {
+		return InvalidSquare
 	}
-	return castleBlackKingside
+	return rook
+}
+
+// castlingMove returns the move which castles the king with the given rook.
+// See castlingRook.
+func castlingMove(king, rook Square) Move {
+	if king.X() == 4 && (rook.X() == 0 || rook.X() == 7) {
+		kingTo, _ := castlingSquares(king, rook)
+		return Move{From: king, To: kingTo}
+	}
+	return Move{From: king, To: rook}
+}
+
+// castlingSquares returns where the king and rook end up after castling. As
+// in Chess960, they finish on the same squares as in a standard game.
+func castlingSquares(king, rook Square) (kingTo, rookTo Square) {
+	y := king.Y()
+	if rook.X() < king.X() {
+		return SquareFromCoords(2, y), SquareFromCoords(3, y)
+	}
+	return SquareFromCoords(6, y), SquareFromCoords(5, y)
+}
+
+// rankSpan returns the squares from a to b, inclusive, which must be on the
+// same rank.
+func rankSpan(a, b Square) uint64 {
+	return a.mask() | b.mask() | betweenMask[a.Address][b.Address]
+}
+
+// castle moves the king and rook to their squares after castling, and removes
+// the player's castling rights.
+func (g *Game) castle(king Piece, from, rook Square) {
+	kingTo, rookTo := castlingSquares(from, rook)
+	r, _ := g.board.PieceAt(rook)
+	g.board.ClearPieceAt(from)
+	g.board.ClearPieceAt(rook)
+	g.board.SetPieceAt(kingTo, king)
+	g.board.SetPieceAt(rookTo, r)
+	g.clearCastlingRights(maskRank[from.Y()])
 }
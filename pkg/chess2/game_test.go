@@ -9,40 +9,49 @@ import (
 
 func TestUpdateGameStatus(t *testing.T) {
 	cases := map[string]struct {
-		epd   string
-		state GameState
+		epd    string
+		state  GameState
+		reason EndReason
 	}{
 		"normal game": {
-			epd:   "4k3/8/4bnr1/8/4R3/8/PPPPPPP1/RNBQKBN1 w - - 0 1 cc 33",
-			state: GameInProgress,
+			epd:    "4k3/8/4bnr1/8/4R3/8/PPPPPPP1/RNBQKBN1 w - - 0 1 cc 33",
+			state:  GameInProgress,
+			reason: EndReasonNone,
 		},
 		"white midline victory": {
-			epd:   "4k3/8/8/4K3/8/8/8/8 b - - 0 1 cc 33",
-			state: GameOverWhite,
+			epd:    "4k3/8/8/4K3/8/8/8/8 b - - 0 1 cc 33",
+			state:  GameOverWhite,
+			reason: EndReasonMidline,
 		},
 		"black midline victory": {
-			epd:   "8/8/8/8/4k3/8/8/4K3 w - - 0 1 cc 33",
-			state: GameOverBlack,
+			epd:    "8/8/8/8/4k3/8/8/4K3 w - - 0 1 cc 33",
+			state:  GameOverBlack,
+			reason: EndReasonMidline,
 		},
 		"one king behind midline": {
-			epd:   "4k3/8/8/3K4/8/8/8/4K3 b - - 0 1 kc 33",
-			state: GameInProgress,
+			epd:    "4k3/8/8/3K4/8/8/8/4K3 b - - 0 1 kc 33",
+			state:  GameInProgress,
+			reason: EndReasonNone,
 		},
 		"two kings midline victory": {
-			epd:   "4k3/8/8/3KK3/8/8/8/8 b - - 0 1 kc 33",
-			state: GameOverWhite,
+			epd:    "4k3/8/8/3KK3/8/8/8/8 b - - 0 1 kc 33",
+			state:  GameOverWhite,
+			reason: EndReasonMidline,
 		},
 		"checkmate black": {
-			epd:   "8/8/8/7k/6QR/8/8/4K3 b - - 0 1 cc 33",
-			state: GameOverWhite,
+			epd:    "8/8/8/7k/6QR/8/8/4K3 b - - 0 1 cc 33",
+			state:  GameOverWhite,
+			reason: EndReasonCheckmate,
 		},
 		"stalemate black": {
-			epd:   "4k3/8/3R1Q2/8/8/8/8/4K3 b - - 0 1 cc 33",
-			state: GameOverWhite,
+			epd:    "4k3/8/3R1Q2/8/8/8/8/4K3 b - - 0 1 cc 33",
+			state:  GameOverWhite,
+			reason: EndReasonStalemate,
 		},
 		"fifty move rule": {
-			epd:   "4k3/8/8/8/8/8/8/4K3 w - - 50 25 cc 33",
-			state: GameOverDraw,
+			epd:    "4k3/8/8/8/8/8/8/4K3 w - - 50 25 cc 33",
+			state:  GameOverDraw,
+			reason: EndReasonFiftyMove,
 		},
 	}
 	for name, config := range cases {
@@ -50,6 +59,7 @@ func TestUpdateGameStatus(t *testing.T) {
 			game, err := ParseEpd(config.epd)
 			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
 			assert.Equal(t, game.GameState(), config.state, "Case: %s", name)
+			assert.Equal(t, GameResult{config.state, config.reason}, game.Result(), "Case: %s", name)
 		})
 	}
 }
@@ -93,6 +103,29 @@ func TestGameFromArmiesTwoKings(t *testing.T) {
 	require.Equal(t, "rnbkkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR", fen)
 }
 
+func TestSetStones(t *testing.T) {
+	game := GameFromArmies(ArmyClassic, ArmyAnimals)
+	assert.Equal(t, DefaultStones, game.Stones(ColorWhite))
+	assert.Equal(t, DefaultMaxStones, game.MaxStones())
+	start := game.Hash()
+
+	require.NoError(t, game.SetStones(1, 5, 9))
+	assert.Equal(t, 1, game.Stones(ColorWhite))
+	assert.Equal(t, 5, game.Stones(ColorBlack))
+	assert.Equal(t, 9, game.MaxStones())
+	assert.Equal(t, game.computeHash(), game.Hash())
+	assert.NotEqual(t, start, game.Hash())
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 ca 15/9", EncodeEpd(game))
+
+	require.NoError(t, game.SetStones(DefaultStones, DefaultStones, DefaultMaxStones))
+	assert.Equal(t, start, game.Hash())
+
+	assert.Error(t, game.SetStones(4, 3, 3))
+	assert.Error(t, game.SetStones(3, -1, 6))
+	assert.Error(t, game.SetStones(3, 3, MaxStonesLimit+1))
+	assert.Equal(t, start, game.Hash())
+}
+
 type AttackMaskTest struct {
 	epd     string
 	squares []string
@@ -269,12 +302,19 @@ func TestIsInCheck(t *testing.T) {
 			color:   ColorWhite,
 			inCheck: false,
 		},
+		"rampage blocked by ghost": {
+			epd:     "8/8/8/8/8/8/8/1rRK3k b - - 0 1 ra 33",
+			color:   ColorWhite,
+			inCheck: false,
+		},
+		"rampage stopped by wall": {
+			epd:     "7k/8/8/8/8/8/8/4r1pK b - - 0 1 ca 33",
+			color:   ColorWhite,
+			inCheck: true,
+		},
 	}
 	for name, config := range cases {
 		t.Run(name, func(t *testing.T) {
-			if name == "rampage would kill own king" {
-				t.Skip()
-			}
 			game, err := ParseEpd(config.epd)
 			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
 			inCheck := game.IsInCheck(config.color)
@@ -305,6 +345,40 @@ func TestValidatePseudoLegalMove(t *testing.T) {
 			move: "Q@a8",
 			err:  IllegalDropError,
 		},
+		"legal drop": {
+			epd:  "4k3/8/8/8/8/8/8/4K3[q] b - - 0 1 nn 33",
+			move: "q@a8",
+		},
+		"drop not in reserve": {
+			epd:  "4k3/8/8/8/8/8/8/4K3[Q] b - - 0 1 nn 33",
+			move: "q@a8",
+			err:  IllegalDropError,
+		},
+		"drop out of turn": {
+			epd:  "4k3/8/8/8/8/8/8/4K3[Q] b - - 0 1 nn 33",
+			move: "Q@a8",
+			err:  IllegalDropError,
+		},
+		"drop on occupied square": {
+			epd:  "4k3/8/8/8/8/8/8/4K3[q] b - - 0 1 nn 33",
+			move: "q@e1",
+			err:  IllegalDropError,
+		},
+		"drop pawn on last rank": {
+			epd:  "4k3/8/8/8/8/8/8/4K3[p] b - - 0 1 nn 33",
+			move: "p@a1",
+			err:  IllegalDropError,
+		},
+		"drop during king turn": {
+			epd:  "4k3/8/8/8/8/8/8/3KK3[N] K - - 0 1 kk 33",
+			move: "N@a1",
+			err:  IllegalDropError,
+		},
+		"drop queen with two kings": {
+			epd:  "4k3/8/8/8/8/8/8/3KK3[Q] w - - 0 1 kk 33",
+			move: "Q@a1",
+			err:  IllegalDropError,
+		},
 		"out of turn": {
 			epd:  "4k3/8/8/8/8/8/8/4K3 b - - 0 1 nn 33",
 			move: "e1e2",
@@ -498,6 +572,35 @@ func TestValidatePseudoLegalMove(t *testing.T) {
 			epd:  "4k3/8/8/8/8/8/8/R3K3 w KQkq - 0 1 cn 33",
 			move: "e1c1",
 		},
+		"chess960 castle onto rook": {
+			epd:  "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1 cc 33",
+			move: "f1b1",
+		},
+		"chess960 castle written as two squares": {
+			epd:  "4k3/8/8/8/8/8/8/1R2K1R1 w GB - 0 1 cc 33",
+			move: "e1c1",
+			err:  IllegalCastleError,
+		},
+		"chess960 castle blocked": {
+			epd:  "4k3/8/8/8/8/8/8/1RB2KR1 w GB - 0 1 cc 33",
+			move: "f1b1",
+			err:  IllegalCastleError,
+		},
+		"chess960 castle through check": {
+			epd:  "4r1k1/8/8/8/8/8/8/3K2R1 w G - 0 1 cc 33",
+			move: "d1g1",
+			err:  IllegalCastleError,
+		},
+		"chess960 castle without rook": {
+			epd:  "4k3/8/8/8/8/8/8/5K1N w K - 0 1 cc 33",
+			move: "f1h1",
+			err:  IllegalCastleError,
+		},
+		"standard castle onto rook": {
+			epd:  "4k3/8/8/8/8/8/8/R3K3 w Q - 0 1 cc 33",
+			move: "e1a1",
+			err:  UnreachableSquareError,
+		},
 		"elephant rampage": {
 			epd:  "4k3/8/8/8/RnpP4/8/8/4K3 w KQkq - 0 1 ac 33",
 			move: "a4d4",
@@ -572,6 +675,16 @@ func TestValidateLegalMove(t *testing.T) {
 			move: "e1d1",
 			err:  MoveIntoCheckError,
 		},
+		"next to elephant blocked by its own king": {
+			epd:  "8/8/8/3k4/8/4K3/3r4/8 w - - 0 1 ca 33",
+			move: "e3d3",
+			err:  nil,
+		},
+		"into elephant rampage": {
+			epd:  "8/8/8/4k3/8/4K3/3r4/8 w - - 0 1 ca 33",
+			move: "e3d3",
+			err:  MoveIntoCheckError,
+		},
 	}
 	for name, config := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -683,6 +796,16 @@ func TestApplyMove(t *testing.T) {
 			move:   "e1e2",
 			after:  "4k3/8/8/8/8/8/4K3/8 b kq - 0 1 cc 43",
 		},
+		"capturing pawn at stone limit": {
+			before: "4k3/8/8/8/8/8/4p3/4K3 w - - 0 1 cc 43/4",
+			move:   "e1e2",
+			after:  "4k3/8/8/8/8/8/4K3/8 b - - 0 1 cc 43/4",
+		},
+		"capturing pawn past default stone limit": {
+			before: "4k3/8/8/8/8/8/4p3/4K3 w - - 0 1 cc 63/9",
+			move:   "e1e2",
+			after:  "4k3/8/8/8/8/8/4K3/8 b - - 0 1 cc 73/9",
+		},
 		"capture en-passant": {
 			before: "4k3/8/8/3Pp3/8/8/8/4K3 w KQkq e6 0 1 cc 33",
 			move:   "d5e6",
@@ -733,6 +856,21 @@ func TestApplyMove(t *testing.T) {
 			move:   "e8g8",
 			after:  "5rk1/8/8/8/8/8/8/4K3 w - - 1 2 cc 33",
 		},
+		"chess960 castling queenside": {
+			before: "4k3/8/8/8/8/8/8/RK6 w Q - 0 1 cc 33",
+			move:   "b1a1",
+			after:  "4k3/8/8/8/8/8/8/2KR4 b - - 1 1 cc 33",
+		},
+		"chess960 castling with king in place": {
+			before: "4k3/8/8/8/8/8/8/1R4KR w KB - 0 1 cc 33",
+			move:   "g1h1",
+			after:  "4k3/8/8/8/8/8/8/1R3RK1 b - - 1 1 cc 33",
+		},
+		"chess960 castling onto rook square": {
+			before: "1r4kr/8/8/8/8/8/8/4K3 b kb - 0 1 cc 33",
+			move:   "g8b8",
+			after:  "2kr3r/8/8/8/8/8/8/4K3 w - - 1 2 cc 33",
+		},
 		"animals bishop noncapture": {
 			before: "4k3/8/8/8/8/5p2/8/3BK3 w - - 0 1 ac 33",
 			move:   "d1e2",
@@ -788,6 +926,21 @@ func TestApplyMove(t *testing.T) {
 			move:   "d4e5:10+",
 			after:  "4k3/8/8/8/8/8/8/4K3 b - - 0 1 cc 21",
 		},
+		"drop from reserve": {
+			before: "4k3/8/8/8/8/8/8/4K3[NNp] w - - 0 1 cc 33",
+			move:   "N@e4",
+			after:  "4k3/8/8/8/4N3/8/8/4K3[Np] b - - 1 1 cc 33",
+		},
+		"capture joins reserve": {
+			before: "4k3/8/8/8/8/8/4b3/4K3[] w - - 0 1 cc 33",
+			move:   "e1e2",
+			after:  "4k3/8/8/8/8/8/4K3/8[B] b - - 0 1 cc 33",
+		},
+		"attacker lost in duel joins reserve": {
+			before: "4k3/8/8/8/8/8/4b3/4K1N1[] w - - 0 1 cc 33",
+			move:   "g1e2:10+",
+			after:  "4k3/8/8/8/8/8/8/4K3[Bn] b - - 0 1 cc 32",
+		},
 	}
 	for name, config := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -0,0 +1,113 @@
+package chess2
+
+// maxCaptures is the most pieces a single move can capture, which is a
+// whirlwind attack surrounded by pieces.
+const maxCaptures = 8
+
+// A capturedPiece is a piece removed from the board by a move.
+type capturedPiece struct {
+	square Square
+	piece  Piece
+}
+
+// An Undo records everything that MakeMove changed, so that UnmakeMove can
+// restore the game to exactly the position before the move.
+type Undo struct {
+	move Move
+	// The piece which moved, and whether it was placed on the target square.
+	moved  Piece
+	placed bool
+	// The square that the rook started on, if the move was a castle.
+	castlingRook Square
+	// The captured pieces, in the order they were captured.
+	captured    [maxCaptures]capturedPiece
+	numCaptured int
+
+	castlingRights uint64
+	stones         [2]int
+	reserves       [2][6]int
+	toMove         Color
+	kingTurn       bool
+	gameState      GameState
+	endReason      EndReason
+	gameStateStale bool
+	halfmoveClock  int
+	fullmoveNumber int
+	epSquare       Square
+	hash           uint64
+}
+
+// MakeMove applies the given move to the receiver in place, and returns a
+// record which can be passed to UnmakeMove to take the move back. Like
+// ApplyMove, it does not validate that the move is legal.
+//
+// Unlike ApplyMove, the state of the game is not determined until GameState
+// is called, since that requires searching for a legal move. Games which are
+// over because of the midline or the fifty-move rule have no legal moves in
+// the meantime.
+func (g *Game) MakeMove(move Move) Undo {
+	undo := Undo{
+		move:           move,
+		castlingRook:   InvalidSquare,
+		castlingRights: g.castlingRights,
+		stones:         g.stones,
+		reserves:       g.reserves,
+		toMove:         g.toMove,
+		kingTurn:       g.kingTurn,
+		gameState:      g.gameState,
+		endReason:      g.endReason,
+		gameStateStale: g.gameStateStale,
+		halfmoveClock:  g.halfmoveClock,
+		fullmoveNumber: g.fullmoveNumber,
+		epSquare:       g.epSquare,
+		hash:           g.hash,
+	}
+	if !move.IsDrop() && !move.IsPass() {
+		undo.moved, _ = g.board.PieceAt(move.From)
+	}
+	g.makeMove(move, &undo)
+	g.gameState = GameInProgress
+	g.endReason = EndReasonNone
+	g.gameStateStale = true
+	return undo
+}
+
+// UnmakeMove takes back the move that returned the given record. Moves must be
+// taken back in the reverse of the order that they were made.
+func (g *Game) UnmakeMove(undo Undo) {
+	move := undo.move
+	switch {
+	case move.IsDrop():
+		g.board.ClearPieceAt(move.To)
+	case move.IsPass():
+	default:
+		if undo.placed {
+			g.board.ClearPieceAt(move.To)
+		}
+		if undo.castlingRook != InvalidSquare {
+			// Move the king and rook back when castling
+			kingTo, rookTo := castlingSquares(move.From, undo.castlingRook)
+			rook, _ := g.board.PieceAt(rookTo)
+			g.board.ClearPieceAt(kingTo)
+			g.board.ClearPieceAt(rookTo)
+			g.board.SetPieceAt(undo.castlingRook, rook)
+		}
+		g.board.SetPieceAt(move.From, undo.moved)
+		for i := undo.numCaptured - 1; i >= 0; i-- {
+			g.board.SetPieceAt(undo.captured[i].square, undo.captured[i].piece)
+		}
+	}
+
+	g.castlingRights = undo.castlingRights
+	g.stones = undo.stones
+	g.reserves = undo.reserves
+	g.toMove = undo.toMove
+	g.kingTurn = undo.kingTurn
+	g.gameState = undo.gameState
+	g.endReason = undo.endReason
+	g.gameStateStale = undo.gameStateStale
+	g.halfmoveClock = undo.halfmoveClock
+	g.fullmoveNumber = undo.fullmoveNumber
+	g.epSquare = undo.epSquare
+	g.hash = undo.hash
+}
@@ -0,0 +1,104 @@
+package chess2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeUnmakeMove(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals}
+	for _, white := range armies {
+		for _, black := range armies {
+			game := GameFromArmies(white, black)
+			for ply := 0; ply < 40 && game.GameState() == GameInProgress; ply++ {
+				before := EncodeEpd(game)
+				hash := game.Hash()
+				moves := game.GenerateLegalMoves()
+				for _, move := range moves {
+					for _, candidate := range append(game.GenerateDuels(move), move) {
+						expected := game.ApplyMove(candidate)
+						undo := game.MakeMove(candidate)
+						require.Equal(t, EncodeEpd(expected), EncodeEpd(game), "EPD: %s  Move: %s", before, candidate)
+						require.Equal(t, expected.Hash(), game.Hash(), "EPD: %s  Move: %s", before, candidate)
+						require.Equal(t, expected.GameState(), game.GameState(), "EPD: %s  Move: %s", before, candidate)
+						require.Equal(t, expected.Result(), game.Result(), "EPD: %s  Move: %s", before, candidate)
+						game.UnmakeMove(undo)
+						require.Equal(t, before, EncodeEpd(game), "Move: %s", candidate)
+						require.Equal(t, hash, game.Hash(), "EPD: %s  Move: %s", before, candidate)
+					}
+				}
+				game.MakeMove(moves[rng.Intn(len(moves))])
+			}
+		}
+	}
+}
+
+func TestMakeUnmakeDrops(t *testing.T) {
+	game, err := ParseEpd("4k3/8/8/8/8/8/4b3/4K1N1[p] w - - 0 1 cc 33")
+	require.NoError(t, err)
+	var undos []Undo
+	for _, uci := range []string{"g1e2:10+", "n@f3", "e1f2", "p@e3"} {
+		move, err := ParseUci(uci)
+		require.NoError(t, err)
+		require.NoError(t, game.ValidateLegalMove(move), "Move: %s", uci)
+		expected := game.ApplyMove(move)
+		undos = append(undos, game.MakeMove(move))
+		require.Equal(t, EncodeEpd(expected), EncodeEpd(game), "Move: %s", uci)
+		require.Equal(t, expected.Hash(), game.Hash(), "Move: %s", uci)
+	}
+	assert.Equal(t, "4k3/8/8/8/8/4pn2/5K2/8[B] w - - 3 3 cc 32", EncodeEpd(game))
+	for i := len(undos) - 1; i >= 0; i-- {
+		game.UnmakeMove(undos[i])
+	}
+	assert.Equal(t, "4k3/8/8/8/8/8/4b3/4K1N1[p] w - - 0 1 cc 33", EncodeEpd(game))
+	assert.Equal(t, game.computeHash(), game.Hash())
+}
+
+func TestMakeMoveGameState(t *testing.T) {
+	cases := map[string]struct {
+		epd   string
+		move  string
+		state GameState
+	}{
+		"in progress": {
+			epd:   "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+			move:  "e1e2",
+			state: GameInProgress,
+		},
+		"midline": {
+			epd:   "8/8/4k3/8/4K3/8/8/8 w - - 0 1 cc 33",
+			move:  "e4d5",
+			state: GameOverWhite,
+		},
+		"fifty move rule": {
+			epd:   "4k3/8/8/8/8/8/8/4K3 w - - 49 1 cc 33",
+			move:  "e1e2",
+			state: GameOverDraw,
+		},
+		"checkmate": {
+			epd:   "k7/7R/8/8/8/8/8/K5R1 w - - 0 1 cc 33",
+			move:  "g1g8",
+			state: GameOverWhite,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			move, err := ParseUci(config.move)
+			require.NoError(t, err, "Move: %s  Name: %s", config.move, name)
+			undo := game.MakeMove(move)
+			if config.state != GameInProgress {
+				assert.Empty(t, game.GenerateLegalMoves(), "Case: %s", name)
+			}
+			assert.Equal(t, config.state, game.GameState(), "Case: %s", name)
+			game.UnmakeMove(undo)
+			assert.Equal(t, GameInProgress, game.GameState(), "Case: %s", name)
+			assert.Equal(t, config.epd, EncodeEpd(game), "Case: %s", name)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package chess2
+
+// Generates every pseudo-legal move without duels from the current game state,
+// along with the piece which moves. Most moves are known to be pseudo-legal
+// from the way that they are generated, so ValidatePseudoLegalMove does not
+// need to be called on them. Each move will only be sent once.
+func (g *Game) generatePseudoLegalMoves(send func(Move, Piece)) {
+	if g.isGameOver() {
+		return
+	}
+	fromMask := g.board.colorMask(g.toMove)
+	if g.kingTurn {
+		fromMask &= g.board.pieceMask(TypeKing)
+		send(MovePass, InvalidPiece)
+	}
+	eachSquareInMask(fromMask, func(from Square) {
+		g.generatePseudoLegalMovesFrom(from, send)
+	})
+	if g.flags&GameFlagDrops != 0 && !g.kingTurn {
+		g.generateDrops(send)
+	}
+}
+
+// Generates every drop of a piece from the reserve of the player to move. The
+// dropped pieces are in the Classic army, like the ones from ParseUci.
+func (g *Game) generateDrops(send func(Move, Piece)) {
+	colorIdx := ColorIdx(g.toMove)
+	empty := ^g.board.occupiedMask()
+	for idx, count := range g.reserves[colorIdx] {
+		t := idxPieceType(idx)
+		if count == 0 || !g.canDrop(colorIdx, t) {
+			continue
+		}
+		targets := empty
+		if t == TypePawn {
+			targets &^= maskRank[0] | maskRank[7]
+		}
+		piece := NewPiece(t, ArmyClassic, g.toMove)
+		eachSquareInMask(targets, func(to Square) {
+			send(Move{From: InvalidSquare, To: to, Piece: piece}, piece.WithArmy(g.armies[colorIdx]))
+		})
+	}
+}
+
+// Generates every pseudo-legal move without duels originating from the given
+// square.
+func (g *Game) generatePseudoLegalMovesFrom(from Square, send func(Move, Piece)) {
+	piece, _ := g.board.PieceAt(from)
+	piece = piece.WithArmy(g.armies[ColorIdx(piece.Color())])
+	rules := piece.rules()
+	switch {
+	case piece.Type() == TypePawn:
+		g.generatePawnMoves(from, piece, send)
+		return
+	case rules.rampage:
+		// Rampages have enough special cases that each one is validated.
+		eachSquareInMask(g.attackMask(from), func(to Square) {
+			move := Move{From: from, To: to}
+			if g.ValidatePseudoLegalMove(move) == nil {
+				send(move, piece)
+			}
+		})
+		return
+	}
+
+	// Every other piece moves along its attackMask, which only reaches past
+	// empty squares, so only the target square needs to be capturable.
+	targets := g.attackMask(from) &^ g.noncapturableMask(piece, from) &^ from.mask()
+	eachSquareInMask(targets, func(to Square) {
+		send(Move{From: from, To: to}, piece)
+	})
+
+	if rules.castles {
+		// Castling
+		eachSquareInMask(g.castlingRights&maskRank[from.Y()], func(rook Square) {
+			move := castlingMove(from, rook)
+			if g.ValidatePseudoLegalMove(move) == nil {
+				send(move, piece)
+			}
+		})
+	}
+	if rules.whirlwind && g.kingTurn {
+		// Whirlwind attack
+		ownKings := g.board.pieceMask(TypeKing) & g.board.colorMask(piece.Color())
+		if dist1Mask[from.Address]&ownKings == 0 {
+			send(Move{From: from, To: from}, piece)
+		}
+	}
+}
+
+// Generates every pseudo-legal move without duels for the pawn on the given
+// square, enumerating all of the possible promotions.
+func (g *Game) generatePawnMoves(from Square, piece Piece, send func(Move, Piece)) {
+	colorIdx := ColorIdx(piece.Color())
+	rules := piece.rules()
+	occupied := g.board.occupiedMask()
+	attacks := g.attackMask(from)
+
+	// Captures, including en passant
+	targets := attacks & occupied &^ g.noncapturableMask(piece, from)
+	if g.epSquare != InvalidSquare {
+		targets |= attacks & g.epSquare.mask()
+	}
+	// Advances
+	sign := colorIdx*2 - 1
+	if y := from.Y() + sign; y >= 0 && y < 8 {
+		forward := SquareFromCoords(from.X(), y)
+		if forward.mask()&occupied == 0 {
+			targets |= forward.mask()
+			// targetRank = 4 for white, 3 for black
+			if rules.doubleStep && y+sign == 4-colorIdx {
+				targets |= SquareFromCoords(from.X(), y+sign).mask() &^ occupied
+			}
+		}
+	}
+	if rules.towardKings {
+		enemyKings := g.board.pieceMask(TypeKing) & g.board.colorMask(OtherColor(piece.Color()))
+		targets |= singleStepMask(from, enemyKings) &^ occupied
+	}
+
+	lastRank := maskRank[7*colorIdx]
+	eachSquareInMask(targets, func(to Square) {
+		move := Move{From: from, To: to}
+		if to.mask()&lastRank == 0 {
+			send(move, piece)
+			return
+		}
+		for _, promotion := range g.armyRules(colorIdx).promotions {
+			move.Piece = NewPiece(promotion, ArmyNone, ColorWhite)
+			send(move, piece)
+		}
+	})
+}
+
+// noncapturableMask returns the mask of pieces that cannot be captured by the
+// given piece moving from the given square. In the case of an elephant, this
+// is the mask of pieces that can stop a rampage.
+func (g *Game) noncapturableMask(piece Piece, from Square) uint64 {
+	mask := g.protectedMask(piece, from)
+	rules := piece.rules()
+	if rules.rampage {
+		// Rampages trample their own pieces, other than kings, which are
+		// checked by ValidatePseudoLegalMove.
+	} else if rules.capturesOwn {
+		// Cannot capture own king
+		mask |= g.board.colorMask(piece.Color()) & g.board.pieceMask(TypeKing)
+	} else {
+		// Cannot capture own pieces
+		mask |= g.board.colorMask(piece.Color())
+	}
+	return mask
+}
+
+// A legalityFilter decides which pseudo-legal moves are legal in a game state.
+// A move which doesn't involve a king can only expose one by moving a piece
+// from between the king and an enemy piece, so other moves are accepted
+// without making them.
+type legalityFilter struct {
+	game    *Game
+	inCheck bool
+	// Moves from these squares are made to see if they expose a king.
+	unsafeFrom uint64
+	// Moves to these squares are made to see if they expose a king. Pieces
+	// in front of or behind a king can change whether an Elephant's rampage
+	// is legal, so this is only needed against armies which rampage.
+	unsafeTo uint64
+}
+
+func (g *Game) newLegalityFilter() legalityFilter {
+	f := legalityFilter{game: g, inCheck: g.IsInCheck(g.toMove)}
+	kings := g.board.pieceMask(TypeKing) & g.board.colorMask(g.toMove)
+	enemies := g.board.colorMask(OtherColor(g.toMove))
+	enemyArmy := g.armyRules(1 - ColorIdx(g.toMove))
+	elephants := len(enemyArmy.rampagers) > 0
+	if enemyArmy.complexThreats {
+		f.unsafeFrom, f.unsafeTo = maskFull, maskFull
+		return f
+	}
+	eachSquareInMask(kings, func(king Square) {
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				if dx == 0 && dy == 0 {
+					continue
+				}
+				ray := maskEmpty
+				for x, y := king.X()+dx, king.Y()+dy; x >= 0 && x < 8 && y >= 0 && y < 8; x, y = x+dx, y+dy {
+					sq := SquareFromCoords(x, y)
+					if sq.mask()&enemies != 0 {
+						f.unsafeFrom |= ray
+					}
+					ray |= sq.mask()
+				}
+				if elephants {
+					f.unsafeFrom |= ray
+					f.unsafeTo |= ray
+				}
+			}
+		}
+	})
+	return f
+}
+
+// Returns true if the given pseudo-legal move, made by the given piece, is
+// legal.
+func (f *legalityFilter) isLegal(move Move, piece Piece) bool {
+	g := f.game
+	if move.IsPass() {
+		return !f.inCheck
+	}
+	rules := piece.rules()
+	obviouslySafe := !f.inCheck &&
+		piece.Type() != TypeKing &&
+		!rules.rampage &&
+		move.From.mask()&f.unsafeFrom == 0 &&
+		move.To.mask()&f.unsafeTo == 0 &&
+		// En passant and the Tiger's capture remove a piece from a square
+		// that the moving piece doesn't move to.
+		!(piece.Type() == TypePawn && move.To == g.epSquare) &&
+		!(rules.staysOnCapture && move.To.mask()&g.board.occupiedMask() != 0)
+	if obviouslySafe {
+		return true
+	}
+	clone := *g
+	clone.applyMove(move)
+	return !clone.IsInCheck(g.toMove)
+}
@@ -0,0 +1,111 @@
+package chess2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Checks the move generator against the validators on every possible move.
+func checkMoveGeneration(t *testing.T, game Game) {
+	var pseudoLegal, legal, generated []Move
+	BruteforceMoveList(func(move Move) {
+		if game.ValidatePseudoLegalMove(move) == nil {
+			pseudoLegal = append(pseudoLegal, move)
+			if game.ValidateLegalMove(move) == nil {
+				legal = append(legal, move)
+			}
+		}
+	})
+	game.generatePseudoLegalMoves(func(move Move, piece Piece) {
+		generated = append(generated, move)
+	})
+	epd := EncodeEpd(game)
+	require.ElementsMatch(t, pseudoLegal, generated, "EPD: %s", epd)
+	require.ElementsMatch(t, legal, game.GenerateLegalMoves(), "EPD: %s", epd)
+}
+
+func TestGeneratePseudoLegalMoves(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals}
+	for _, white := range armies {
+		for _, black := range armies {
+			game := GameFromArmies(white, black)
+			for ply := 0; ply < 30 && game.GameState() == GameInProgress; ply++ {
+				checkMoveGeneration(t, game)
+				moves := game.GenerateLegalMoves()
+				game = game.ApplyMove(moves[rng.Intn(len(moves))])
+			}
+		}
+	}
+}
+
+func TestGenerateDrops(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals}
+	for _, white := range armies {
+		for _, black := range armies {
+			game := GameFromArmies(white, black)
+			game.flags |= GameFlagDrops
+			for ply := 0; ply < 40 && game.GameState() == GameInProgress; ply++ {
+				checkMoveGeneration(t, game)
+				for _, move := range game.GenerateLegalMoves() {
+					if move.IsDrop() {
+						parsed, err := ParseUci(move.String())
+						require.NoError(t, err)
+						require.Equal(t, move, parsed, "EPD: %s", EncodeEpd(game))
+					}
+				}
+				moves := game.GenerateLegalMoves()
+				move := moves[rng.Intn(len(moves))]
+				if duels := game.GenerateDuels(move); len(duels) > 0 {
+					move = duels[rng.Intn(len(duels))]
+				}
+				game = game.ApplyMove(move)
+				require.Equal(t, game.computeHash(), game.Hash(), "EPD: %s", EncodeEpd(game))
+			}
+		}
+	}
+}
+
+func TestGenerateLegalMoves(t *testing.T) {
+	cases := map[string]struct {
+		epd   string
+		moves int
+	}{
+		"pinned piece": {
+			epd:   "4k3/8/8/8/4r3/8/4B3/4K3 w - - 0 1 cc 33",
+			moves: 4,
+		},
+		"en passant exposes king": {
+			epd:   "8/6k1/8/8/3Pp3/8/1B6/4K3 b - d3 0 1 cc 33",
+			moves: 9,
+		},
+		"tiger capture exposes king": {
+			epd:   "4k3/8/8/8/8/B7/8/r1n1K3 w - - 0 1 ac 33",
+			moves: 7,
+		},
+		"elephant tramples blocker": {
+			epd:   "4k3/8/8/8/8/4r3/8/2N1K3 w - - 0 1 ca 33",
+			moves: 4,
+		},
+		"king-turn pass in check": {
+			epd:   "4k3/8/8/8/8/8/7K/r2K4 K - - 0 1 kc 33",
+			moves: 3,
+		},
+		"drop blocks check": {
+			epd:   "4k3/8/8/8/8/8/8/r3K3[Rp] w - - 0 1 cc 33",
+			moves: 6,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			checkMoveGeneration(t, game)
+			assert.Len(t, game.GenerateLegalMoves(), config.moves, "Case: %s", name)
+		})
+	}
+}
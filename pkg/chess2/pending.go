@@ -0,0 +1,173 @@
+package chess2
+
+// DuelPhase describes which decision a PendingMove is waiting for.
+type DuelPhase int
+
+const (
+	// DuelPhaseComplete means that all duels have been decided and the move
+	// can be applied.
+	DuelPhaseComplete = DuelPhase(iota)
+	// DuelPhaseChallenge means that the defender must decide whether to
+	// challenge the current capture, and how many stones to bid.
+	DuelPhaseChallenge
+	// DuelPhaseResponse means that the attacker must respond to the defender's
+	// challenge with a secret bid.
+	DuelPhaseResponse
+)
+
+func (p DuelPhase) String() string {
+	switch p {
+	case DuelPhaseComplete:
+		return "complete"
+	case DuelPhaseChallenge:
+		return "challenge"
+	case DuelPhaseResponse:
+		return "response"
+	default:
+		return "invalid"
+	}
+}
+
+// A PendingMove is a legal move whose duels are still being decided. Each
+// capture that can be dueled is resolved in order: the defender issues a
+// challenge (or declines to), then the attacker responds. Captures which can't
+// be dueled, or which the defender can't afford to challenge, are skipped
+// automatically. Once every duel is decided, Apply finalizes the move.
+type PendingMove struct {
+	game  Game
+	move  Move
+	index int
+	phase DuelPhase
+}
+
+// NewPendingMove begins resolving the duels of the given move. The move must
+// be legal and must not have any duels started.
+func NewPendingMove(game Game, move Move) (*PendingMove, error) {
+	if err := validateNoDuels(move, TooManyDuelsError); err != nil {
+		return nil, err
+	}
+	if err := game.ValidateLegalMove(move); err != nil {
+		return nil, err
+	}
+	p := &PendingMove{game: game, move: move}
+	p.advance(0)
+	return p, nil
+}
+
+// Game returns the game position before the move.
+func (p *PendingMove) Game() Game {
+	return p.game
+}
+
+// Move returns the move with the duels decided so far. While a response is
+// pending, the move contains the defender's secret challenge, so it must not
+// be shown to the attacker.
+func (p *PendingMove) Move() Move {
+	return p.move
+}
+
+// Phase returns the decision that the PendingMove is waiting for.
+func (p *PendingMove) Phase() DuelPhase {
+	return p.phase
+}
+
+// Index returns the index of the duel currently being decided.
+func (p *PendingMove) Index() int {
+	return p.index
+}
+
+// Opportunity returns the capture currently being dueled. It must not be
+// called once the move is complete.
+func (p *PendingMove) Opportunity() DuelOpportunity {
+	return p.game.DuelOpportunities(p.move)[p.index]
+}
+
+// AvailableBids returns the bids that are allowed for the current phase. A
+// defender may additionally decline to challenge.
+func (p *PendingMove) AvailableBids() []int {
+	var results []int
+	for bid := 0; bid <= 2; bid++ {
+		if p.validBid(bid) {
+			results = append(results, bid)
+		}
+	}
+	return results
+}
+
+func (p *PendingMove) validBid(bid int) bool {
+	if bid < 0 || bid > 2 {
+		return false
+	}
+	candidate := p.move
+	switch p.phase {
+	case DuelPhaseChallenge:
+		candidate.Duels[p.index] = DuelWithChallenge(bid)
+	case DuelPhaseResponse:
+		candidate.Duels[p.index] = DuelWithResponse(p.move.Duels[p.index], bid, true)
+	default:
+		return false
+	}
+	return p.game.ValidateDuels(candidate) == nil
+}
+
+// Decline records that the defender does not challenge the current capture.
+func (p *PendingMove) Decline() error {
+	if p.phase != DuelPhaseChallenge {
+		return DuelOutOfTurnError
+	}
+	p.advance(p.index + 1)
+	return nil
+}
+
+// Challenge records the defender's bid for the current capture.
+func (p *PendingMove) Challenge(bid int) error {
+	if p.phase != DuelPhaseChallenge {
+		return DuelOutOfTurnError
+	} else if !p.validBid(bid) {
+		return NotEnoughStonesError
+	}
+	p.move.Duels[p.index] = DuelWithChallenge(bid)
+	p.phase = DuelPhaseResponse
+	return nil
+}
+
+// Respond records the attacker's bid for the current capture. Gain is only
+// meaningful when both players bid 0, and indicates that the attacker gains a
+// stone rather than the defender losing one.
+func (p *PendingMove) Respond(bid int, gain bool) error {
+	if p.phase != DuelPhaseResponse {
+		return DuelOutOfTurnError
+	} else if !p.validBid(bid) {
+		return NotEnoughStonesError
+	}
+	p.move.Duels[p.index] = DuelWithResponse(p.move.Duels[p.index], bid, gain)
+	p.advance(p.index + 1)
+	return nil
+}
+
+// Apply returns the game after the move has been made. It fails if there are
+// still duels to decide.
+func (p *PendingMove) Apply() (Game, error) {
+	if p.phase != DuelPhaseComplete {
+		return Game{}, DuelOutOfTurnError
+	}
+	return p.game.ApplyMove(p.move), nil
+}
+
+// Moves forward to the next capture, starting at the given index, which the
+// defender is able to challenge.
+func (p *PendingMove) advance(index int) {
+	opportunities := p.game.DuelOpportunities(p.move)
+	for ; index < len(opportunities) && index < len(p.move.Duels); index++ {
+		if !opportunities[index].IsDuelable() {
+			continue
+		}
+		p.index = index
+		p.phase = DuelPhaseChallenge
+		if len(p.AvailableBids()) > 0 {
+			return
+		}
+	}
+	p.index = index
+	p.phase = DuelPhaseComplete
+}
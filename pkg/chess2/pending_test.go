@@ -0,0 +1,105 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingMove(t *testing.T) {
+	game, err := ParseEpd("4k3/Rppp4/8/8/8/8/8/4K3 w - - 0 1 ac 23")
+	require.NoError(t, err)
+	move, err := ParseUci("a7d7")
+	require.NoError(t, err)
+	pending, err := NewPendingMove(game, move)
+	require.NoError(t, err)
+
+	assert.Equal(t, DuelPhaseChallenge, pending.Phase())
+	assert.Equal(t, 0, pending.Index())
+	assert.Equal(t, "b7", pending.Opportunity().Square.String())
+	assert.Equal(t, []int{0, 1, 2}, pending.AvailableBids())
+	assert.Equal(t, DuelOutOfTurnError, pending.Respond(0, true))
+	_, err = pending.Apply()
+	assert.Equal(t, DuelOutOfTurnError, err)
+
+	// First pawn: declined
+	require.NoError(t, pending.Decline())
+	assert.Equal(t, DuelPhaseChallenge, pending.Phase())
+	assert.Equal(t, 1, pending.Index())
+
+	// Second pawn: challenged and won by the attacker
+	require.NoError(t, pending.Challenge(1))
+	assert.Equal(t, DuelPhaseResponse, pending.Phase())
+	assert.Equal(t, DuelOutOfTurnError, pending.Challenge(1))
+	require.NoError(t, pending.Respond(2, false))
+	assert.Equal(t, DuelPhaseChallenge, pending.Phase())
+	assert.Equal(t, 2, pending.Index())
+
+	// Third pawn: challenged and won by the defender
+	assert.Equal(t, []int{0, 1, 2}, pending.AvailableBids())
+	assert.Equal(t, NotEnoughStonesError, pending.Challenge(3))
+	require.NoError(t, pending.Challenge(2))
+	require.NoError(t, pending.Respond(1, false))
+	assert.Equal(t, DuelPhaseComplete, pending.Phase())
+
+	assert.Equal(t, "a7d7::12:21", pending.Move().String())
+	next, err := pending.Apply()
+	require.NoError(t, err)
+	assert.Equal(t, "4k3/8/8/8/8/8/8/4K3 b - - 0 1 ac 20", EncodeEpd(next))
+}
+
+func TestPendingMoveSkipsDuels(t *testing.T) {
+	cases := map[string]struct {
+		epd   string
+		move  string
+		phase DuelPhase
+		err   error
+	}{
+		"no capture": {
+			epd:   "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 33",
+			move:  "d4d5",
+			phase: DuelPhaseComplete,
+		},
+		"capture by king": {
+			epd:   "4k3/8/8/8/8/8/4p3/4K3 w - - 0 1 cc 33",
+			move:  "e1e2",
+			phase: DuelPhaseComplete,
+		},
+		"defender without stones": {
+			epd:   "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 30",
+			move:  "d4e5",
+			phase: DuelPhaseChallenge,
+		},
+		"attacker cannot pay rank": {
+			epd:   "4k3/8/8/4b3/3P4/8/8/4K3 w - - 0 1 cc 03",
+			move:  "d4e5",
+			phase: DuelPhaseComplete,
+		},
+		"duels already chosen": {
+			epd:  "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 33",
+			move: "d4e5:1",
+			err:  TooManyDuelsError,
+		},
+		"illegal move": {
+			epd:  "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 33",
+			move: "d4f5",
+			err:  UnreachableSquareError,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			move, err := ParseUci(config.move)
+			require.NoError(t, err, "Move: %s  Name: %s", config.move, name)
+			pending, err := NewPendingMove(game, move)
+			if config.err != nil {
+				assert.Equal(t, config.err, err, "Case: %s", name)
+				return
+			}
+			require.NoError(t, err, "Case: %s", name)
+			assert.Equal(t, config.phase, pending.Phase(), "Case: %s", name)
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package chess2
 
+import "sync"
+
 var promotions = []PieceType{TypeQueen, TypeRook, TypeBishop, TypeKnight}
 
-// BruteforceMoveList calls the given function once for each possible move.
-// Drop moves are not emitted, but passes are.
+// perftShards is the number of independently locked parts of a PerftTable.
+const perftShards = 64
+
+// BruteforceMoveList calls the given function once for each possible move,
+// including passes and drops of every piece other than a king.
 func BruteforceMoveList(send func(Move)) {
 	for from := uint8(0); from < 64; from++ {
 		for to := uint8(0); to < 64; to++ {
@@ -22,49 +27,261 @@ func BruteforceMoveList(send func(Move)) {
 		}
 	}
 	send(MovePass)
+	for to := uint8(0); to < 64; to++ {
+		for _, color := range []Color{ColorWhite, ColorBlack} {
+			for _, t := range []PieceType{TypeQueen, TypeRook, TypeBishop, TypeKnight, TypePawn} {
+				send(Move{
+					From:  InvalidSquare,
+					To:    Square{Address: to},
+					Piece: NewPiece(t, ArmyClassic, color),
+				})
+			}
+		}
+	}
+}
+
+// PerftOptions controls how PerftWithOptions counts moves.
+type PerftOptions struct {
+	// Threads is the number of goroutines that the moves from the root are
+	// divided among. Values less than 2 count the moves on the calling
+	// goroutine.
+	Threads int
+	// Table, if not nil, is used to avoid counting the moves from positions
+	// which are reached more than once. The same table may be shared by many
+	// calls.
+	Table *PerftTable
+	// Bruteforce generates the moves as PerftBruteforce does.
+	Bruteforce bool
+	// Duels counts every legal combination of duels for each capture, as
+	// returned by GenerateDuels, as a separate move. Each bluff which is called
+	// is counted twice: once where the attacker gains a stone, and once where
+	// the defender loses one.
+	Duels bool
+}
+
+// A perfter holds the options for a single call to PerftWithOptions.
+type perfter struct {
+	table    *PerftTable
+	duels    bool
+	getMoves func(*Game) []Move
 }
 
 // Perft returns the number of valid sequences of moves of length depth from the
-// given game. Challenges are never issued while counting moves.
+// given game. Challenges are never issued while counting moves; see
+// PerftOptions.Duels.
 func Perft(game Game, depth int) []uint64 {
-	if depth <= 0 {
-		return make([]uint64, 0)
-	}
-	results := make([]uint64, depth)
-	doPerft(game, depth, results, func(g Game) []Move {
-		return g.GenerateLegalMoves()
-	})
-	return results
+	return PerftWithOptions(game, depth, PerftOptions{})
 }
 
 // PerftBruteforce is similar to Perft, except that it generates the moves by
 // trying every possible square combination rather than using the (much faster)
 // move generator. It is useful for testing the move generator.
 func PerftBruteforce(game Game, depth int) []uint64 {
+	return PerftWithOptions(game, depth, PerftOptions{Bruteforce: true})
+}
+
+// PerftWithOptions is Perft, with the given options. Threads and Table only
+// change how the moves are counted. Bruteforce changes which move generator is
+// used, which gives the same results unless the move generator has a bug, and
+// Duels changes what is counted as a move.
+func PerftWithOptions(game Game, depth int, options PerftOptions) []uint64 {
 	if depth <= 0 {
 		return make([]uint64, 0)
 	}
+	p := newPerfter(options)
 	results := make([]uint64, depth)
-	doPerft(game, depth, results, func(g Game) []Move {
-		moves := make([]Move, 0, 64)
-		BruteforceMoveList(func(candidate Move) {
-			if err := g.ValidateLegalMove(candidate); err == nil {
-				moves = append(moves, candidate)
+	if options.Threads < 2 || depth == 1 {
+		p.doPerft(&game, results)
+		return results
+	}
+
+	moves := p.getMoves(&game)
+	results[0] = uint64(len(moves))
+	work := make(chan Move)
+	partials := make([][]uint64, options.Threads)
+	var wg sync.WaitGroup
+	for i := range partials {
+		partials[i] = make([]uint64, depth-1)
+		wg.Add(1)
+		go func(partial []uint64) {
+			defer wg.Done()
+			for move := range work {
+				child := game
+				child.MakeMove(move)
+				p.doPerft(&child, partial)
 			}
-		})
-		return moves
+		}(partials[i])
+	}
+	for _, move := range moves {
+		work <- move
+	}
+	close(work)
+	wg.Wait()
+	for _, partial := range partials {
+		for i, count := range partial {
+			results[i+1] += count
+		}
+	}
+	return results
+}
+
+// PerftMoves returns the moves from the given game which PerftWithOptions
+// counts at the first ply, in the order that they are counted.
+func PerftMoves(game Game, options PerftOptions) []Move {
+	return newPerfter(options).getMoves(&game)
+}
+
+func newPerfter(options PerftOptions) *perfter {
+	p := &perfter{table: options.Table, duels: options.Duels}
+	p.getMoves = func(g *Game) []Move {
+		return g.GenerateLegalMoves()
+	}
+	if options.Bruteforce {
+		p.getMoves = bruteforceLegalMoves
+	}
+	if options.Duels {
+		getMoves := p.getMoves
+		p.getMoves = func(g *Game) []Move {
+			return expandDuels(g, getMoves(g))
+		}
+	}
+	return p
+}
+
+func bruteforceLegalMoves(g *Game) []Move {
+	moves := make([]Move, 0, 64)
+	BruteforceMoveList(func(candidate Move) {
+		if err := g.ValidateLegalMove(candidate); err == nil {
+			moves = append(moves, candidate)
+		}
 	})
+	return moves
+}
+
+// Replaces each capture in the given moves with every combination of duels
+// that can be made with it, including both ways of calling a bluff.
+func expandDuels(g *Game, moves []Move) []Move {
+	results := make([]Move, 0, len(moves))
+	for _, move := range moves {
+		for _, duels := range g.GenerateDuels(move) {
+			results = appendBluffs(results, duels, 0)
+		}
+	}
+	return results
+}
+
+// Appends the move, along with every variant of it where the bluffs called in
+// the duels from index i onwards make the defender lose a stone.
+func appendBluffs(results []Move, move Move, i int) []Move {
+	if i == len(move.Duels) {
+		return append(results, move)
+	}
+	results = appendBluffs(results, move, i+1)
+	if d := move.Duels[i]; d.IsStarted() && d.Challenge() == 0 && d.Response() == 0 {
+		move.Duels[i] = NewDuel(0, 0, false)
+		results = appendBluffs(results, move, i+1)
+	}
 	return results
 }
 
-func doPerft(game Game, depth int, results []uint64, getMoves func(Game) []Move) {
-	moves := getMoves(game)
-	results[len(results)-depth] += uint64(len(moves))
-	if depth == 1 {
+// Adds the number of sequences of moves of each length from the given game to
+// results, which has one element for each ply.
+func (p *perfter) doPerft(game *Game, results []uint64) {
+	if p.table == nil || len(results) == 1 {
+		p.expand(game, results)
+		return
+	}
+	counts := p.table.lookup(game, len(results), p.duels)
+	if counts == nil {
+		counts = make([]uint64, len(results))
+		p.expand(game, counts)
+		p.table.store(game, counts, p.duels)
+	}
+	for i, count := range counts {
+		results[i] += count
+	}
+}
+
+func (p *perfter) expand(game *Game, results []uint64) {
+	moves := p.getMoves(game)
+	results[0] += uint64(len(moves))
+	if len(results) == 1 {
 		return
 	}
 	for _, move := range moves {
-		child := game.ApplyMove(move)
-		doPerft(child, depth-1, results, getMoves)
+		undo := game.MakeMove(move)
+		p.doPerft(game, results[1:])
+		game.UnmakeMove(undo)
+	}
+}
+
+// A PerftTable remembers the results of Perft for positions which have already
+// been counted. It is safe for concurrent use.
+type PerftTable struct {
+	shards [perftShards]perftShard
+}
+
+type perftShard struct {
+	sync.Mutex
+	entries []perftEntry
+}
+
+type perftEntry struct {
+	// The position is identified by the Zobrist key, plus the parts of the
+	// game which affect the moves but are not part of the key.
+	key           uint64
+	flags         GameFlags
+	halfmoveClock int
+	duels         bool
+	// counts has one element for each ply that was counted.
+	counts []uint64
+}
+
+// NewPerftTable creates a PerftTable that holds about the given number of
+// positions.
+func NewPerftTable(size int) *PerftTable {
+	t := &PerftTable{}
+	shardSize := (size + perftShards - 1) / perftShards
+	if shardSize < 1 {
+		shardSize = 1
+	}
+	for i := range t.shards {
+		t.shards[i].entries = make([]perftEntry, shardSize)
+	}
+	return t
+}
+
+// Returns the entry where the given game is stored, and locks its shard.
+func (t *PerftTable) entry(game *Game) (*perftShard, *perftEntry) {
+	hash := game.Hash()
+	shard := &t.shards[hash%perftShards]
+	shard.Lock()
+	return shard, &shard.entries[(hash/perftShards)%uint64(len(shard.entries))]
+}
+
+// Returns the counts for the given game and depth, or nil if they aren't
+// known. The returned slice must not be modified.
+func (t *PerftTable) lookup(game *Game, depth int, duels bool) []uint64 {
+	shard, entry := t.entry(game)
+	defer shard.Unlock()
+	if entry.key != game.Hash() ||
+		entry.flags != game.flags ||
+		entry.halfmoveClock != game.halfmoveClock ||
+		entry.duels != duels ||
+		len(entry.counts) != depth {
+		return nil
+	}
+	return entry.counts
+}
+
+func (t *PerftTable) store(game *Game, counts []uint64, duels bool) {
+	shard, entry := t.entry(game)
+	defer shard.Unlock()
+	*entry = perftEntry{
+		key:           game.Hash(),
+		flags:         game.flags,
+		halfmoveClock: game.halfmoveClock,
+		duels:         duels,
+		counts:        counts,
 	}
 }
@@ -0,0 +1,87 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerftWithOptions(t *testing.T) {
+	positions := map[string]struct {
+		epd    string
+		depth  int
+		counts []uint64
+	}{
+		"classic": {
+			epd:    "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			depth:  3,
+			counts: []uint64{20, 400, 8902},
+		},
+		"fifty move rule": {
+			epd:    "4k3/8/8/8/8/8/8/4K2R w K - 48 30 cc 33",
+			depth:  3,
+			counts: []uint64{15, 66, 0},
+		},
+	}
+	options := map[string]PerftOptions{
+		"threads":          {Threads: 4},
+		"hash":             {Table: NewPerftTable(1 << 10)},
+		"tiny hash":        {Table: NewPerftTable(1)},
+		"threads and hash": {Threads: 4, Table: NewPerftTable(1 << 10)},
+	}
+	for name, position := range positions {
+		game, err := ParseEpd(position.epd)
+		require.NoError(t, err, "EPD: %s  Name: %s", position.epd, name)
+		assert.Equal(t, position.counts, Perft(game, position.depth), "Case: %s", name)
+		for optionsName, options := range options {
+			result := PerftWithOptions(game, position.depth, options)
+			assert.Equal(t, position.counts, result, "Case: %s  Options: %s", name, optionsName)
+		}
+	}
+}
+
+func TestPerftTableSharedAcrossVariants(t *testing.T) {
+	epd := "8/8/4k3/8/8/4K3/8/8 w - - 0 1 cc 33"
+	table := NewPerftTable(1 << 10)
+	chess2, err := ParseEpdFlags(epd, VariantChess2)
+	require.NoError(t, err)
+	classic, err := ParseEpdFlags(epd, VariantClassic)
+	require.NoError(t, err)
+	require.NotEqual(t, Perft(chess2, 4), Perft(classic, 4))
+	options := PerftOptions{Table: table}
+	assert.Equal(t, Perft(chess2, 4), PerftWithOptions(chess2, 4, options))
+	assert.Equal(t, Perft(classic, 4), PerftWithOptions(classic, 4, options))
+}
+
+func TestPerftDuels(t *testing.T) {
+	cases := map[string]struct {
+		epd    string
+		counts []uint64
+	}{
+		"no captures": {
+			epd:    "4k3/8/8/8/8/8/3P4/4K3 w - - 0 1 cc 33",
+			counts: []uint64{6},
+		},
+		// The capture can be made without a duel, with one of the four
+		// challenges and responses, or with the bluff called either way.
+		"pawn capture": {
+			epd:    "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 11",
+			counts: []uint64{12},
+		},
+		// A challenge can still be made with a bid of zero.
+		"defender has no stones": {
+			epd:    "4k3/8/8/4p3/3P4/8/8/4K3 w - - 0 1 cc 10",
+			counts: []uint64{10},
+		},
+	}
+	for name, config := range cases {
+		game, err := ParseEpd(config.epd)
+		require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+		for _, bruteforce := range []bool{false, true} {
+			options := PerftOptions{Duels: true, Bruteforce: bruteforce}
+			result := PerftWithOptions(game, len(config.counts), options)
+			assert.Equal(t, config.counts, result, "Case: %s  Bruteforce: %v", name, bruteforce)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package chess2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Results as written in PGN.
+const (
+	PgnWhiteWins  = "1-0"
+	PgnBlackWins  = "0-1"
+	PgnDraw       = "1/2-1/2"
+	PgnInProgress = "*"
+)
+
+// Values of the Variant tag.
+const (
+	PgnVariantChess2  = "Chess2"
+	PgnVariantClassic = "Classic"
+)
+
+// pgnLineLength is the longest line of movetext that EncodePgn writes.
+const pgnLineLength = 79
+
+var (
+	gameFlagNames = map[GameFlags]string{
+		GameFlagMidline:    "midline",
+		GameFlagStalemate:  "stalemate",
+		GameFlagRepetition: "repetition",
+		GameFlagDrops:      "drops",
+	}
+
+	rePgnTag        = regexp.MustCompile(`^\[\s*([A-Za-z0-9_]+)\s+"((?:[^"\\]|\\.)*)"\s*\]$`)
+	rePgnMoveNumber = regexp.MustCompile(`^[0-9]+\.+`)
+)
+
+// A PgnTag is a single tag pair from the header of a PGN game.
+type PgnTag struct {
+	Name, Value string
+}
+
+// A PgnGame is a game in a PGN-like format. In addition to the Seven Tag
+// Roster, the header describes the starting position with these tags:
+//
+//   - WhiteArmy and BlackArmy are the EPD symbols of the armies.
+//   - Stones is the starting stones, as in EPD, like "33" or "12/9".
+//   - Variant is "Chess2", "Classic", or a space-separated list of game flags.
+//   - EPD is the starting position, if it isn't the standard one.
+//
+// The movetext uses the notation from EncodeSan. King-turns are written as
+// separate moves, without a move number.
+type PgnGame struct {
+	Tags   []PgnTag
+	Record *GameRecord
+}
+
+// NewPgnGame creates a PgnGame for the given record, with all of the required
+// tags. The Seven Tag Roster is filled with unknown values, which can be
+// replaced using SetTag.
+func NewPgnGame(record *GameRecord) *PgnGame {
+	p := &PgnGame{Record: record}
+	for _, name := range []string{"Event", "Site", "Date", "Round", "White", "Black"} {
+		p.SetTag(name, "?")
+	}
+	p.SetTag("Result", pgnResult(record.Game()))
+	start := record.StartingPosition()
+	p.SetTag("WhiteArmy", string(armyToSymbol[start.armies[0]]))
+	p.SetTag("BlackArmy", string(armyToSymbol[start.armies[1]]))
+	var stones strings.Builder
+	encodeStones(&stones, start.stones, start.maxStones)
+	p.SetTag("Stones", stones.String())
+	p.SetTag("Variant", encodeVariant(start.flags))
+	standard := GameFromArmies(start.armies[0], start.armies[1])
+	standard.stones = start.stones
+	standard.maxStones = start.maxStones
+	if EncodeEpd(standard) != EncodeEpd(start) {
+		p.SetTag("EPD", EncodeEpd(start))
+	}
+	return p
+}
+
+// Tag returns the value of the tag with the given name, or an empty string if
+// there is no such tag.
+func (p *PgnGame) Tag(name string) string {
+	for _, tag := range p.Tags {
+		if tag.Name == name {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag changes the value of the tag with the given name, adding it if
+// necessary.
+func (p *PgnGame) SetTag(name, value string) {
+	for i := range p.Tags {
+		if p.Tags[i].Name == name {
+			p.Tags[i].Value = value
+			return
+		}
+	}
+	p.Tags = append(p.Tags, PgnTag{Name: name, Value: value})
+}
+
+func pgnResult(game Game) string {
+	switch game.GameState() {
+	case GameOverWhite:
+		return PgnWhiteWins
+	case GameOverBlack:
+		return PgnBlackWins
+	case GameOverDraw:
+		return PgnDraw
+	default:
+		return PgnInProgress
+	}
+}
+
+func encodeVariant(flags GameFlags) string {
+	switch flags {
+	case VariantChess2:
+		return PgnVariantChess2
+	case VariantClassic:
+		return PgnVariantClassic
+	}
+	var names []string
+	for flag := GameFlags(1); flag <= GameFlagDrops; flag <<= 1 {
+		if flags&flag != 0 {
+			names = append(names, gameFlagNames[flag])
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func parseVariant(variant string) (GameFlags, error) {
+	switch variant {
+	case "", PgnVariantChess2:
+		return VariantChess2, nil
+	case PgnVariantClassic:
+		return VariantClassic, nil
+	}
+	var flags GameFlags
+	for _, name := range strings.Fields(variant) {
+		found := false
+		for flag, flagName := range gameFlagNames {
+			if name == flagName {
+				flags |= flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, ParseError(fmt.Sprintf("PGN has unknown variant: %s", variant))
+		}
+	}
+	return flags, nil
+}
+
+// EncodePgn returns the PGN for the given game. The Result tag is written as
+// it is, but the game termination marker at the end of the movetext always
+// matches the Result tag.
+func EncodePgn(p *PgnGame) string {
+	var sb strings.Builder
+	for _, tag := range p.Tags {
+		value := strings.Replace(tag.Value, `\`, `\\`, -1)
+		value = strings.Replace(value, `"`, `\"`, -1)
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", tag.Name, value)
+	}
+	sb.WriteRune('\n')
+
+	lineLength := 0
+	write := func(token string) {
+		if lineLength > 0 && lineLength+1+len(token) > pgnLineLength {
+			sb.WriteRune('\n')
+			lineLength = 0
+		} else if lineLength > 0 {
+			sb.WriteRune(' ')
+			lineLength++
+		}
+		sb.WriteString(token)
+		lineLength += len(token)
+	}
+	positions := p.Record.Positions()
+	for i, move := range p.Record.Moves() {
+		game := positions[i]
+		if game.toMove == ColorWhite && !game.kingTurn {
+			write(fmt.Sprintf("%d.", game.fullmoveNumber+1))
+		} else if i == 0 {
+			write(fmt.Sprintf("%d...", game.fullmoveNumber+1))
+		}
+		write(EncodeSan(game, move))
+	}
+	result := p.Tag("Result")
+	if result == "" {
+		result = PgnInProgress
+	}
+	write(result)
+	sb.WriteString("\n\n")
+	return sb.String()
+}
+
+// ParsePgn parses a single game in PGN.
+func ParsePgn(pgn string) (*PgnGame, error) {
+	game, err := NewPgnReader(strings.NewReader(pgn)).Next()
+	if err == io.EOF {
+		return nil, ParseError("PGN is empty")
+	}
+	return game, err
+}
+
+// A PgnReader reads a stream of games in PGN, one game at a time, so that
+// files with many games don't need to be loaded into memory at once.
+type PgnReader struct {
+	r *bufio.Reader
+	// unread is a line which has been read but belongs to the next game.
+	unread string
+	// games is the number of games read so far, including the current one,
+	// for error messages.
+	games int
+}
+
+// NewPgnReader creates a PgnReader that reads from the given source.
+func NewPgnReader(r io.Reader) *PgnReader {
+	return &PgnReader{r: bufio.NewReader(r)}
+}
+
+func (r *PgnReader) readLine() (string, error) {
+	if r.unread != "" {
+		line := r.unread
+		r.unread = ""
+		return line, nil
+	}
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Next reads the next game from the stream. It returns io.EOF when there are
+// no more games. Each game is replayed as it is read, so any illegal moves
+// are reported as errors.
+func (r *PgnReader) Next() (*PgnGame, error) {
+	p := &PgnGame{}
+	r.games++
+	var movetext strings.Builder
+	inMovetext := false
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			if !inMovetext && len(p.Tags) == 0 {
+				return nil, io.EOF
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "%") || trimmed == "" {
+			// Escaped lines and blank lines
+			continue
+		} else if strings.HasPrefix(trimmed, "[") {
+			if inMovetext {
+				r.unread = line
+				break
+			}
+			match := rePgnTag.FindStringSubmatch(trimmed)
+			if match == nil {
+				return nil, r.error("invalid tag: %s", trimmed)
+			}
+			value := strings.Replace(match[2], `\"`, `"`, -1)
+			value = strings.Replace(value, `\\`, `\`, -1)
+			p.SetTag(match[1], value)
+			continue
+		}
+		inMovetext = true
+		movetext.WriteString(line)
+		movetext.WriteRune('\n')
+	}
+
+	start, err := r.startingPosition(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Record = NewGameRecord(start)
+	for _, token := range tokenizePgnMovetext(movetext.String()) {
+		switch token {
+		case PgnWhiteWins, PgnBlackWins, PgnDraw, PgnInProgress:
+			if p.Tag("Result") == "" {
+				p.SetTag("Result", token)
+			}
+			continue
+		}
+		move, err := ParseSan(p.Record.Game(), token)
+		if err == nil {
+			err = p.Record.ApplyMove(move)
+		}
+		if err != nil {
+			return nil, r.error("move %d (%s): %s", len(p.Record.Moves())+1, token, err)
+		}
+	}
+	if p.Tag("Result") == "" {
+		p.SetTag("Result", pgnResult(p.Record.Game()))
+	}
+	return p, nil
+}
+
+func (r *PgnReader) error(format string, args ...interface{}) error {
+	return ParseError(fmt.Sprintf("PGN game %d: %s", r.games, fmt.Sprintf(format, args...)))
+}
+
+// Creates the starting position described by the tags.
+func (r *PgnReader) startingPosition(p *PgnGame) (Game, error) {
+	flags, err := parseVariant(p.Tag("Variant"))
+	if err != nil {
+		return Game{}, r.error("%s", err)
+	}
+	epd := p.Tag("EPD")
+	if epd == "" {
+		var armies [2]Army
+		for i, name := range []string{"WhiteArmy", "BlackArmy"} {
+			symbol := p.Tag(name)
+			if symbol == "" {
+				armies[i] = ArmyClassic
+				continue
+			}
+			army, found := ArmyNone, false
+			if len(symbol) == 1 {
+				army, found = FindArmySymbol(rune(symbol[0]))
+			}
+			if !found {
+				return Game{}, r.error("invalid %s: %s", name, symbol)
+			}
+			armies[i] = army
+		}
+		standard := GameFromArmies(armies[0], armies[1])
+		epd = EncodeEpd(standard)
+		if stones := p.Tag("Stones"); stones != "" {
+			epd = epd[:strings.LastIndexByte(epd, ' ')+1] + stones
+		}
+	}
+	game, err := ParseEpdFlags(epd, flags)
+	if err != nil {
+		return Game{}, r.error("%s", err)
+	}
+	return game, nil
+}
+
+// Splits movetext into moves and game termination markers, removing move
+// numbers, comments, variations and annotations.
+func tokenizePgnMovetext(movetext string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		token := current.String()
+		current.Reset()
+		token = rePgnMoveNumber.ReplaceAllString(token, "")
+		if token != "" && token[0] != '$' {
+			tokens = append(tokens, token)
+		}
+	}
+	depth := 0
+	for i := 0; i < len(movetext); i++ {
+		c := movetext[i]
+		switch {
+		case c == '{':
+			flush()
+			if end := strings.IndexByte(movetext[i:], '}'); end != -1 {
+				i += end
+			} else {
+				i = len(movetext)
+			}
+		case c == ';':
+			flush()
+			if end := strings.IndexByte(movetext[i:], '\n'); end != -1 {
+				i += end
+			} else {
+				i = len(movetext)
+			}
+		case c == '(':
+			flush()
+			depth++
+		case c == ')':
+			flush()
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,157 @@
+package chess2
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPgnRoundTrip(t *testing.T) {
+	record := NewGameRecord(GameFromArmies(ArmyTwoKings, ArmyAnimals))
+	applyUcis(t, record, []string{"e2e4", "0000", "d7d5", "e4d5:10+", "d1e2", "d8d5", "g1f3", "0000"})
+	pgn := NewPgnGame(record)
+	pgn.SetTag("Event", "Test")
+	encoded := EncodePgn(pgn)
+	assert.Equal(t, `[Event "Test"]
+[Site "?"]
+[Date "?"]
+[Round "?"]
+[White "?"]
+[Black "?"]
+[Result "*"]
+[WhiteArmy "k"]
+[BlackArmy "a"]
+[Stones "33"]
+[Variant "Chess2"]
+
+1. e4 -- d5 2. exd5:10+ Kde2 Qd5 3. Nf3 -- *
+
+`, encoded)
+
+	parsed, err := ParsePgn(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, pgn.Tags, parsed.Tags)
+	assert.Equal(t, record.Moves(), parsed.Record.Moves())
+	assert.Equal(t, EncodeEpd(record.Game()), EncodeEpd(parsed.Record.Game()))
+}
+
+func TestPgnStartingPosition(t *testing.T) {
+	start, err := ParseEpdFlags("4k3/8/8/8/8/8/4P3/4K3 w - - 0 1 cc 52", VariantClassic)
+	require.NoError(t, err)
+	record := NewGameRecord(start)
+	applyUcis(t, record, []string{"e2e4"})
+	pgn := NewPgnGame(record)
+	assert.Equal(t, "52", pgn.Tag("Stones"))
+	assert.Equal(t, PgnVariantClassic, pgn.Tag("Variant"))
+	assert.Equal(t, "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1 cc 52", pgn.Tag("EPD"))
+
+	parsed, err := ParsePgn(EncodePgn(pgn))
+	require.NoError(t, err)
+	assert.Equal(t, EncodeEpd(record.Game()), EncodeEpd(parsed.Record.Game()))
+	assert.Equal(t, VariantClassic, parsed.Record.Game().flags)
+
+	// Only the stones differ from the standard position
+	parsed, err = ParsePgn("[WhiteArmy \"e\"]\n[Stones \"15\"]\n\n1. e4 *\n")
+	require.NoError(t, err)
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1 ec 15", EncodeEpd(parsed.Record.Game()))
+	assert.Equal(t, "", NewPgnGame(parsed.Record).Tag("EPD"))
+
+	// The limit on stones is part of the stones
+	parsed, err = ParsePgn("[Stones \"12/9\"]\n\n1. e4 *\n")
+	require.NoError(t, err)
+	game := parsed.Record.StartingPosition()
+	assert.Equal(t, 9, game.MaxStones())
+	pgn = NewPgnGame(parsed.Record)
+	assert.Equal(t, "12/9", pgn.Tag("Stones"))
+	assert.Equal(t, "", pgn.Tag("EPD"))
+}
+
+func TestPgnDrops(t *testing.T) {
+	start, err := ParseEpdFlags("4k3/8/8/8/8/8/4b3/4K3[N] w - - 0 1 cc 33", VariantChess2)
+	require.NoError(t, err)
+	record := NewGameRecord(start)
+	applyUcis(t, record, []string{"e1e2", "e8d8", "B@d3"})
+	pgn := NewPgnGame(record)
+	assert.Equal(t, "midline repetition drops", pgn.Tag("Variant"))
+
+	parsed, err := ParsePgn(EncodePgn(pgn))
+	require.NoError(t, err)
+	assert.Equal(t, "3k4/8/8/8/8/3B4/4K3/8[N] b - - 2 2 cc 33", EncodeEpd(parsed.Record.Game()))
+	assert.Equal(t, VariantChess2|GameFlagDrops, parsed.Record.Game().flags)
+}
+
+func TestPgnReader(t *testing.T) {
+	stream := `[Event "First"]
+[Result "1-0"]
+
+1. e4 {best by test} e5 (1... c5 2. Nf3) 2. Qh5 $1 Nc6 ; a comment
+3. Bc4 Nf6?? 4. Qxf7# 1-0
+
+[Event "Second"]
+[White "Someone \"Quoted\""]
+[Result "1/2-1/2"]
+
+1.d4 d5 1/2-1/2
+[Event "Third"]
+
+1. e4 Nf6 2. Ke2 Ng8 3. Kd3 Nf6 4. Kc4 Ng8 5. Kd5
+`
+	reader := NewPgnReader(strings.NewReader(stream))
+	first, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "First", first.Tag("Event"))
+	assert.Equal(t, PgnWhiteWins, first.Tag("Result"))
+	assert.Len(t, first.Record.Moves(), 7)
+	assert.Equal(t, GameOverWhite, first.Record.Game().gameState)
+
+	second, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, `Someone "Quoted"`, second.Tag("White"))
+	assert.Equal(t, PgnDraw, second.Tag("Result"))
+	assert.Len(t, second.Record.Moves(), 2)
+
+	third, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, PgnWhiteWins, third.Tag("Result"), "Midline victory")
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPgnErrors(t *testing.T) {
+	cases := map[string]struct {
+		pgn string
+		err string
+	}{
+		"illegal move": {
+			pgn: "1. e4 e4 *",
+			err: "PGN game 1: move 2 (e4): No legal move matches SAN",
+		},
+		"bad tag": {
+			pgn: "[Event Test]\n\n1. e4 *",
+			err: "PGN game 1: invalid tag: [Event Test]",
+		},
+		"bad army": {
+			pgn: "[WhiteArmy \"x\"]\n\n1. e4 *",
+			err: "PGN game 1: invalid WhiteArmy: x",
+		},
+		"bad variant": {
+			pgn: "[Variant \"Atomic\"]\n\n1. e4 *",
+			err: "PGN game 1: PGN has unknown variant: Atomic",
+		},
+		"empty": {
+			pgn: "\n\n",
+			err: "PGN is empty",
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParsePgn(config.pgn)
+			require.Error(t, err, "Case: %s", name)
+			assert.Equal(t, config.err, err.Error(), "Case: %s", name)
+		})
+	}
+}
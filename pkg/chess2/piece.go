@@ -2,6 +2,7 @@ package chess2
 
 import (
 	"fmt"
+	"strconv"
 )
 
 type (
@@ -93,14 +94,15 @@ const (
 )
 
 const (
-	typeMask  = 0x0f
-	armyMask  = 0x70
+	typeMask = 0x0f
+	// Custom armies use the high byte, see RegisterArmy.
+	armyMask  = 0xff70
 	colorMask = 0x80
 )
 
 // Piece represents a single piece, including army, color, and type.
 type Piece struct {
-	repr uint8
+	repr uint16
 }
 
 var (
@@ -126,22 +128,6 @@ var (
 		ColorWhite: "white",
 		ColorBlack: "black",
 	}
-	pieceNames = map[PieceName]string{
-		PieceNameClassicKing:     "Classic King",
-		PieceNameNemesisQueen:    "Nemesis",
-		PieceNameNemesisPawn:     "Nemesis Pawn",
-		PieceNameEmpoweredQueen:  "Abdicated Queen",
-		PieceNameEmpoweredBishop: "Empowered Bishop",
-		PieceNameEmpoweredKnight: "Empowered Knight",
-		PieceNameEmpoweredRook:   "Empowered Rook",
-		PieceNameReaperQueen:     "Reaper",
-		PieceNameReaperRook:      "Ghost",
-		PieceNameTwoKingsKing:    "Warrior King",
-		PieceNameAnimalsQueen:    "Jungle Queen",
-		PieceNameAnimalsBishop:   "Tiger",
-		PieceNameAnimalsKnight:   "Wild Horse",
-		PieceNameAnimalsRook:     "Elephant",
-	}
 )
 
 // InvalidPiece is the default value for Piece. It represents "no piece".
@@ -164,7 +150,8 @@ func OtherColor(color Color) Color {
 }
 
 // DuelingRank computes the dueling rank of the given piece type. Dueling a
-// piece of a higher dueling rank requires paying a stone.
+// piece of a higher dueling rank requires paying a stone. Armies can change the
+// dueling rank of their pieces, see Piece.DuelingRank.
 func DuelingRank(t PieceType) int {
 	switch t {
 	case TypePawn:
@@ -183,33 +170,36 @@ func (t PieceType) String() string {
 	if name, found := basicTypeNames[t]; found {
 		return name
 	}
-	return string(int(t))
+	return strconv.Itoa(int(t))
 }
 
 func (a Army) String() string {
 	if name, found := armyNames[a]; found {
 		return name
 	}
-	return string(int(a))
+	return strconv.Itoa(int(a))
 }
 
 func (c Color) String() string {
 	if name, found := colorNames[c]; found {
 		return name
 	}
-	return string(int(c))
+	return strconv.Itoa(int(c))
 }
 
 func (p PieceName) String() string {
-	if name, found := pieceNames[p]; found {
-		return name
+	t := PieceType(int(p) & typeMask)
+	if t >= TypeKing && t <= TypePawn {
+		if name := lookupArmy(Army(int(p) & armyMask)).pieces[pieceTypeIdx(t)].name; name != "" {
+			return name
+		}
 	}
-	return PieceType(uint8(p) & typeMask).String()
+	return t.String()
 }
 
 // NewPiece returns a piece with the given properties
 func NewPiece(pieceType PieceType, army Army, color Color) Piece {
-	return Piece{repr: uint8(pieceType) | uint8(army) | uint8(color)}
+	return Piece{repr: uint16(pieceType) | uint16(army) | uint16(color)}
 }
 
 // WithArmy returns a copied Piece with the army set to the given value.
@@ -228,19 +218,25 @@ func (p Piece) Army() Army {
 }
 
 // Name returns one of the PieceName* constants. It's a combination of the Army
-// and Type, but pieces which aren't special are converted to ArmyBasic.
+// and Type, but pieces which aren't special are converted to ArmyBasic. The
+// special pieces are the ones listed in the ArmyDefinition of the army.
 func (p Piece) Name() PieceName {
-	name := PieceName(p.repr &^ colorMask)
-	switch name {
-	case PieceNameClassicKing, PieceNameNemesisQueen, PieceNameNemesisPawn,
-		PieceNameEmpoweredQueen, PieceNameEmpoweredBishop,
-		PieceNameEmpoweredKnight, PieceNameEmpoweredRook, PieceNameReaperQueen,
-		PieceNameReaperRook, PieceNameTwoKingsKing, PieceNameAnimalsQueen,
-		PieceNameAnimalsBishop, PieceNameAnimalsKnight, PieceNameAnimalsRook:
-		return name
-	default:
-		return PieceName(p.repr & typeMask)
+	if t := p.Type(); t >= TypeKing && t <= TypePawn && p.rules().special {
+		return PieceName(p.repr &^ colorMask)
 	}
+	return PieceName(p.repr & typeMask)
+}
+
+// DuelingRank returns the dueling rank of the receiver, which depends on its
+// army. See the DuelingRank function.
+func (p Piece) DuelingRank() int {
+	return p.rules().duelRank
+}
+
+// rules returns the rules of the army of the receiver for its type. The
+// receiver must be a real piece.
+func (p Piece) rules() *pieceRules {
+	return &lookupArmy(p.Army()).pieces[pieceTypeIdx(p.Type())]
 }
 
 // Color returns the piece color of the receiver.
@@ -0,0 +1,238 @@
+package chess2
+
+// A GameRecord is the full history of a game: the starting position, every
+// move that was played, and every position that was reached. Unlike Game,
+// which only knows about the current position, a GameRecord can detect
+// threefold repetition. A GameRecord also handles the ways that players can
+// end a game other than by moving: resigning, agreeing to a draw, and
+// claiming a draw.
+type GameRecord struct {
+	// positions[0] is the starting position and positions[i+1] is the result
+	// of applying moves[i] to positions[i].
+	positions []Game
+	moves     []Move
+	// drawOffer is the player who has offered a draw, if drawOffered is set.
+	drawOffer   Color
+	drawOffered bool
+}
+
+// NewGameRecord creates a GameRecord which starts at the given position.
+func NewGameRecord(start Game) *GameRecord {
+	r := &GameRecord{positions: []Game{start}}
+	r.updateGameState()
+	return r
+}
+
+// Game returns the current position of the receiver.
+func (r *GameRecord) Game() Game {
+	return r.positions[len(r.positions)-1]
+}
+
+// StartingPosition returns the position the receiver started from.
+func (r *GameRecord) StartingPosition() Game {
+	return r.positions[0]
+}
+
+// Moves returns the moves that have been played, in order. The returned slice
+// must not be modified.
+func (r *GameRecord) Moves() []Move {
+	return r.moves
+}
+
+// Positions returns every position that was reached, starting with the
+// starting position and ending with the current position. There is always one
+// more position than there are moves. The returned slice must not be
+// modified.
+func (r *GameRecord) Positions() []Game {
+	return r.positions
+}
+
+// Clone returns a copy of the receiver which can be changed independently.
+func (r *GameRecord) Clone() *GameRecord {
+	clone := *r
+	clone.positions = append([]Game(nil), r.positions...)
+	clone.moves = append([]Move(nil), r.moves...)
+	return &clone
+}
+
+// ApplyMove validates that the given move is legal in the current position and
+// adds it to the receiver.
+func (r *GameRecord) ApplyMove(move Move) error {
+	current := r.Game()
+	if err := current.ValidateLegalMove(move); err != nil {
+		return err
+	}
+	r.moves = append(r.moves, move)
+	r.positions = append(r.positions, current.ApplyMove(move))
+	// The opponent declines an offer of a draw by moving.
+	if r.drawOffered && r.drawOffer != current.ToMove() {
+		r.drawOffered = false
+	}
+	r.updateGameState()
+	return nil
+}
+
+// Timeout ends the game because the given player has run out of time. It has
+// no effect if the game is already over.
+func (r *GameRecord) Timeout(color Color) {
+	current := r.Game()
+	if current.GameState() == GameInProgress {
+		r.end(winner(OtherColor(color)), EndReasonTimeout)
+	}
+}
+
+// Resign ends the game with a win for the opponent of the given player, who
+// must be the player to move.
+func (r *GameRecord) Resign(color Color) error {
+	if err := r.validateActor(color); err != nil {
+		return err
+	}
+	r.end(winner(OtherColor(color)), EndReasonResignation)
+	return nil
+}
+
+// OfferDraw offers a draw on behalf of the given player, who is usually the
+// player who just moved, but may also be the player to move. The offer stands
+// until the opponent answers it or makes a move. A player can't offer a draw
+// while the opponent's offer stands.
+func (r *GameRecord) OfferDraw(color Color) error {
+	current := r.Game()
+	if current.GameState() != GameInProgress {
+		return GameOverError
+	} else if r.drawOffered && r.drawOffer != color {
+		return ActionOutOfTurnError
+	}
+	r.drawOffer = color
+	r.drawOffered = true
+	return nil
+}
+
+// DrawOffer returns the player who has offered a draw, and false if no draw
+// has been offered.
+func (r *GameRecord) DrawOffer() (Color, bool) {
+	return r.drawOffer, r.drawOffered
+}
+
+// AcceptDraw ends the game in a draw. The given player must be the opponent of
+// the player who offered the draw.
+func (r *GameRecord) AcceptDraw(color Color) error {
+	if err := r.validateAnswer(color); err != nil {
+		return err
+	}
+	r.end(GameOverDraw, EndReasonAgreement)
+	return nil
+}
+
+// DeclineDraw withdraws the offer of a draw. The given player must be the
+// opponent of the player who offered the draw.
+func (r *GameRecord) DeclineDraw(color Color) error {
+	if err := r.validateAnswer(color); err != nil {
+		return err
+	}
+	r.drawOffered = false
+	return nil
+}
+
+// ClaimDraw ends the game in a draw by the fifty move rule or by threefold
+// repetition. The given player must be the player to move. The fifty move rule
+// always ends the game by itself, and so does threefold repetition when
+// GameFlagRepetition is set, so claims are needed for repetitions in games
+// without that flag.
+func (r *GameRecord) ClaimDraw(color Color) error {
+	if err := r.validateActor(color); err != nil {
+		return err
+	}
+	current := r.Game()
+	switch {
+	case current.halfmoveClock >= 50:
+		r.end(GameOverDraw, EndReasonFiftyMove)
+	case r.RepetitionCount() >= 3:
+		r.end(GameOverDraw, EndReasonRepetition)
+	default:
+		return NoDrawToClaimError
+	}
+	return nil
+}
+
+// Returns an error if the given player can't act on their own initiative.
+func (r *GameRecord) validateActor(color Color) error {
+	current := r.Game()
+	if current.GameState() != GameInProgress {
+		return GameOverError
+	} else if color != current.ToMove() {
+		return ActionOutOfTurnError
+	}
+	return nil
+}
+
+// Returns an error if the given player can't answer an offer of a draw.
+func (r *GameRecord) validateAnswer(color Color) error {
+	current := r.Game()
+	if current.GameState() != GameInProgress {
+		return GameOverError
+	} else if !r.drawOffered {
+		return NoDrawOfferError
+	} else if color == r.drawOffer {
+		return ActionOutOfTurnError
+	}
+	return nil
+}
+
+// Ends the game in the current position.
+func (r *GameRecord) end(state GameState, reason EndReason) {
+	current := &r.positions[len(r.positions)-1]
+	current.gameState = state
+	current.endReason = reason
+	r.drawOffered = false
+}
+
+// Returns the state where the given player has won.
+func winner(color Color) GameState {
+	if color == ColorWhite {
+		return GameOverWhite
+	}
+	return GameOverBlack
+}
+
+// RepetitionCount returns the number of times that the current position has
+// occurred in the game, including the current occurrence.
+func (r *GameRecord) RepetitionCount() int {
+	current := &r.positions[len(r.positions)-1]
+	hash := current.Hash()
+	count := 0
+	for i := range r.positions {
+		// Positions with the same hash are compared in full, so that a
+		// collision can't end the game.
+		if r.positions[i].Hash() == hash && samePosition(&r.positions[i], current) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns true if the games are in the same position, comparing everything that
+// is covered by Hash.
+func samePosition(a, b *Game) bool {
+	return a.board.pieces == b.board.pieces &&
+		a.board.colors == b.board.colors &&
+		a.toMove == b.toMove &&
+		a.kingTurn == b.kingTurn &&
+		a.castlingRights == b.castlingRights &&
+		a.epSquare == b.epSquare &&
+		a.armies == b.armies &&
+		a.stones == b.stones &&
+		a.maxStones == b.maxStones &&
+		a.reserves == b.reserves
+}
+
+// Ends the game if the current position has been repeated too many times.
+func (r *GameRecord) updateGameState() {
+	current := &r.positions[len(r.positions)-1]
+	if current.gameState != GameInProgress || current.flags&GameFlagRepetition == 0 {
+		return
+	}
+	if r.RepetitionCount() >= 3 {
+		current.gameState = GameOverDraw
+		current.endReason = EndReasonRepetition
+	}
+}
@@ -0,0 +1,226 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func applyUcis(t *testing.T, record *GameRecord, ucis []string) {
+	for _, uci := range ucis {
+		move, err := ParseUci(uci)
+		require.NoError(t, err, "UCI: %s", uci)
+		require.NoError(t, record.ApplyMove(move), "UCI: %s", uci)
+	}
+}
+
+func TestGameRecordHistory(t *testing.T) {
+	record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+	applyUcis(t, record, []string{"e2e4", "e7e5"})
+	require.Len(t, record.Moves(), 2)
+	require.Len(t, record.Positions(), 3)
+	assert.Equal(t, "e2e4", record.Moves()[0].String())
+	assert.Equal(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33", EncodeEpd(record.StartingPosition()))
+	assert.Equal(t, "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2 cc 33", EncodeEpd(record.Game()))
+
+	move, err := ParseUci("e4e5")
+	require.NoError(t, err)
+	assert.EqualError(t, record.ApplyMove(move), IllegalCaptureError.Error())
+	require.Len(t, record.Moves(), 2)
+}
+
+func TestGameRecordClone(t *testing.T) {
+	record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+	applyUcis(t, record, []string{"e2e4"})
+	require.NoError(t, record.OfferDraw(ColorWhite))
+	clone := record.Clone()
+	applyUcis(t, clone, []string{"e7e5"})
+	assert.Len(t, record.Moves(), 1)
+	assert.Len(t, clone.Moves(), 2)
+	color, offered := record.DrawOffer()
+	assert.True(t, offered)
+	assert.Equal(t, ColorWhite, color)
+	_, offered = clone.DrawOffer()
+	assert.False(t, offered)
+}
+
+func TestGameRecordRepetition(t *testing.T) {
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+	applyUcis(t, record, shuffle)
+	assert.Equal(t, 2, record.RepetitionCount())
+	current := record.Game()
+	assert.Equal(t, GameInProgress, current.GameState())
+	applyUcis(t, record, shuffle)
+	assert.Equal(t, 3, record.RepetitionCount())
+	current = record.Game()
+	assert.Equal(t, GameOverDraw, current.GameState())
+	assert.Equal(t, EndReasonRepetition, current.Result().Reason)
+
+	move, err := ParseUci("e2e4")
+	require.NoError(t, err)
+	assert.EqualError(t, record.ApplyMove(move), GameOverError.Error())
+}
+
+func TestGameRecordRepetitionHashCollision(t *testing.T) {
+	start := GameFromArmies(ArmyClassic, ArmyClassic)
+	record := NewGameRecord(start)
+	applyUcis(t, record, []string{"g1f3", "g8f6"})
+	current := record.Game()
+	// Force the earlier positions to collide with the current one.
+	for i := 0; i < 2; i++ {
+		other := &record.positions[i]
+		other.hash ^= other.Hash() ^ current.Hash()
+		require.Equal(t, current.Hash(), other.Hash())
+	}
+	assert.Equal(t, 1, record.RepetitionCount())
+}
+
+func TestGameRecordRepetitionTwoKings(t *testing.T) {
+	game, err := ParseEpdFlags("4k3/8/8/8/8/8/8/3KK3 w - - 0 1 kc 33", VariantChess2)
+	require.NoError(t, err)
+	record := NewGameRecord(game)
+	shuffle := []string{"d1c1", "0000", "e8d8", "c1d1", "0000", "d8e8"}
+	applyUcis(t, record, shuffle)
+	applyUcis(t, record, shuffle)
+	assert.Equal(t, 3, record.RepetitionCount())
+	current := record.Game()
+	assert.Equal(t, GameOverDraw, current.GameState())
+}
+
+func TestGameRecordRepetitionDisabled(t *testing.T) {
+	game, err := ParseEpdFlags("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33", GameFlagMidline)
+	require.NoError(t, err)
+	record := NewGameRecord(game)
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	applyUcis(t, record, shuffle)
+	applyUcis(t, record, shuffle)
+	assert.Equal(t, 3, record.RepetitionCount())
+	current := record.Game()
+	assert.Equal(t, GameInProgress, current.GameState())
+}
+
+func TestGameRecordTimeout(t *testing.T) {
+	record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+	applyUcis(t, record, []string{"e2e4"})
+	record.Timeout(ColorBlack)
+	current := record.Game()
+	assert.Equal(t, GameResult{GameOverWhite, EndReasonTimeout}, current.Result())
+	record.Timeout(ColorWhite)
+	current = record.Game()
+	assert.Equal(t, GameOverWhite, current.GameState(), "A finished game can't time out")
+
+	move, err := ParseUci("e7e5")
+	require.NoError(t, err)
+	assert.EqualError(t, record.ApplyMove(move), GameOverError.Error())
+}
+
+func TestGameRecordResign(t *testing.T) {
+	record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+	applyUcis(t, record, []string{"e2e4"})
+	assert.EqualError(t, record.Resign(ColorWhite), ActionOutOfTurnError.Error())
+	require.NoError(t, record.Resign(ColorBlack))
+	current := record.Game()
+	assert.Equal(t, GameResult{GameOverWhite, EndReasonResignation}, current.Result())
+	assert.EqualError(t, record.Resign(ColorBlack), GameOverError.Error())
+}
+
+func TestGameRecordDrawOffer(t *testing.T) {
+	cases := map[string]struct {
+		// Actions taken after white offers a draw on the first move.
+		actions func(t *testing.T, r *GameRecord) error
+		err     error
+		result  GameResult
+		offered bool
+	}{
+		"accepted": {
+			actions: func(t *testing.T, r *GameRecord) error { return r.AcceptDraw(ColorBlack) },
+			result:  GameResult{GameOverDraw, EndReasonAgreement},
+		},
+		"declined": {
+			actions: func(t *testing.T, r *GameRecord) error { return r.DeclineDraw(ColorBlack) },
+		},
+		"accepted by offerer": {
+			actions: func(t *testing.T, r *GameRecord) error { return r.AcceptDraw(ColorWhite) },
+			err:     ActionOutOfTurnError,
+			offered: true,
+		},
+		"offered again": {
+			actions: func(t *testing.T, r *GameRecord) error { return r.OfferDraw(ColorWhite) },
+			offered: true,
+		},
+		"offered by opponent": {
+			actions: func(t *testing.T, r *GameRecord) error { return r.OfferDraw(ColorBlack) },
+			err:     ActionOutOfTurnError,
+			offered: true,
+		},
+		"offerer moved": {
+			actions: func(t *testing.T, r *GameRecord) error {
+				applyUcis(t, r, []string{"e2e4"})
+				return r.AcceptDraw(ColorBlack)
+			},
+			result: GameResult{GameOverDraw, EndReasonAgreement},
+		},
+		"opponent moved": {
+			actions: func(t *testing.T, r *GameRecord) error {
+				applyUcis(t, r, []string{"e2e4", "e7e5"})
+				return r.AcceptDraw(ColorBlack)
+			},
+			err: NoDrawOfferError,
+		},
+		// The usual way to offer a draw is along with a move.
+		"offered after moving": {
+			actions: func(t *testing.T, r *GameRecord) error {
+				if err := r.DeclineDraw(ColorBlack); err != nil {
+					return err
+				}
+				applyUcis(t, r, []string{"e2e4"})
+				return r.OfferDraw(ColorWhite)
+			},
+			offered: true,
+		},
+		"no offer to decline": {
+			actions: func(t *testing.T, r *GameRecord) error {
+				if err := r.DeclineDraw(ColorBlack); err != nil {
+					return err
+				}
+				return r.DeclineDraw(ColorBlack)
+			},
+			err: NoDrawOfferError,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			record := NewGameRecord(GameFromArmies(ArmyClassic, ArmyClassic))
+			require.NoError(t, record.OfferDraw(ColorWhite), "Case: %s", name)
+			err := config.actions(t, record)
+			if config.err != nil {
+				assert.EqualError(t, err, config.err.Error(), "Case: %s", name)
+			} else {
+				assert.NoError(t, err, "Case: %s", name)
+			}
+			current := record.Game()
+			assert.Equal(t, config.result, current.Result(), "Case: %s", name)
+			offer, offered := record.DrawOffer()
+			assert.Equal(t, config.offered, offered, "Case: %s", name)
+			if offered {
+				assert.Equal(t, ColorWhite, offer, "Case: %s", name)
+			}
+		})
+	}
+}
+
+func TestGameRecordClaimDraw(t *testing.T) {
+	game, err := ParseEpdFlags("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33", GameFlagMidline)
+	require.NoError(t, err)
+	record := NewGameRecord(game)
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	applyUcis(t, record, shuffle)
+	assert.EqualError(t, record.ClaimDraw(ColorWhite), NoDrawToClaimError.Error())
+	applyUcis(t, record, shuffle)
+	assert.EqualError(t, record.ClaimDraw(ColorBlack), ActionOutOfTurnError.Error())
+	require.NoError(t, record.ClaimDraw(ColorWhite))
+	current := record.Game()
+	assert.Equal(t, GameResult{GameOverDraw, EndReasonRepetition}, current.Result())
+}
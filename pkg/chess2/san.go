@@ -0,0 +1,237 @@
+package chess2
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Notation for moves which don't fit the usual SAN pattern.
+const (
+	// SanPass is the notation for passing the second move of a king-turn.
+	SanPass = "--"
+	// SanKingside and SanQueenside are the notations for castling.
+	SanKingside  = "O-O"
+	SanQueenside = "O-O-O"
+)
+
+var (
+	reSanMove      = regexp.MustCompile(`^([KQBNR])?([a-h])?([1-8])?(x)?([a-h][1-8])(?:=?([QBNRqbnr]))?$`)
+	reSanWhirlwind = regexp.MustCompile(`^K\*([a-h][1-8])$`)
+	reSanDrop      = regexp.MustCompile(`^([KQBNRP])@([a-h][1-8])$`)
+)
+
+// EncodeSan returns the standard algebraic notation for the given legal move
+// in the given position, like "Nxe5" or "e8=Q".
+//
+// Chess 2 moves are written with these extensions:
+//   - A Warrior King's whirlwind attack is written "K*d4", where d4 is the
+//     square of the king.
+//   - A pass during a king-turn is written "--".
+//   - An Elephant's rampage is written as a capture of its final square.
+//   - Any moves resulting in a victory, including crossing the midline, are
+//     marked with "#".
+//   - Duels are written after the move exactly as in UCI, like "Nxe5+:10+".
+func EncodeSan(game Game, move Move) string {
+	var sb strings.Builder
+	switch {
+	case move.IsPass():
+		return SanPass
+	case move.IsDrop():
+		sb.WriteRune(pieceTypeToFen[move.Piece.Type()] &^ 0x20)
+		sb.WriteRune('@')
+		sb.WriteString(move.To.String())
+		return sb.String()
+	}
+
+	p, _ := game.board.PieceAt(move.From)
+	p = p.WithArmy(game.armies[ColorIdx(p.Color())])
+	rook := game.castlingRook(p, move)
+	switch {
+	case rook != InvalidSquare:
+		if rook.X() > move.From.X() {
+			sb.WriteString(SanKingside)
+		} else {
+			sb.WriteString(SanQueenside)
+		}
+	case p.rules().whirlwind && move.From == move.To:
+		sb.WriteString("K*")
+		sb.WriteString(move.To.String())
+	default:
+		capture := game.isCapture(move)
+		if p.Type() == TypePawn {
+			if capture {
+				sb.WriteByte(move.From.String()[0])
+			}
+		} else {
+			sb.WriteRune(pieceTypeToFen[p.Type()] &^ 0x20)
+			sb.WriteString(disambiguateSan(&game, p, move))
+		}
+		if capture {
+			sb.WriteRune('x')
+		}
+		sb.WriteString(move.To.String())
+		if move.Piece != InvalidPiece {
+			sb.WriteRune('=')
+			sb.WriteRune(pieceTypeToFen[move.Piece.Type()] &^ 0x20)
+		}
+	}
+
+	next := game.ApplyMove(move)
+	switch next.GameState() {
+	case GameOverWhite, GameOverBlack:
+		sb.WriteRune('#')
+	case GameInProgress:
+		if next.IsInCheck(OtherColor(p.Color())) {
+			sb.WriteRune('+')
+		}
+	}
+
+	numDuels := 0
+	for i, d := range move.Duels {
+		if d.IsStarted() {
+			numDuels = i + 1
+		}
+	}
+	for i := 0; i < numDuels; i++ {
+		sb.WriteRune(':')
+		sb.WriteString(move.Duels[i].String())
+	}
+	return sb.String()
+}
+
+// Returns the part of the from square which is needed to distinguish the move
+// from other legal moves of the same type of piece to the same square.
+func disambiguateSan(game *Game, p Piece, move Move) string {
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range game.GenerateLegalMoves() {
+		if other.To != move.To || other.From == move.From || other.From == other.To {
+			continue
+		}
+		q, _ := game.board.PieceAt(other.From)
+		if q.Type() != p.Type() || game.castlingRook(q.WithArmy(p.Army()), other) != InvalidSquare {
+			continue
+		}
+		ambiguous = true
+		sameFile = sameFile || other.From.X() == move.From.X()
+		sameRank = sameRank || other.From.Y() == move.From.Y()
+	}
+	from := move.From.String()
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return from[:1]
+	case !sameRank:
+		return from[1:]
+	default:
+		return from
+	}
+}
+
+// ParseSan takes a move in the notation produced by EncodeSan and returns the
+// legal move that it describes in the given position, including its duels. The
+// notation is parsed leniently: check and victory markers and annotations like
+// "!?" are ignored, and castling may be written with zeros.
+func ParseSan(game Game, san string) (Move, error) {
+	var duels []Duel
+	if idx := strings.IndexRune(san, ':'); idx != -1 {
+		for _, str := range strings.Split(san[idx+1:], ":") {
+			duel, err := ParseDuel(str)
+			if err != nil {
+				return Move{}, err
+			}
+			duels = append(duels, duel)
+		}
+		if len(duels) > 3 {
+			return Move{}, ParseError("Too many duels in SAN")
+		}
+		san = san[:idx]
+	}
+	san = strings.TrimRight(san, "+#!?")
+
+	var matches []Move
+	if san == SanPass {
+		if !game.kingTurn {
+			return Move{}, IllegalPassError
+		}
+		matches = append(matches, MovePass)
+	} else if m := reSanDrop.FindStringSubmatch(san); m != nil {
+		piece, _ := ParseFenPiece(rune(m[1][0]))
+		move := Move{From: InvalidSquare, To: SquareFromName(m[2])}
+		move.Piece = NewPiece(piece.Type(), ArmyNone, game.toMove)
+		matches = append(matches, move)
+	} else {
+		castle := strings.Replace(san, "0", "O", -1)
+		whirlwind := reSanWhirlwind.FindStringSubmatch(san)
+		normal := reSanMove.FindStringSubmatch(san)
+		if castle != SanKingside && castle != SanQueenside && whirlwind == nil && normal == nil {
+			return Move{}, ParseError("Invalid SAN")
+		}
+		for _, move := range game.GenerateLegalMoves() {
+			if move.IsPass() {
+				continue
+			}
+			p, _ := game.board.PieceAt(move.From)
+			p = p.WithArmy(game.armies[ColorIdx(p.Color())])
+			rook := game.castlingRook(p, move)
+			switch {
+			case rook != InvalidSquare:
+				kingside := rook.X() > move.From.X()
+				if castle == SanKingside && kingside || castle == SanQueenside && !kingside {
+					matches = append(matches, move)
+				}
+			case move.From == move.To:
+				if whirlwind != nil && move.To == SquareFromName(whirlwind[1]) {
+					matches = append(matches, move)
+				}
+			case normal != nil:
+				if matchSan(p, move, normal) {
+					matches = append(matches, move)
+				}
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Move{}, ParseError("No legal move matches SAN")
+	case 1:
+	default:
+		return Move{}, ParseError("Ambiguous SAN")
+	}
+	move := matches[0]
+	if move.Piece != InvalidPiece && !move.IsDrop() {
+		// Use the same promotion piece that ParseUci would.
+		move.Piece, _ = ParseFenPiece(pieceTypeToFen[move.Piece.Type()])
+	}
+	copy(move.Duels[:], duels)
+	// Drops aren't matched against the legal moves, and neither are duels.
+	// ValidateLegalMove checks both, including with ValidateDuels.
+	if err := game.ValidateLegalMove(move); err != nil {
+		return Move{}, err
+	}
+	return move, nil
+}
+
+// Returns true if the move is described by the parts of a normal SAN move.
+func matchSan(p Piece, move Move, parts []string) bool {
+	pieceType := TypePawn
+	if parts[1] != "" {
+		pieceType = fenToPieceType[rune(parts[1][0])|0x20]
+	}
+	from := move.From.String()
+	switch {
+	case p.Type() != pieceType:
+		return false
+	case move.To != SquareFromName(parts[5]):
+		return false
+	case parts[2] != "" && parts[2][0] != from[0]:
+		return false
+	case parts[3] != "" && parts[3][0] != from[1]:
+		return false
+	}
+	if parts[6] == "" {
+		return move.Piece == InvalidPiece
+	}
+	return move.Piece != InvalidPiece && move.Piece.Type() == fenToPieceType[rune(parts[6][0])|0x20]
+}
@@ -0,0 +1,213 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSan(t *testing.T) {
+	cases := map[string]struct {
+		epd string
+		uci string
+		san string
+	}{
+		"pawn move": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			uci: "e2e4",
+			san: "e4",
+		},
+		"knight move": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			uci: "g1f3",
+			san: "Nf3",
+		},
+		"kingside castle": {
+			epd: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1 cc 33",
+			uci: "e1g1",
+			san: "O-O",
+		},
+		"queenside castle": {
+			epd: "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1 cc 33",
+			uci: "e8c8",
+			san: "O-O-O",
+		},
+		"chess960 castle": {
+			epd: "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1 cc 33",
+			uci: "f1b1",
+			san: "O-O-O",
+		},
+		"disambiguate file": {
+			epd: "4k3/8/8/8/8/8/8/R4RK1 w - - 0 1 cc 33",
+			uci: "a1d1",
+			san: "Rad1",
+		},
+		"disambiguate rank": {
+			epd: "4k3/8/8/R7/8/8/8/R3K3 w - - 0 1 cc 33",
+			uci: "a1a3",
+			san: "R1a3",
+		},
+		"pawn capture": {
+			epd: "4k3/8/8/3p4/4P3/8/8/4K3 w - - 0 1 cc 33",
+			uci: "e4d5",
+			san: "exd5",
+		},
+		"en passant": {
+			epd: "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1 cc 33",
+			uci: "e5d6",
+			san: "exd6",
+		},
+		"duel": {
+			epd: "4k3/8/8/3p4/4P3/8/8/4K3 w - - 0 1 cc 33",
+			uci: "e4d5:10+",
+			san: "exd5:10+",
+		},
+		"promotion with check": {
+			epd: "4k3/P7/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+			uci: "a7a8q",
+			san: "a8=Q+",
+		},
+		"checkmate": {
+			epd: "6k1/5ppp/8/8/8/8/8/R3K3 w - - 0 1 cc 33",
+			uci: "a1a8",
+			san: "Ra8#",
+		},
+		"midline invasion": {
+			epd: "4k3/8/8/8/4K3/8/8/8 w - - 0 1 cc 33",
+			uci: "e4e5",
+			san: "Ke5#",
+		},
+		"elephant rampage": {
+			epd: "4k3/Rppp4/8/8/8/8/8/4K3 w - - 0 1 ac 23",
+			uci: "a7d7::12",
+			san: "Rxd7::12",
+		},
+		"whirlwind attack": {
+			epd: "4k3/8/8/8/3p4/3K4/8/3K4 K - - 0 1 kc 33",
+			uci: "d3d3",
+			san: "K*d3",
+		},
+		"king-turn pass": {
+			epd: "4k3/8/8/8/3p4/3K4/8/3K4 K - - 0 1 kc 33",
+			uci: "0000",
+			san: "--",
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			move, err := ParseUci(config.uci)
+			require.NoError(t, err, "UCI: %s  Name: %s", config.uci, name)
+			require.NoError(t, game.ValidateLegalMove(move), "Case: %s", name)
+			assert.Equal(t, config.san, EncodeSan(game, move), "Case: %s", name)
+			parsed, err := ParseSan(game, config.san)
+			require.NoError(t, err, "Case: %s", name)
+			assert.Equal(t, move, parsed, "Case: %s", name)
+		})
+	}
+}
+
+func TestParseSan(t *testing.T) {
+	cases := map[string]struct {
+		epd string
+		san string
+		uci string
+		err error
+	}{
+		"annotations": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			san: "Nf3!?",
+			uci: "g1f3",
+		},
+		"castle with zeros": {
+			epd: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1 cc 33",
+			san: "0-0-0",
+			uci: "e1c1",
+		},
+		"promotion without equals": {
+			epd: "4k3/P7/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+			san: "a8N",
+			uci: "a7a8n",
+		},
+		"ambiguous": {
+			epd: "4k3/8/8/8/8/8/8/R4RK1 w - - 0 1 cc 33",
+			san: "Rd1",
+			err: ParseError("Ambiguous SAN"),
+		},
+		"illegal": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			san: "e5",
+			err: ParseError("No legal move matches SAN"),
+		},
+		"castle is not a king move": {
+			epd: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1 cc 33",
+			san: "Kg1",
+			err: ParseError("No legal move matches SAN"),
+		},
+		"garbage": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			san: "Zz9",
+			err: ParseError("Invalid SAN"),
+		},
+		"pass outside king-turn": {
+			epd: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33",
+			san: "--",
+			err: IllegalPassError,
+		},
+		"drop": {
+			epd: "4k3/8/8/8/8/8/8/4K3[Q] w - - 0 1 cc 33",
+			san: "Q@e4",
+			uci: "Q@e4",
+		},
+		"drop without drops": {
+			epd: "4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+			san: "Q@e4",
+			err: IllegalDropError,
+		},
+		"drop not in reserve": {
+			epd: "4k3/8/8/8/8/8/8/4K3[R] w - - 0 1 cc 33",
+			san: "Q@e4",
+			err: IllegalDropError,
+		},
+		"drop onto occupied square": {
+			epd: "4k3/8/8/8/8/8/8/4K3[Q] w - - 0 1 cc 33",
+			san: "Q@e8",
+			err: IllegalDropError,
+		},
+		"duels": {
+			epd: "4k3/8/8/3p4/4P3/8/8/4K3 w - - 0 1 cc 33",
+			san: "exd5:12",
+			uci: "e4d5:12",
+		},
+		"duel without stones": {
+			epd: "4k3/8/8/3p4/4P3/8/8/4K3 w - - 0 1 cc 31",
+			san: "exd5:2",
+			err: NotEnoughStonesError,
+		},
+		"too many duels": {
+			epd: "4k3/8/8/3p4/4P3/8/8/4K3 w - - 0 1 cc 33",
+			san: "exd5:1:1",
+			err: TooManyDuelsError,
+		},
+		"duel without capture": {
+			epd: "4k3/8/8/8/4P3/8/8/4K3 w - - 0 1 cc 33",
+			san: "e5:1",
+			err: TooManyDuelsError,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			move, err := ParseSan(game, config.san)
+			if config.err != nil {
+				assert.Equal(t, config.err, err, "Case: %s", name)
+				return
+			}
+			require.NoError(t, err, "Case: %s", name)
+			assert.Equal(t, config.uci, move.String(), "Case: %s", name)
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package chess2
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MateScore is the score of a won game. Wins found by Search are reported
+	// as MateScore minus the number of plies until the game is won, so that
+	// faster wins are preferred.
+	MateScore = 30000
+	// maxPly is the deepest that Search will ever look.
+	maxPly = 64
+	// mateThreshold is the smallest score which is considered a forced win.
+	mateThreshold = MateScore - maxPly
+	// How many nodes to search between checks of the time and node limits.
+	searchCheckInterval = 1024
+)
+
+// IsMateScore returns true if the given score from Search represents a forced
+// win or loss.
+func IsMateScore(score int) bool {
+	return score >= mateThreshold || score <= -mateThreshold
+}
+
+// SearchLimits controls how long Search will run for. Zero values mean that
+// there is no limit of that kind. If no limits are given, the search runs
+// until it reaches the maximum depth or is stopped.
+type SearchLimits struct {
+	// Depth is the maximum number of plies to search.
+	Depth int
+	// Nodes is the maximum number of positions to visit.
+	Nodes uint64
+	// MoveTime is the maximum amount of time to spend searching.
+	MoveTime time.Duration
+}
+
+// SearchInfo describes the result of a single iteration of Search.
+type SearchInfo struct {
+	// Depth is the number of plies that were searched.
+	Depth int
+	// Score is the value of the position from the perspective of the player to
+	// move, in centipawns. See IsMateScore.
+	Score int
+	// Nodes is the number of positions visited so far.
+	Nodes uint64
+	// Time is the time elapsed since the search started.
+	Time time.Duration
+	// PV is the principal variation, the sequence of moves that the search
+	// expects to be played.
+	PV []Move
+}
+
+// SearchResult is the outcome of Search.
+type SearchResult struct {
+	SearchInfo
+	// BestMove is the move chosen by the search. It is the first move of the
+	// PV. If the game is over, BestMove is MovePass and the PV is empty.
+	BestMove Move
+}
+
+// Bounds stored in the transposition table.
+const (
+	boundExact = iota + 1
+	boundLower
+	boundUpper
+)
+
+type ttEntry struct {
+	key   uint64
+	move  Move
+	score int32
+	depth int8
+	bound int8
+}
+
+// A Searcher finds the best move in a position using an iterative deepening
+// negamax search with alpha-beta pruning. A Searcher keeps its transposition
+// table between searches, so reusing one for consecutive positions of a game
+// is faster than creating a new one each time. A Searcher must not be used for
+// more than one search at a time, but Stop may be called from any goroutine.
+//
+// Duels are not considered by the search: every capture is assumed to happen
+// without a challenge.
+type Searcher struct {
+	// Evaluator is used to score positions at the leaves of the search.
+	Evaluator Evaluator
+	// Info, if not nil, is called after each iteration of the search
+	// completes.
+	Info func(SearchInfo)
+
+	tt      []ttEntry
+	stopped int32
+
+	// State of the current search
+	limits   SearchLimits
+	start    time.Time
+	nodes    uint64
+	aborted  bool
+	killers  [maxPly][2]Move
+	pv       [maxPly + 1][maxPly + 1]Move
+	pvLength [maxPly + 1]int
+}
+
+// NewSearcher creates a Searcher with a transposition table that holds the
+// given number of entries. A size of 0 disables the transposition table. The
+// Searcher uses a DefaultEvaluator.
+func NewSearcher(ttSize int) *Searcher {
+	return &Searcher{
+		Evaluator: NewDefaultEvaluator(),
+		tt:        make([]ttEntry, ttSize),
+	}
+}
+
+// Search finds the best move for the player to move in the given game, using a
+// new Searcher with a default-sized transposition table.
+func Search(game Game, limits SearchLimits) SearchResult {
+	return NewSearcher(1<<16).Search(game, limits)
+}
+
+// Stop causes a running search to return as soon as possible. The result will
+// come from the last completed iteration.
+func (s *Searcher) Stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+}
+
+// Search finds the best move for the player to move in the given game.
+func (s *Searcher) Search(game Game, limits SearchLimits) SearchResult {
+	atomic.StoreInt32(&s.stopped, 0)
+	s.limits = limits
+	s.start = time.Now()
+	s.nodes = 0
+	s.aborted = false
+	s.killers = [maxPly][2]Move{}
+
+	result := SearchResult{BestMove: MovePass}
+	if game.GameState() != GameInProgress {
+		result.Score = s.terminalScore(&game, 0)
+		return result
+	}
+	// Always have some move to return, even if the first iteration doesn't
+	// complete.
+	if moves := game.GenerateLegalMoves(); len(moves) > 0 {
+		result.BestMove = moves[0]
+		result.PV = []Move{moves[0]}
+	}
+
+	maxDepth := limits.Depth
+	if maxDepth <= 0 || maxDepth > maxPly {
+		maxDepth = maxPly
+	}
+	for depth := 1; depth <= maxDepth; depth++ {
+		score := s.negamax(&game, depth, 0, -MateScore-1, MateScore+1)
+		if s.aborted {
+			break
+		}
+		result.Depth = depth
+		result.Score = score
+		result.PV = make([]Move, s.pvLength[0])
+		copy(result.PV, s.pv[0][:s.pvLength[0]])
+		if len(result.PV) > 0 {
+			result.BestMove = result.PV[0]
+		}
+		result.Nodes = s.nodes
+		result.Time = time.Since(s.start)
+		if s.Info != nil {
+			s.Info(result.SearchInfo)
+		}
+		if IsMateScore(score) {
+			// No point searching deeper once a forced result is known.
+			break
+		}
+		if limits.MoveTime > 0 && result.Time > limits.MoveTime/2 {
+			// The next iteration will almost certainly not finish in time.
+			break
+		}
+	}
+	result.Nodes = s.nodes
+	result.Time = time.Since(s.start)
+	return result
+}
+
+// Returns true if the search should be abandoned.
+func (s *Searcher) checkLimits() bool {
+	if s.aborted {
+		return true
+	}
+	if s.nodes%searchCheckInterval == 0 {
+		if atomic.LoadInt32(&s.stopped) != 0 ||
+			s.limits.MoveTime > 0 && time.Since(s.start) >= s.limits.MoveTime {
+			s.aborted = true
+		}
+	}
+	if s.limits.Nodes > 0 && s.nodes >= s.limits.Nodes {
+		s.aborted = true
+	}
+	return s.aborted
+}
+
+// Returns the score of a finished game from the perspective of the player to
+// move.
+func (s *Searcher) terminalScore(g *Game, ply int) int {
+	switch g.GameState() {
+	case GameOverWhite:
+		if g.toMove == ColorWhite {
+			return MateScore - ply
+		}
+		return -MateScore + ply
+	case GameOverBlack:
+		if g.toMove == ColorBlack {
+			return MateScore - ply
+		}
+		return -MateScore + ply
+	}
+	return 0
+}
+
+// Searches the child position, returning the score from the perspective of
+// the given player, who moved into it. During a king-turn the same player
+// moves twice in a row, so the score is only negated when the player changes.
+func (s *Searcher) searchChild(mover Color, child *Game, depth, ply, alpha, beta int) int {
+	if child.toMove == mover {
+		return s.negamax(child, depth, ply, alpha, beta)
+	}
+	return -s.negamax(child, depth, ply, -beta, -alpha)
+}
+
+func (s *Searcher) negamax(g *Game, depth, ply, alpha, beta int) int {
+	s.pvLength[ply] = 0
+	s.nodes++
+	if s.checkLimits() {
+		return 0
+	}
+	if g.GameState() != GameInProgress {
+		return s.terminalScore(g, ply)
+	}
+	if depth <= 0 || ply >= maxPly {
+		return s.quiesce(g, ply, alpha, beta)
+	}
+
+	// Probe the transposition table
+	var ttMove Move
+	hash := g.Hash()
+	if entry := s.probe(hash); entry != nil {
+		ttMove = entry.move
+		if int(entry.depth) >= depth && ply > 0 {
+			score := scoreFromTT(int(entry.score), ply)
+			switch {
+			case entry.bound == boundExact,
+				entry.bound == boundLower && score >= beta,
+				entry.bound == boundUpper && score <= alpha:
+				return score
+			}
+		}
+	}
+
+	moves := g.GenerateLegalMoves()
+	s.orderMoves(g, moves, ttMove, ply)
+	origAlpha := alpha
+	bestScore := -MateScore - 1
+	bestMove := MovePass
+	for _, move := range moves {
+		mover := g.toMove
+		undo := g.MakeMove(move)
+		score := s.searchChild(mover, g, depth-1, ply+1, alpha, beta)
+		g.UnmakeMove(undo)
+		if s.aborted {
+			return 0
+		}
+		if score > bestScore {
+			bestScore = score
+			bestMove = move
+			if score > alpha {
+				alpha = score
+				s.updatePV(ply, move)
+			}
+		}
+		if alpha >= beta {
+			if !g.isCapture(move) {
+				s.killers[ply][1] = s.killers[ply][0]
+				s.killers[ply][0] = move
+			}
+			break
+		}
+	}
+
+	bound := boundExact
+	if bestScore <= origAlpha {
+		bound = boundUpper
+	} else if bestScore >= beta {
+		bound = boundLower
+	}
+	s.store(hash, bestMove, scoreToTT(bestScore, ply), depth, bound)
+	return bestScore
+}
+
+// Searches only captures until the position is quiet, to avoid misjudging
+// positions in the middle of an exchange.
+func (s *Searcher) quiesce(g *Game, ply, alpha, beta int) int {
+	s.pvLength[ply] = 0
+	standPat := s.Evaluator.Evaluate(g)
+	if standPat >= beta || ply >= maxPly {
+		return standPat
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+	moves := g.GenerateLegalMoves()
+	captures := moves[:0]
+	for _, move := range moves {
+		if g.isCapture(move) {
+			captures = append(captures, move)
+		}
+	}
+	s.orderMoves(g, captures, MovePass, ply)
+	for _, move := range captures {
+		s.nodes++
+		if s.checkLimits() {
+			return 0
+		}
+		mover := g.toMove
+		undo := g.MakeMove(move)
+		var score int
+		if g.GameState() != GameInProgress {
+			score = s.terminalScore(g, ply+1)
+			if g.toMove != mover {
+				score = -score
+			}
+		} else {
+			score = s.searchChild(mover, g, 0, ply+1, alpha, beta)
+		}
+		g.UnmakeMove(undo)
+		if s.aborted {
+			return 0
+		}
+		if score >= beta {
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// Records that the given move is the best at the given ply, followed by the
+// principal variation of the next ply.
+func (s *Searcher) updatePV(ply int, move Move) {
+	s.pv[ply][0] = move
+	n := copy(s.pv[ply][1:], s.pv[ply+1][:s.pvLength[ply+1]])
+	s.pvLength[ply] = n + 1
+}
+
+// Sorts the moves so that the most promising are searched first: the move from
+// the transposition table, then captures of valuable pieces by cheap pieces,
+// then killer moves.
+func (s *Searcher) orderMoves(g *Game, moves []Move, ttMove Move, ply int) {
+	scores := make([]int, len(moves))
+	for i, move := range moves {
+		var score int
+		switch {
+		case move == ttMove:
+			score = 1 << 20
+		case g.isCapture(move):
+			victim, _ := g.board.PieceAt(move.To)
+			attacker, _ := g.board.PieceAt(move.From)
+			score = 1<<16 + 16*pieceTypeValue(victim.Type()) - pieceTypeValue(attacker.Type())/16
+		case ply < maxPly && move == s.killers[ply][0]:
+			score = 1 << 12
+		case ply < maxPly && move == s.killers[ply][1]:
+			score = 1 << 11
+		}
+		if move.Piece != InvalidPiece {
+			score += pieceTypeValue(move.Piece.Type())
+		}
+		scores[i] = score
+	}
+	sort.Stable(movesByScore{moves, scores})
+}
+
+// movesByScore sorts moves in descending order of their scores.
+type movesByScore struct {
+	moves  []Move
+	scores []int
+}
+
+func (m movesByScore) Len() int           { return len(m.moves) }
+func (m movesByScore) Less(i, j int) bool { return m.scores[i] > m.scores[j] }
+func (m movesByScore) Swap(i, j int) {
+	m.moves[i], m.moves[j] = m.moves[j], m.moves[i]
+	m.scores[i], m.scores[j] = m.scores[j], m.scores[i]
+}
+
+func (s *Searcher) probe(hash uint64) *ttEntry {
+	if len(s.tt) == 0 {
+		return nil
+	}
+	entry := &s.tt[hash%uint64(len(s.tt))]
+	if entry.key != hash || entry.bound == 0 {
+		return nil
+	}
+	return entry
+}
+
+func (s *Searcher) store(hash uint64, move Move, score, depth, bound int) {
+	if len(s.tt) == 0 {
+		return
+	}
+	entry := &s.tt[hash%uint64(len(s.tt))]
+	if entry.key == hash && int(entry.depth) > depth {
+		// Keep the deeper result for this position.
+		return
+	}
+	*entry = ttEntry{
+		key:   hash,
+		move:  move,
+		score: int32(score),
+		depth: int8(depth),
+		bound: int8(bound),
+	}
+}
+
+// Mate scores are stored relative to the position in the transposition table,
+// rather than relative to the root of the search.
+func scoreToTT(score, ply int) int {
+	if score >= mateThreshold {
+		return score + ply
+	} else if score <= -mateThreshold {
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score, ply int) int {
+	if score >= mateThreshold {
+		return score - ply
+	} else if score <= -mateThreshold {
+		return score + ply
+	}
+	return score
+}
+
+// isCapture returns true if the given move removes an opponent's piece from
+// the board, ignoring duels.
+func (g *Game) isCapture(move Move) bool {
+	if move.IsPass() || move.IsDrop() {
+		return false
+	}
+	enemies := g.board.colorMask(OtherColor(g.toMove))
+	if move.From == move.To {
+		// Whirlwind attack
+		return dist1Mask[move.From.Address]&enemies != 0
+	}
+	path := move.To.mask()
+	piece, _ := g.board.PieceAt(move.From)
+	if g.pieceRules(ColorIdx(piece.Color()), piece.Type()).rampage {
+		// Elephant rampage
+		path |= betweenMask[move.From.Address][move.To.Address]
+	}
+	if path&enemies != 0 {
+		return true
+	}
+	return piece.Type() == TypePawn && move.To == g.epSquare && move.From.X() != move.To.X()
+}
+
+// pieceTypeValue is the nominal material value of a piece type, in
+// centipawns.
+func pieceTypeValue(t PieceType) int {
+	switch t {
+	case TypePawn:
+		return 100
+	case TypeKnight, TypeBishop:
+		return 300
+	case TypeRook:
+		return 500
+	case TypeQueen:
+		return 900
+	}
+	return 0
+}
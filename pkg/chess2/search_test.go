@@ -0,0 +1,84 @@
+package chess2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	cases := map[string]struct {
+		epd   string
+		depth int
+		best  string
+		mate  bool
+	}{
+		"checkmate in one": {
+			epd:   "6k1/5ppp/8/8/8/8/8/R3K3 w - - 0 1 cc 33",
+			depth: 2,
+			best:  "a1a8",
+			mate:  true,
+		},
+		"capture hanging queen": {
+			epd:   "4k3/8/8/3q4/8/8/3R4/4K3 w - - 0 1 cc 33",
+			depth: 2,
+			best:  "d2d5",
+		},
+		"midline victory": {
+			epd:   "4k3/8/8/8/4K3/8/8/8 w - - 0 1 cc 33",
+			depth: 1,
+			mate:  true,
+		},
+		"midline victory after king-turn": {
+			epd:   "3k4/8/8/8/3KK3/8/8/8 w - - 0 1 kc 33",
+			depth: 3,
+			mate:  true,
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			game, err := ParseEpd(config.epd)
+			require.NoError(t, err, "EPD: %s  Name: %s", config.epd, name)
+			result := NewSearcher(1024).Search(game, SearchLimits{Depth: config.depth})
+			if config.best != "" {
+				assert.Equal(t, config.best, result.BestMove.String(), "Case: %s", name)
+			}
+			assert.Equal(t, config.mate, IsMateScore(result.Score), "Case: %s  Score: %d", name, result.Score)
+			if config.mate {
+				assert.True(t, result.Score > 0, "Case: %s  Score: %d", name, result.Score)
+			}
+			// The PV must be a legal sequence of moves starting with the best
+			// move.
+			require.NotEmpty(t, result.PV, "Case: %s", name)
+			assert.Equal(t, result.BestMove, result.PV[0], "Case: %s", name)
+			for _, move := range result.PV {
+				require.NoError(t, game.ValidateLegalMove(move), "Case: %s  PV: %v", name, result.PV)
+				game = game.ApplyMove(move)
+			}
+		})
+	}
+}
+
+func TestSearchLimits(t *testing.T) {
+	game := GameFromArmies(ArmyClassic, ArmyAnimals)
+	var infos []SearchInfo
+	searcher := NewSearcher(1 << 12)
+	searcher.Info = func(info SearchInfo) {
+		infos = append(infos, info)
+	}
+	result := searcher.Search(game, SearchLimits{Nodes: 5000})
+	assert.True(t, result.Nodes <= 5000, "Nodes: %d", result.Nodes)
+	require.NotEmpty(t, infos)
+	assert.Equal(t, infos[len(infos)-1].Depth, result.Depth)
+	assert.NoError(t, game.ValidateLegalMove(result.BestMove))
+}
+
+func TestSearchGameOver(t *testing.T) {
+	game, err := ParseEpd("4k3/8/8/4K3/8/8/8/8 b - - 0 1 cc 33")
+	require.NoError(t, err)
+	result := Search(game, SearchLimits{Depth: 3})
+	assert.Equal(t, MovePass, result.BestMove)
+	assert.Empty(t, result.PV)
+	assert.Equal(t, -MateScore, result.Score)
+}
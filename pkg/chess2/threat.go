@@ -0,0 +1,56 @@
+package chess2
+
+// IsInCheck determines if the given player is currently in check, regardless of
+// if they are the player to move. If the game is over due to checkmate, this
+// method will return true for the losing player.
+func (g *Game) IsInCheck(color Color) bool {
+	kingMask := g.board.colorMask(color) & g.board.pieceMask(TypeKing)
+	return g.threatMask(OtherColor(color))&kingMask != 0
+}
+
+// threatMask returns the mask of squares threatened by the pieces of the given
+// color. An occupied square is threatened if there is a pseudo-legal move for
+// the color which captures the piece on it; an empty square is threatened if a
+// piece could move there and capture it if it were occupied. Like attackMask,
+// the result may include squares which are not actually threatened, except
+// that Elephants are handled exactly.
+func (g *Game) threatMask(color Color) uint64 {
+	pieces := g.board.colorMask(color)
+	army := g.armyRules(ColorIdx(color))
+	if len(army.rampagers) == 0 {
+		return g.fullAttackMask(pieces)
+	}
+	elephants := maskEmpty
+	for _, t := range army.rampagers {
+		elephants |= pieces & g.board.pieceMask(t)
+	}
+	result := g.fullAttackMask(pieces &^ elephants)
+	eachSquareInMask(elephants, func(from Square) {
+		result |= g.rampageThreatMask(from)
+	})
+	return result
+}
+
+// rampageThreatMask returns the squares threatened by the Elephant on the
+// given square. An Elephant captures everything along its path, so a rampage
+// which is blocked by a noncapturable piece, which stops short illegally, or
+// which would trample its own king threatens nothing, even though the squares
+// are in the Elephant's attackMask.
+func (g *Game) rampageThreatMask(from Square) uint64 {
+	piece, _ := g.board.PieceAt(from)
+	// Evaluate the rampage as though it were the Elephant's turn.
+	probe := *g
+	probe.toMove = piece.Color()
+	probe.kingTurn = false
+	probe.gameState = GameInProgress
+	probe.endReason = EndReasonNone
+	probe.gameStateStale = false
+	occupied := g.board.occupiedMask()
+	var result uint64
+	eachSquareInMask(g.attackMask(from), func(to Square) {
+		if probe.ValidatePseudoLegalMove(Move{From: from, To: to}) == nil {
+			result |= to.mask() | betweenMask[from.Address][to.Address]&occupied
+		}
+	})
+	return result
+}
@@ -0,0 +1,176 @@
+package chess2
+
+// Zobrist keys are generated from a fixed seed so that position hashes are
+// stable between runs and between builds of the package.
+const zobristSeed = uint64(0x9e3779b97f4a7c15)
+
+var (
+	// One key per color, piece type, and square.
+	zobristPieces [2][6][64]uint64
+	// One key per square, XORed in for each square in castlingRights.
+	zobristCastling [64]uint64
+	// One key per en passant square. InvalidSquare has no key.
+	zobristEpSquare [64]uint64
+	// One key per color and army. ArmyNone has no key.
+	zobristArmies [2][8]uint64
+	// One key per color and stone count.
+	zobristStones [2][MaxStonesLimit + 1]uint64
+	// One key per limit on stones. DefaultMaxStones has no key.
+	zobristMaxStones [MaxStonesLimit + 1]uint64
+	// Keys for the side to move being black and for a king-turn.
+	zobristBlackToMove uint64
+	zobristKingTurn    uint64
+	// One key per color, piece type, and number of pieces in the reserve. An
+	// empty reserve has no key.
+	zobristReserves [2][6][32]uint64
+)
+
+func init() {
+	state := zobristSeed
+	// xorshift64* is small, fast, and good enough for hash keys.
+	next := func() uint64 {
+		state ^= state >> 12
+		state ^= state << 25
+		state ^= state >> 27
+		return state * 0x2545f4914f6cdd1d
+	}
+	for c := range zobristPieces {
+		for t := range zobristPieces[c] {
+			for sq := range zobristPieces[c][t] {
+				zobristPieces[c][t][sq] = next()
+			}
+		}
+	}
+	for sq := range zobristCastling {
+		zobristCastling[sq] = next()
+	}
+	for sq := range zobristEpSquare {
+		zobristEpSquare[sq] = next()
+	}
+	for c := range zobristArmies {
+		for a := 1; a < len(zobristArmies[c]); a++ {
+			zobristArmies[c][a] = next()
+		}
+	}
+	for c := range zobristStones {
+		for n := 0; n <= DefaultMaxStones; n++ {
+			zobristStones[c][n] = next()
+		}
+	}
+	zobristBlackToMove = next()
+	zobristKingTurn = next()
+	// Reserve keys are generated last so that they don't change the keys
+	// above.
+	for c := range zobristReserves {
+		for t := range zobristReserves[c] {
+			for n := 1; n < len(zobristReserves[c][t]); n++ {
+				zobristReserves[c][t][n] = next()
+			}
+		}
+	}
+	// Likewise for stone counts above the default limit, and the limits.
+	for c := range zobristStones {
+		for n := DefaultMaxStones + 1; n < len(zobristStones[c]); n++ {
+			zobristStones[c][n] = next()
+		}
+	}
+	for n := range zobristMaxStones {
+		if n != DefaultMaxStones {
+			zobristMaxStones[n] = next()
+		}
+	}
+}
+
+// zobristPiece returns the key for the given piece standing on the given
+// square. The army of the piece is not part of the key.
+func zobristPiece(p Piece, sq Square) uint64 {
+	return zobristPieces[ColorIdx(p.Color())][pieceTypeIdx(p.Type())][sq.Address]
+}
+
+// zobristArmy returns the key for the given army playing the given color.
+// Custom armies don't have keys in the table, so their keys are made by mixing
+// the Army value instead.
+func zobristArmy(colorIdx int, army Army) uint64 {
+	if army >= 0 && army < 0x100 {
+		return zobristArmies[colorIdx][army>>4&7]
+	}
+	// The finalizer of splitmix64.
+	key := zobristSeed ^ uint64(army)<<1 ^ uint64(colorIdx)
+	key = (key ^ key>>30) * 0xbf58476d1ce4e5b9
+	key = (key ^ key>>27) * 0x94d049bb133111eb
+	return key ^ key>>31
+}
+
+// zobristStoneCount returns the key for a player having the given number of
+// stones.
+func zobristStoneCount(colorIdx int, stones int) uint64 {
+	if stones < 0 || stones >= len(zobristStones[colorIdx]) {
+		return 0
+	}
+	return zobristStones[colorIdx][stones]
+}
+
+// zobristMaxStoneCount returns the key for a game with the given limit on
+// stones.
+func zobristMaxStoneCount(max int) uint64 {
+	if max < 0 || max >= len(zobristMaxStones) {
+		return 0
+	}
+	return zobristMaxStones[max]
+}
+
+// zobristReserveCount returns the key for a player having the given number of
+// pieces of the type with the given index in their reserve.
+func zobristReserveCount(colorIdx int, typeIdx int, count int) uint64 {
+	if count < 0 || count >= len(zobristReserves[colorIdx][typeIdx]) {
+		return 0
+	}
+	return zobristReserves[colorIdx][typeIdx][count]
+}
+
+// stateHash computes the portion of the position key which is not covered by
+// the Board: side to move, king-turn, castling rights, en passant square,
+// armies, stones and the limit on them, and reserves.
+func (g *Game) stateHash() uint64 {
+	var hash uint64
+	if g.toMove == ColorBlack {
+		hash ^= zobristBlackToMove
+	}
+	if g.kingTurn {
+		hash ^= zobristKingTurn
+	}
+	eachSquareInMask(g.castlingRights, func(sq Square) {
+		hash ^= zobristCastling[sq.Address]
+	})
+	if g.epSquare != InvalidSquare {
+		hash ^= zobristEpSquare[g.epSquare.Address]
+	}
+	for i := 0; i < 2; i++ {
+		hash ^= zobristArmy(i, g.armies[i])
+		hash ^= zobristStoneCount(i, g.stones[i])
+		for t, count := range g.reserves[i] {
+			hash ^= zobristReserveCount(i, t, count)
+		}
+	}
+	hash ^= zobristMaxStoneCount(g.maxStones)
+	return hash
+}
+
+// computeHash calculates the position key of the board from scratch.
+func (b *Board) computeHash() uint64 {
+	var hash uint64
+	for c := 0; c < 2; c++ {
+		for t := range b.pieces {
+			eachSquareInMask(b.colors[c]&b.pieces[t], func(sq Square) {
+				hash ^= zobristPieces[c][t][sq.Address]
+			})
+		}
+	}
+	return hash
+}
+
+// computeHash calculates the position key of the game from scratch. It should
+// always be equal to Hash.
+func (g *Game) computeHash() uint64 {
+	return g.board.computeHash() ^ g.stateHash()
+}
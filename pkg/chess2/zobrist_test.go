@@ -0,0 +1,71 @@
+package chess2
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	armies := []Army{ArmyClassic, ArmyNemesis, ArmyEmpowered, ArmyReaper, ArmyTwoKings, ArmyAnimals}
+	for _, white := range armies {
+		for _, black := range armies {
+			game := GameFromArmies(white, black)
+			require.Equal(t, game.computeHash(), game.Hash(), "Armies: %v %v", white, black)
+			for ply := 0; ply < 40 && game.GameState() == GameInProgress; ply++ {
+				moves := game.GenerateLegalMoves()
+				move := moves[rng.Intn(len(moves))]
+				if duels := game.GenerateDuels(move); len(duels) > 0 {
+					move = duels[rng.Intn(len(duels))]
+				}
+				game = game.ApplyMove(move)
+				require.Equal(t, game.computeHash(), game.Hash(), "EPD: %s", EncodeEpd(game))
+			}
+		}
+	}
+}
+
+func TestHashTransposition(t *testing.T) {
+	start := GameFromArmies(ArmyClassic, ArmyClassic)
+	a, b := start, start
+	for _, uci := range []string{"g1f3", "g8f6", "b1c3"} {
+		move, err := ParseUci(uci)
+		require.NoError(t, err)
+		a = a.ApplyMove(move)
+	}
+	for _, uci := range []string{"b1c3", "g8f6", "g1f3"} {
+		move, err := ParseUci(uci)
+		require.NoError(t, err)
+		b = b.ApplyMove(move)
+	}
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, start.Hash(), a.Hash())
+}
+
+func TestHashDistinguishesState(t *testing.T) {
+	cases := []string{
+		"4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 33",
+		"4k3/8/8/8/8/8/8/4K3 b - - 0 1 cc 33",
+		"4k3/8/8/8/8/8/8/4K3 w - - 0 1 cc 32",
+		"4k3/8/8/8/8/8/8/4K3 w - - 0 1 ca 33",
+		"4k3/8/8/8/8/8/8/4K3 w - e6 0 1 cc 33",
+		"4k3/8/8/8/8/8/8/4K3 K - - 0 1 kc 33",
+		"4k3/8/8/8/8/8/8/4K3 w K - 0 1 kc 33",
+	}
+	seen := make(map[uint64]string)
+	for _, epd := range cases {
+		game, err := ParseEpd(epd)
+		require.NoError(t, err, "EPD: %s", epd)
+		other, found := seen[game.Hash()]
+		assert.False(t, found, "EPD %s collides with %s", epd, other)
+		seen[game.Hash()] = epd
+		// The move clocks do not contribute to the key.
+		epdClocks := epd[:len(epd)-9] + "7 9" + epd[len(epd)-6:]
+		clocks, err := ParseEpd(epdClocks)
+		require.NoError(t, err, "EPD: %s", epdClocks)
+		assert.Equal(t, game.Hash(), clocks.Hash(), "EPD: %s", epdClocks)
+	}
+}
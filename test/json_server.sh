@@ -10,6 +10,15 @@ function test {
 }
 
 test '{' '{"error":"Invalid JSON input"}'
-test '{ "armies": "kk" }' '{"epd":"rnbkkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKKBNR w KQkq - 0 1 kk 33","game_over":false,"legal_moves":["a2a3","a2a4","b1a3","b1c3","b2b3","b2b4","c2c3","c2c4","d2d3","d2d4","e2e3","e2e4","f2f3","f2f4","g1f3","g1h3","g2g3","g2g4","h2h3","h2h4"],"winner":null}'
-test '{ "epd": "rnbkkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKKBNR w KQkq - 0 1 kk 33", "move": "d2d4" }' '{"available_duels":["d2d4"],"epd":"rnbkkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBKKBNR K KQkq d3 0 1 kk 33","game_over":false,"legal_moves":["0000","d1d2","e1d2"],"winner":null}'
+test '{ "armies": "kk" }' '{"epd":"rnbkkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKKBNR w KQkq - 0 1 kk 33","game_over":false,"legal_moves":["a2a3","a2a4","b1a3","b1c3","b2b3","b2b4","c2c3","c2c4","d2d3","d2d4","e2e3","e2e4","f2f3","f2f4","g1f3","g1h3","g2g3","g2g4","h2h3","h2h4"],"reason":null,"winner":null}'
+test '{ "armies": "kc", "chess960": 0 }' '{"epd":"bbqnnrkr/pppppppp/8/8/8/8/PPPPPPPP/BBKNNRKR w KFkf - 0 1 kc 33","game_over":false,"legal_moves":["a2a3","a2a4","b2b3","b2b4","c2c3","c2c4","d1c3","d1e3","d2d3","d2d4","e1d3","e1f3","e2e3","e2e4","f2f3","f2f4","g2g3","g2g4","h2h3","h2h4"],"reason":null,"winner":null}'
+test '{ "armies": "cc", "stones": "15/9" }' '{"epd":"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 15/9","game_over":false,"legal_moves":["a2a3","a2a4","b1a3","b1c3","b2b3","b2b4","c2c3","c2c4","d2d3","d2d4","e2e3","e2e4","f2f3","f2f4","g1f3","g1h3","g2g3","g2g4","h2h3","h2h4"],"reason":null,"winner":null}'
+test '{ "armies": "cc", "stones": "77" }' '{"error":"Invalid stones"}'
+test '{ "epd": "rnbkkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKKBNR w KQkq - 0 1 kk 33", "move": "d2d4" }' '{"available_duels":["d2d4"],"epd":"rnbkkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBKKBNR K KQkq d3 0 1 kk 33","game_over":false,"legal_moves":["0000","d1d2","e1d2"],"reason":null,"winner":null}'
 test '{ "epd": "rnbqkbnr/pppp1ppp/8/4p3/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 1 cc 11", "move": "d4f5" }' '{"error":"illegal move: unreachable square"}'
+test '{ "epd": "4k3/8/8/8/8/8/8/4K3 w - - 49 1 cc 33", "move": "e1e2" }' '{"available_duels":["e1e2"],"epd":"4k3/8/8/8/8/8/4K3/8 b - - 50 1 cc 33","game_over":true,"legal_moves":[],"reason":"fifty_move","winner":"draw"}'
+test '{ "armies": "cc", "action": "offer_draw", "color": "white" }' '{"draw_offer":"white","epd":"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33","game_over":false,"legal_moves":["a2a3","a2a4","b1a3","b1c3","b2b3","b2b4","c2c3","c2c4","d2d3","d2d4","e2e3","e2e4","f2f3","f2f4","g1f3","g1h3","g2g3","g2g4","h2h3","h2h4"],"reason":null,"winner":null}'
+test '{ "armies": "cc", "action": "accept_draw", "color": "black", "draw_offer": "white" }' '{"draw_offer":null,"epd":"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 cc 33","game_over":true,"legal_moves":[],"reason":"agreement","winner":"draw"}'
+test '{ "armies": "cc", "action": "resign", "color": "black" }' '{"error":"action out of turn"}'
+test '{ "armies": "cc", "moves": ["g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"] }' '{"epd":"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 8 5 cc 33","game_over":true,"legal_moves":[],"reason":"repetition","winner":"draw"}'
+test '{ "armies": "cc", "moves": ["e2e5"] }' '{"error":"illegal move in history: e2e5: unreachable square"}'